@@ -5,6 +5,7 @@ PLATFORM=${PLATFORM:-linux/amd64}
 
 RUN_IMAGE=jimjuniorb/buildpacks-run:latest
 BUILD_IMAGE=jimjuniorb/buildpacks-build:latest
+BUILDER_IMAGE=${MIRA_BUILDER_IMAGE:-cranecloudplatform/mira-builder:latest}
 
 # Build the base images
 echo "Building base images for Crane Cloud Platform Builder..."
@@ -19,10 +20,13 @@ docker buildx build --platform=${PLATFORM} \
   -t "${RUN_IMAGE}" \
   ./run --push
 
+echo "BUILDING ${BUILDER_IMAGE}..."
+pack builder create "${BUILDER_IMAGE}" --config ./builder.toml --publish
+
 echo
-echo "BASE IMAGES BUILT!"
+echo "IMAGES BUILT!"
 echo
 echo "Images:"
-for IMAGE in "${BUILD_IMAGE}" "${RUN_IMAGE}"; do
+for IMAGE in "${BUILD_IMAGE}" "${RUN_IMAGE}" "${BUILDER_IMAGE}"; do
   echo "    ${IMAGE}"
 done
\ No newline at end of file
@@ -2,9 +2,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"mira/cmd/api/services"
@@ -37,13 +41,14 @@ import (
 
 func StartServer(port string) {
 	// Load environment variables from .env file
+	serverConfig := config.NewServerConfig()
 
 	// Initialize NATS client
 	natsClient, err := common.NewNATSClient()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create NATS client: %v", err))
 	}
-	defer natsClient.Close()
+	natsClient.SetSBOMRetention(serverConfig.SBOMRetention)
 
 	// Initialize MongoDB
 	mongoConfig := config.NewMongoDBConfig()
@@ -52,8 +57,6 @@ func StartServer(port string) {
 		log.Printf("Warning: Failed to connect to MongoDB: %v", err)
 		log.Printf("MongoDB features will be disabled")
 		mongoConfig = nil
-	} else {
-		defer mongoConfig.Disconnect()
 	}
 
 	app := fiber.New(fiber.Config{
@@ -63,15 +66,16 @@ func StartServer(port string) {
 		ReadTimeout:  5 * time.Minute, // 5 minute read timeout
 		WriteTimeout: 5 * time.Minute, // 5 minute write timeout
 		IdleTimeout:  5 * time.Minute, // 5 minute idle timeout
+		BodyLimit:    serverConfig.MaxUploadSizeMB << 20,
 	})
 
 	// Enable CORS with proper configuration for WebSocket support
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "*",
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-Requested-With,Upgrade,Connection",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-Requested-With,Upgrade,Connection,Idempotency-Key",
 		AllowCredentials: false,
-		ExposeHeaders:    "Content-Length",
+		ExposeHeaders:    "Content-Length,Idempotent-Replayed",
 		MaxAge:           12 * 3600, // 12 hours
 	}))
 
@@ -114,17 +118,48 @@ func StartServer(port string) {
 	app.Get("/apidocs/*", fiberSwagger.WrapHandler)
 
 	// Setup all API routes
-	SetupRoutes(app, natsClient, mongoConfig)
+	closeLogStreams := SetupRoutes(app, natsClient, mongoConfig, serverConfig)
 
 	// Start MongoDB log subscriber if MongoDB is available
 	if mongoConfig != nil && mongoConfig.Client != nil {
 		mongoService := services.NewMongoLogService(mongoConfig)
 		startMongoDBLogSubscriber(natsClient, mongoService)
 		startMongoDBBuildStatusSubscriber(natsClient, mongoService)
+		startMongoDBSBOMSubscriber(natsClient, services.NewSBOMService(mongoConfig, natsClient))
 	}
 
-	app.Listen(":" + port)
+	shutdown, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(":" + port)
+	}()
 	fmt.Println("Server started on port:", port)
+
+	select {
+	case err := <-listenErr:
+		log.Printf("Server stopped: %v", err)
+	case <-shutdown.Done():
+		log.Printf("Shutdown requested, draining connections")
+
+		// Log streams never end on their own, close them before waiting for in-flight requests
+		closeLogStreams()
+		if err := app.ShutdownWithTimeout(serverConfig.ShutdownTimeout); err != nil {
+			log.Printf("Failed to shut down server cleanly: %v", err)
+		}
+	}
+
+	// NATS goes first so that pending logs and statuses still reach MongoDB
+	if err := natsClient.Drain(serverConfig.NATSDrainTimeout); err != nil {
+		log.Printf("Failed to drain NATS connection: %v", err)
+	}
+	if mongoConfig != nil {
+		if err := mongoConfig.Disconnect(); err != nil {
+			log.Printf("Failed to disconnect from MongoDB: %v", err)
+		}
+	}
+	log.Printf("Server shut down")
 }
 
 // startMongoDBLogSubscriber starts listening to NATS logs and saving them to MongoDB
@@ -169,18 +204,11 @@ func startMongoDBBuildStatusSubscriber(natsClient *common.NATSClient, mongoServi
 			return
 		}
 
-		// Save build status with project_id and app_name from the build status
-		err := mongoService.SaveBuildStatus(
-			buildStatus.BuildID,
-			buildStatus.ProjectID,
-			buildStatus.AppName,
-			buildStatus.Status,
-			buildStatus.StartedAt,
-			buildStatus.CompletedAt,
-			buildStatus.Error,
-			buildStatus.ImageName,
-		)
-		if err != nil {
+		err := mongoService.SaveBuildStatus(&buildStatus)
+		var transitionErr *services.InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			log.Printf("Rejected build status: %v", err)
+		} else if err != nil {
 			log.Printf("Failed to save build status to MongoDB: %v", err)
 		} else {
 			log.Printf("✅ Saved build status to MongoDB for build %s: %s (Project: %s, App: %s)",
@@ -193,3 +221,20 @@ func startMongoDBBuildStatusSubscriber(natsClient *common.NATSClient, mongoServi
 		log.Printf("Started MongoDB build status subscriber on subject: %s", subject)
 	}
 }
+
+// startMongoDBSBOMSubscriber starts listening to stored build SBOMs and indexing their packages in MongoDB
+func startMongoDBSBOMSubscriber(natsClient *common.NATSClient, sbomService *services.SBOMService) {
+	_, err := natsClient.SubscribeToBuildSBOMs(func(sbom *common.BuildSBOM) {
+		count, err := sbomService.Index(sbom)
+		if err != nil {
+			log.Printf("Failed to index SBOM of build %s: %v", sbom.BuildID, err)
+			return
+		}
+		log.Printf("✅ Indexed %d packages from the SBOM of build %s", count, sbom.BuildID)
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to build SBOMs: %v", err)
+	} else {
+		log.Printf("Started MongoDB SBOM subscriber on subject: %s", common.BuildSBOMSubject("*"))
+	}
+}
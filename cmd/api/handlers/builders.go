@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"mira/cmd/api/models"
+	"mira/cmd/api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BuilderHandler reports on the image builders serving the build queue
+type BuilderHandler struct {
+	builderRegistry *services.BuilderRegistry
+}
+
+// NewBuilderHandler creates a new builder handler
+func NewBuilderHandler(builderRegistry *services.BuilderRegistry) *BuilderHandler {
+	return &BuilderHandler{
+		builderRegistry: builderRegistry,
+	}
+}
+
+// GetBuilders lists live image builders and their current load
+// @Summary Get image builders
+// @Description Lists image builders that reported recently with their running and queued builds
+// @Tags builders
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.BuildersResponse "Image builders retrieved successfully"
+// @Router /builders [get]
+func (h *BuilderHandler) GetBuilders(c *fiber.Ctx) error {
+	response := models.BuildersResponse{
+		Builders: []models.BuilderLoadResponse{},
+	}
+
+	for _, load := range h.builderRegistry.Builders() {
+		response.Builders = append(response.Builders, models.BuilderLoadResponse{
+			BuilderID:     load.BuilderID,
+			Active:        load.Active,
+			Queued:        load.Queued,
+			MaxConcurrent: load.MaxConcurrent,
+			QueueSize:     load.QueueSize,
+			LastSeen:      load.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+		})
+		response.Active += load.Active
+		response.Queued += load.Queued
+		response.MaxConcurrent += load.MaxConcurrent
+	}
+	response.Count = len(response.Builders)
+
+	return c.JSON(response)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"mira/cmd/api/models"
+	"mira/cmd/api/schemas"
+	"mira/cmd/api/services"
+	common "mira/cmd/common"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// BuildHandler handles operations on individual builds
+type BuildHandler struct {
+	natsClient        *common.NATSClient
+	mongoService      *services.MongoLogService
+	buildRequests     *services.BuildRequestService
+	queueService      *services.QueueService
+	validationService *services.ValidationService
+	registries        *services.RegistryService
+	policies          *services.SecurityPolicyService
+}
+
+// NewBuildHandler creates a new build handler
+func NewBuildHandler(natsClient *common.NATSClient, mongoService *services.MongoLogService, buildRequests *services.BuildRequestService, queueService *services.QueueService, registries *services.RegistryService, policies *services.SecurityPolicyService) *BuildHandler {
+	return &BuildHandler{
+		natsClient:        natsClient,
+		mongoService:      mongoService,
+		buildRequests:     buildRequests,
+		queueService:      queueService,
+		validationService: services.NewValidationService(),
+		registries:        registries,
+		policies:          policies,
+	}
+}
+
+// CancelBuild requests cancellation of a queued or running build
+// @Summary Cancel a build
+// @Description Stops a queued or running build. The build ends with status "cancelled" and a completion message is sent on its log stream. Requires a Crane Cloud access token of the project of the build in the Authorization header.
+// @Tags builds
+// @Accept json
+// @Produce json
+// @Param buildId path string true "Build ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Security ApiKeyAuth
+// @Success 202 {object} models.BuildCancelResponse "Build cancellation requested"
+// @Failure 400 {object} models.ErrorResponse "Build ID is required"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 404 {object} models.ErrorResponse "Build not found"
+// @Failure 409 {object} models.ErrorResponse "Build already finished"
+// @Failure 500 {object} models.ErrorResponse "Failed to request cancellation"
+// @Router /builds/{buildId} [delete]
+// @Router /builds/{buildId}/cancel [post]
+func (h *BuildHandler) CancelBuild(c *fiber.Ctx) error {
+	buildID := c.Params("buildId")
+	if buildID == "" {
+		return c.Status(400).JSON(models.ErrorResponse{
+			Error: "Build ID is required",
+		})
+	}
+
+	accessToken := bearerToken(c)
+	if accessToken == "" {
+		return missingAccessToken(c)
+	}
+
+	// Only members of the project of the build may cancel it
+	if h.mongoService == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+	build, err := h.mongoService.GetBuildByID(buildID)
+	if err != nil {
+		log.Printf("Failed to get build %s: %v", buildID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to retrieve build",
+		})
+	}
+	if build == nil {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Build not found",
+		})
+	}
+	if err := h.validationService.ValidateProjectAccess(c.Context(), build.ProjectID, accessToken); err != nil {
+		return projectAccessFailed(c, build.ProjectID, err)
+	}
+
+	// Builds that already reached a terminal state cannot be cancelled
+	if common.IsTerminalBuildStatus(build.Status) {
+		return c.Status(409).JSON(models.ErrorResponse{
+			Error: "Build already " + build.Status,
+		})
+	}
+
+	err = h.natsClient.PublishBuildCancel(&common.BuildCancelRequest{
+		BuildID:     buildID,
+		Reason:      "cancelled by user",
+		RequestedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish cancellation for build %s: %v", buildID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to request cancellation",
+		})
+	}
+
+	return c.Status(202).JSON(models.BuildCancelResponse{
+		Message: "Build cancellation requested",
+		Data: models.BuildCancelData{
+			BuildID: buildID,
+		},
+	})
+}
+
+// RetryBuild queues a new build from the stored request of a finished build
+// @Summary Retry a build
+// @Description Rebuilds a finished build from its stored request. Credentials are not stored and must be supplied again, as must env values when no encryption key is configured. The new build links to the original one through parent_build_id. Builds of an uploaded archive can be retried until the archive expires after MIRA_BLOB_TTL.
+// @Tags builds
+// @Accept json
+// @Produce json
+// @Param buildId path string true "Build ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param request body schemas.RetryBuildRequest true "Credentials for the rebuild"
+// @Success 200 {object} models.BuildResponse "Rebuild started"
+// @Failure 400 {object} models.ErrorResponse "Invalid request"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 404 {object} models.ErrorResponse "Build or stored request not found"
+// @Failure 409 {object} models.ErrorResponse "Build has not finished"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /builds/{buildId}/retry [post]
+func (h *BuildHandler) RetryBuild(c *fiber.Ctx) error {
+	buildID := c.Params("buildId")
+	if buildID == "" {
+		return c.Status(400).JSON(models.ErrorResponse{
+			Error: "Build ID is required",
+		})
+	}
+
+	if h.mongoService == nil || h.buildRequests == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	build, err := h.mongoService.GetBuildRecord(buildID)
+	if err != nil {
+		log.Printf("Failed to get build %s: %v", buildID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to retrieve build",
+		})
+	}
+	if build == nil {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Build not found",
+		})
+	}
+	if build.Request == nil {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Build has no stored request to retry",
+		})
+	}
+	if !common.IsTerminalBuildStatus(build.Status) {
+		return c.Status(409).JSON(models.ErrorResponse{
+			Error: "Build is still " + build.Status,
+		})
+	}
+
+	var req schemas.RetryBuildRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+	}
+	if validationErrors := schemas.ValidateRetryBuildRequest(&req); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":      "Validation failed",
+			"validation": validationErrors,
+		})
+	}
+
+	// The rebuild is pushed with the registry of the project, whatever its mode
+	projectID := build.Request.ProjectID
+	if err := h.validationService.ValidateProjectAccess(c.Context(), projectID, req.AccessToken); err != nil {
+		return projectAccessFailed(c, projectID, err)
+	}
+
+	buildReq, missingEnv, err := h.buildRequests.Rebuild(build.Request, uuid.New().String(), req.AccessToken, req.GitPassword, req.Env)
+	if err != nil {
+		log.Printf("Failed to rebuild request of build %s: %v", buildID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to restore build request",
+		})
+	}
+	if len(missingEnv) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Env values were not stored and must be supplied again",
+			"details": strings.Join(missingEnv, ", "),
+		})
+	}
+	if req.Priority != "" {
+		buildReq.Priority = req.Priority
+	}
+	if req.Mode != "" {
+		buildReq.Spec.Mode = req.Mode
+	}
+
+	// Validate app name with CraneCloud backend, unless the rebuild is not deployed
+	if common.BuildModeDeploys(buildReq.Spec.Mode) {
+		if err := h.validationService.ValidateAppName(buildReq.Name, buildReq.Spec.ProjectID, buildReq.Spec.AccessToken); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "App name validation failed",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	host := string(c.Context().URI().Host())
+	if host == "" {
+		host = "localhost:3000"
+	}
+
+	if err := projectRegistry(h.registries, buildReq); err != nil {
+		log.Printf("Failed to load registry of project %s: %v", buildReq.Spec.ProjectID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load project registry",
+			"details": err.Error(),
+		})
+	}
+
+	if err := projectSecurityPolicy(h.policies, buildReq); err != nil {
+		log.Printf("Failed to load security policy of project %s: %v", buildReq.Spec.ProjectID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load project security policy",
+			"details": err.Error(),
+		})
+	}
+
+	if err := queueBuildRequest(h.natsClient, buildReq); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to queue build request",
+			"details": err.Error(),
+		})
+	}
+
+	// Only queued rebuilds are recorded, so a failed retry leaves no build behind
+	if err := h.buildRequests.Save(buildReq, buildID); err != nil {
+		log.Printf("Failed to store build request %s: %v", buildReq.ID, err)
+	}
+
+	data := buildStartedData(host, buildReq)
+	data["parent_build_id"] = buildID
+	return c.JSON(fiber.Map{
+		"message": "Rebuild started",
+		"data":    data,
+	})
+}
+
+// GetQueuePosition reports where a build is in the build queue
+// @Summary Get build queue position
+// @Description Returns the position of a build in the build queue, the number of active builders, and start and finish estimates based on past builds of the same app and framework
+// @Tags builds
+// @Accept json
+// @Produce json
+// @Param buildId path string true "Build ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Success 200 {object} models.BuildQueueResponse "Queue position retrieved successfully"
+// @Failure 400 {object} models.ErrorResponse "Build ID is required"
+// @Failure 404 {object} models.ErrorResponse "Build not found"
+// @Failure 500 {object} models.ErrorResponse "Failed to look up the build queue"
+// @Router /builds/{buildId}/queue [get]
+func (h *BuildHandler) GetQueuePosition(c *fiber.Ctx) error {
+	buildID := c.Params("buildId")
+	if buildID == "" {
+		return c.Status(400).JSON(models.ErrorResponse{
+			Error: "Build ID is required",
+		})
+	}
+
+	info, err := h.queueService.QueueInfo(buildID)
+	if errors.Is(err, services.ErrBuildNotQueued) {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Build not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to get queue position for build %s: %v", buildID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to look up the build queue",
+		})
+	}
+
+	return c.JSON(info)
+}
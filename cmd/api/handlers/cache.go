@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"mira/cmd/api/models"
+	"mira/cmd/api/services"
+	common "mira/cmd/common"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cacheReplyWait is how long the API collects build cache replies from the image builders
+const cacheReplyWait = 3 * time.Second
+
+// CacheHandler inspects and clears the build caches image builders keep per app
+type CacheHandler struct {
+	natsClient *common.NATSClient
+	registries *services.RegistryService
+}
+
+// NewCacheHandler creates a new cache handler
+func NewCacheHandler(natsClient *common.NATSClient, registries *services.RegistryService) *CacheHandler {
+	return &CacheHandler{
+		natsClient: natsClient,
+		registries: registries,
+	}
+}
+
+// GetBuildCache describes the build cache of an app
+// @Summary Get the build cache of an app
+// @Description Asks every image builder about the build cache of an app. Volume caches are kept per builder, image caches are shared through the registry.
+// @Tags cache
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param appName path string true "App name" example("my-app")
+// @Security ApiKeyAuth
+// @Success 200 {object} models.BuildCacheResponse "Build cache retrieved successfully"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/apps/{appName}/cache [get]
+func (h *CacheHandler) GetBuildCache(c *fiber.Ctx) error {
+	return h.requestBuildCache(c, common.BUILD_CACHE_INSPECT)
+}
+
+// ClearBuildCache removes the build cache of an app
+// @Summary Clear the build cache of an app
+// @Description Removes the build cache of an app on every image builder so that the next build starts from scratch. Caches in use by a running build are reported with an error.
+// @Tags cache
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param appName path string true "App name" example("my-app")
+// @Security ApiKeyAuth
+// @Success 200 {object} models.BuildCacheResponse "Build cache cleared"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/apps/{appName}/cache [delete]
+func (h *CacheHandler) ClearBuildCache(c *fiber.Ctx) error {
+	return h.requestBuildCache(c, common.BUILD_CACHE_CLEAR)
+}
+
+// requestBuildCache sends a build cache action to the image builders and reports their replies
+func (h *CacheHandler) requestBuildCache(c *fiber.Ctx, action string) error {
+	request := &common.BuildCacheRequest{
+		ProjectID: c.Params("projectId"),
+		AppName:   c.Params("appName"),
+		Action:    action,
+	}
+
+	// Cache images live in the registry of the project
+	if h.registries != nil {
+		registry, err := h.registries.Resolve(request.ProjectID)
+		if err != nil {
+			log.Printf("Failed to load registry of project %s: %v", request.ProjectID, err)
+			return c.Status(500).JSON(models.ErrorResponse{
+				Error: "Failed to load project registry",
+			})
+		}
+		request.Registry = registry
+	}
+
+	replies, err := h.natsClient.RequestBuildCache(request, cacheReplyWait)
+	if err != nil {
+		log.Printf("Failed to %s build cache of %s/%s: %v", action, request.ProjectID, request.AppName, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to reach image builders",
+		})
+	}
+
+	response := models.BuildCacheResponse{
+		ProjectID: request.ProjectID,
+		AppName:   request.AppName,
+		Builders:  []models.BuilderCacheResponse{},
+	}
+	for _, reply := range replies {
+		builder := models.BuilderCacheResponse{
+			BuilderID: reply.BuilderID,
+			Caches:    []models.BuildCacheEntryResponse{},
+			Error:     reply.Error,
+		}
+		for _, cache := range reply.Caches {
+			entry := models.BuildCacheEntryResponse{
+				Name:      cache.Name,
+				Format:    cache.Format,
+				Exists:    cache.Exists,
+				SizeBytes: cache.SizeBytes,
+				Cleared:   cache.Cleared,
+				Error:     cache.Error,
+			}
+			if !cache.CreatedAt.IsZero() {
+				entry.CreatedAt = cache.CreatedAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			builder.Caches = append(builder.Caches, entry)
+		}
+		response.Builders = append(response.Builders, builder)
+	}
+
+	return c.JSON(response)
+}
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
 	"time"
 
-	_ "mira/cmd/api/models"
+	"mira/cmd/api/models"
 	"mira/cmd/api/schemas"
 	"mira/cmd/api/services"
 	common "mira/cmd/common"
@@ -14,12 +21,20 @@ import (
 	"github.com/google/uuid"
 )
 
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
 type ImageHandler struct {
 	natsClient        *common.NATSClient
 	validationService *services.ValidationService
+	buildRequests     *services.BuildRequestService
+	idempotency       *services.IdempotencyService
+	registries        *services.RegistryService
+	policies          *services.SecurityPolicyService
+	blobs             common.BlobStore
 }
 
-func NewImageHandler(natsClient *common.NATSClient) *ImageHandler {
+func NewImageHandler(natsClient *common.NATSClient, buildRequests *services.BuildRequestService, idempotency *services.IdempotencyService, registries *services.RegistryService, policies *services.SecurityPolicyService, blobs common.BlobStore) *ImageHandler {
 	if natsClient == nil {
 		var err error
 		natsClient, err = common.NewNATSClient()
@@ -31,6 +46,11 @@ func NewImageHandler(natsClient *common.NATSClient) *ImageHandler {
 	return &ImageHandler{
 		natsClient:        natsClient,
 		validationService: services.NewValidationService(),
+		buildRequests:     buildRequests,
+		idempotency:       idempotency,
+		registries:        registries,
+		policies:          policies,
+		blobs:             blobs,
 	}
 }
 
@@ -51,13 +71,17 @@ func getLogsHTMLURL(host, buildID string) string {
 
 // GenerateImage containerizes source code into Docker images
 // @Summary Containerize source code
-// @Description Converts source code from Git repository into a Docker image and deploys to Crane Cloud
+// @Description Converts source code from Git repository into a Docker image and deploys to Crane Cloud. Requests repeated with the same Idempotency-Key header return the original build instead of starting a new one. To build an uploaded archive instead, send a multipart/form-data request with the archive (.zip, .tar.gz or .tgz) in the file field and the build configuration as JSON, without the git fields, in the request field.
 // @Tags images
 // @Accept json
+// @Accept mpfd
 // @Produce json
+// @Param Idempotency-Key header string false "Key identifying the request across client retries"
 // @Param request body schemas.GenerateImageRequest true "Build configuration"
 // @Success 200 {object} models.BuildResponse "Build started successfully"
 // @Failure 400 {object} models.ErrorResponse "Invalid request"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 409 {object} models.ErrorResponse "Idempotency key reused with a different request"
 // @Failure 500 {object} models.ErrorResponse "Internal server error"
 // @Router /images/containerize [post]
 func (h *ImageHandler) GenerateImage(c *fiber.Ctx) error {
@@ -68,9 +92,26 @@ func (h *ImageHandler) GenerateImage(c *fiber.Ctx) error {
 	buildReq.ID = buildID
 	buildReq.Timestamp = time.Now()
 
-	// Parse JSON request
+	// Parse JSON request, multipart requests carry it next to the uploaded source archive
 	var req schemas.GenerateImageRequest
-	if err := c.BodyParser(&req); err != nil {
+	var archive *multipart.FileHeader
+	if strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+		var err error
+		archive, err = c.FormFile("file")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Missing source archive",
+				"details": "the file field must hold a .zip, .tar.gz or .tgz archive",
+			})
+		}
+		if err := json.Unmarshal([]byte(c.FormValue("request")), &req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid JSON format",
+				"details": "request field: " + err.Error(),
+			})
+		}
+		req.Archive = archive.Filename
+	} else if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Invalid JSON format",
 			"details": err.Error(),
@@ -85,40 +126,216 @@ func (h *ImageHandler) GenerateImage(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate app name with CraneCloud backend
-	if err := h.validationService.ValidateAppName(req.Name, req.ProjectId, req.AccessToken); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":   "App name validation failed",
-			"details": err.Error(),
-		})
+	// Every mode builds with the registry and settings of the project, so
+	// the token has to grant access to it, also before replaying a build
+	if err := h.validationService.ValidateProjectAccess(c.Context(), req.ProjectId, req.AccessToken); err != nil {
+		return projectAccessFailed(c, req.ProjectId, err)
+	}
+
+	// Get host for WebSocket URL first (before async operations)
+	host := string(c.Context().URI().Host())
+	if host == "" {
+		host = "localhost:3000"
+	}
+
+	// Answer retried requests with the build they already started. This has to
+	// happen before the app name check, which fails once the app exists.
+	idempotencyKey := c.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid Idempotency-Key header",
+				"details": fmt.Sprintf("must be %d characters or less", maxIdempotencyKeyLength),
+			})
+		}
+		if h.idempotency == nil {
+			fmt.Printf("MongoDB is not available, ignoring Idempotency-Key of build %s\n", buildID)
+			idempotencyKey = ""
+		} else {
+			original, err := h.reserveIdempotencyKey(idempotencyKey, &req, archive, buildID)
+			if errors.Is(err, services.ErrIdempotencyKeyReused) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error":   "Idempotency-Key was already used with a different request",
+					"details": err.Error(),
+				})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error":   "Failed to check Idempotency-Key",
+					"details": err.Error(),
+				})
+			}
+			if original != nil {
+				c.Set("Idempotent-Replayed", "true")
+				return c.JSON(fiber.Map{
+					"message": "Image generation started",
+					"data":    buildStartedData(host, &common.BuildRequest{ID: original.BuildID, Name: original.Name}),
+				})
+			}
+		}
+	}
+
+	// Validate app name with CraneCloud backend, unless the build is not deployed
+	if common.BuildModeDeploys(req.Mode) {
+		if err := h.validationService.ValidateAppName(req.Name, req.ProjectId, req.AccessToken); err != nil {
+			h.releaseIdempotencyKey(idempotencyKey, req.ProjectId, buildID)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "App name validation failed",
+				"details": err.Error(),
+			})
+		}
 	}
 
 	// Map JSON fields to build request structure
 	buildReq.Name = req.Name
+	buildReq.Priority = common.NormalizeBuildPriority(req.Priority)
 	buildReq.Spec.BuildCommand = req.BuildCommand
 	buildReq.Spec.OutputDir = req.OutputDirectory
+	buildReq.Spec.RootDir = req.RootDirectory
 	buildReq.Spec.ProjectID = req.ProjectId
 	buildReq.Spec.AccessToken = req.AccessToken
 	buildReq.Spec.SSR = req.SSR
+	buildReq.Spec.Backend = req.Backend
+	buildReq.Spec.BuilderImage = req.BuilderImage
+	buildReq.Spec.Buildpacks = req.Buildpacks
+	buildReq.Spec.BuilderStrategy = req.BuilderStrategy
+	buildReq.Spec.StartCommand = req.StartCommand
+	buildReq.Spec.Mode = common.NormalizeBuildMode(req.Mode)
 	buildReq.Spec.Env = req.Env
 	if buildReq.Spec.Env == nil {
 		buildReq.Spec.Env = make(map[string]string)
 	}
 
-	// Set git repository as source
-	buildReq.Spec.Source.GitRepo.URL = req.Repo
-	buildReq.Spec.Source.Type = "git"
+	// Set the uploaded archive or the git repository as source
+	if archive != nil {
+		if err := h.storeSourceArchive(&buildReq, archive); err != nil {
+			fmt.Printf("Failed to store source archive of build %s: %v\n", buildID, err)
+			h.releaseIdempotencyKey(idempotencyKey, req.ProjectId, buildID)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to store source archive",
+				"details": err.Error(),
+			})
+		}
+	} else {
+		buildReq.Spec.Source.GitRepo.URL = req.Repo
+		buildReq.Spec.Source.GitRepo.Branch = req.Branch
+		buildReq.Spec.Source.GitRepo.Tag = req.Tag
+		buildReq.Spec.Source.GitRepo.Revision = req.Commit
+		buildReq.Spec.Source.Type = "git"
+	}
 
-	// Get host for WebSocket URL first (before async operations)
-	host := string(c.Context().URI().Host())
-	if host == "" {
-		host = "localhost:3000"
+	if err := projectRegistry(h.registries, &buildReq); err != nil {
+		fmt.Printf("Failed to load registry of project %s: %v\n", req.ProjectId, err)
+		h.releaseIdempotencyKey(idempotencyKey, req.ProjectId, buildID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load project registry",
+			"details": err.Error(),
+		})
+	}
+
+	if err := projectSecurityPolicy(h.policies, &buildReq); err != nil {
+		fmt.Printf("Failed to load security policy of project %s: %v\n", req.ProjectId, err)
+		h.releaseIdempotencyKey(idempotencyKey, req.ProjectId, buildID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load project security policy",
+			"details": err.Error(),
+		})
+	}
+
+	if err := queueBuildRequest(h.natsClient, &buildReq); err != nil {
+		h.releaseIdempotencyKey(idempotencyKey, req.ProjectId, buildID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to queue build request",
+			"details": err.Error(),
+		})
+	}
+
+	// Store the request so the build can be retried later. Builds that were
+	// not queued are not recorded, as no builder would ever pick them up.
+	if h.buildRequests != nil {
+		if err := h.buildRequests.Save(&buildReq, ""); err != nil {
+			fmt.Printf("Failed to store build request %s: %v\n", buildReq.ID, err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Image generation started",
+		"data":    buildStartedData(host, &buildReq),
+	})
+}
+
+// reserveIdempotencyKey claims key for the build, returning the original build when the request is a repeat
+func (h *ImageHandler) reserveIdempotencyKey(key string, req *schemas.GenerateImageRequest, archive *multipart.FileHeader, buildID string) (*models.MongoIdempotencyKey, error) {
+	var hashed interface{} = req
+	if archive != nil {
+		// Uploads of another archive with the same fields are different requests
+		digest, err := archiveDigest(archive)
+		if err != nil {
+			return nil, err
+		}
+		hashed = struct {
+			*schemas.GenerateImageRequest
+			ArchiveDigest string `json:"archive_digest"`
+		}{req, digest}
+	}
+	requestHash, err := services.HashRequest(hashed)
+	if err != nil {
+		return nil, err
+	}
+	return h.idempotency.Reserve(req.ProjectId, key, requestHash, buildID, req.Name)
+}
+
+// archiveDigest returns the SHA-256 of an uploaded archive
+func archiveDigest(archive *multipart.FileHeader) (string, error) {
+	file, err := archive.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to read source archive: %v", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read source archive: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// storeSourceArchive puts the uploaded archive of a build in the blob store,
+// keyed by build ID, and sets it as the file source of the build
+func (h *ImageHandler) storeSourceArchive(buildReq *common.BuildRequest, archive *multipart.FileHeader) error {
+	if h.blobs == nil {
+		return fmt.Errorf("no blob store is available for source archives")
+	}
+	file, err := archive.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read source archive: %v", err)
 	}
+	defer file.Close()
+	if err := h.blobs.Put(buildReq.ID, file); err != nil {
+		return err
+	}
+
+	buildReq.Spec.Source.Type = "file"
+	buildReq.Spec.Source.BlobFile.Key = buildReq.ID
+	buildReq.Spec.Source.BlobFile.Format = common.SourceArchiveFormat(archive.Filename)
+	return nil
+}
 
+// releaseIdempotencyKey frees the key of a build that was rejected, so the client can retry with it
+func (h *ImageHandler) releaseIdempotencyKey(key, projectID, buildID string) {
+	if key == "" || h.idempotency == nil {
+		return
+	}
+	if err := h.idempotency.Release(projectID, key, buildID); err != nil {
+		fmt.Printf("Failed to release Idempotency-Key of build %s: %v\n", buildID, err)
+	}
+}
+
+// queueBuildRequest publishes a build request to NATS, waiting briefly for the result
+func queueBuildRequest(natsClient *common.NATSClient, buildReq *common.BuildRequest) error {
 	// Publish build request to NATS asynchronously for better response time
 	published := make(chan error, 1)
 
-	h.natsClient.PublishBuildRequestAsync(&buildReq,
+	natsClient.PublishBuildRequestAsync(buildReq,
 		func() {
 			// Success callback
 			published <- nil
@@ -133,24 +350,20 @@ func (h *ImageHandler) GenerateImage(c *fiber.Ctx) error {
 	// Wait for publish result with timeout (non-blocking for client)
 	select {
 	case err := <-published:
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "Failed to queue build request",
-				"details": err.Error(),
-			})
-		}
+		return err
 	case <-time.After(2 * time.Second):
 		// Don't wait too long, respond optimistically
 		fmt.Printf("Build request publish taking longer than expected for build %s\n", buildReq.ID)
 	}
+	return nil
+}
 
-	return c.JSON(fiber.Map{
-		"message": "Image generation started",
-		"data": fiber.Map{
-			"name":            buildReq.Name,
-			"build_id":        buildReq.ID,
-			"logs_socket_url": getWebSocketURL(host, buildReq.ID),
-			"logs_html_url":   getLogsHTMLURL(host, buildReq.ID),
-		},
-	})
+// buildStartedData describes a queued build and where to follow its logs
+func buildStartedData(host string, buildReq *common.BuildRequest) fiber.Map {
+	return fiber.Map{
+		"name":            buildReq.Name,
+		"build_id":        buildReq.ID,
+		"logs_socket_url": getWebSocketURL(host, buildReq.ID),
+		"logs_html_url":   getLogsHTMLURL(host, buildReq.ID),
+	}
 }
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"mira/cmd/api/models"
@@ -17,17 +19,49 @@ import (
 	"github.com/gofiber/websocket/v2"
 )
 
+// queueUpdateInterval is how often the log stream of a waiting build gets a queue position update
+const queueUpdateInterval = 10 * time.Second
+
+// queueUpdateMessage is a queue position update sent on the log stream of a waiting build
+type queueUpdateMessage struct {
+	Type string `json:"type"` // "queue_position"
+	*models.BuildQueueResponse
+}
+
 // LogHandler handles WebSocket log streaming and MongoDB log operations
 type LogHandler struct {
 	natsClient   *common.NATSClient
 	mongoService *services.MongoLogService
+	queueService *services.QueueService
+
+	streamsMu sync.Mutex
+	streams   map[*websocket.Conn]string
 }
 
 // NewLogHandler creates a new log handler
-func NewLogHandler(natsClient *common.NATSClient, mongoService *services.MongoLogService) *LogHandler {
+func NewLogHandler(natsClient *common.NATSClient, mongoService *services.MongoLogService, queueService *services.QueueService) *LogHandler {
 	return &LogHandler{
 		natsClient:   natsClient,
 		mongoService: mongoService,
+		queueService: queueService,
+		streams:      make(map[*websocket.Conn]string),
+	}
+}
+
+// CloseStreams tells every connected log stream client that the server is
+// going away and closes the connection, ending its StreamLogs loop
+func (h *LogHandler) CloseStreams() {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+
+	log.Printf("Closing %d log streams", len(h.streams))
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for c, buildID := range h.streams {
+		// WriteControl and Close are safe to call while the stream is writing
+		if err := c.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)); err != nil {
+			log.Printf("Failed to send close message for build %s: %v", buildID, err)
+		}
+		c.Close()
 	}
 }
 
@@ -231,7 +265,7 @@ func (h *LogHandler) GetBuildLogsFromMongoDB(c *fiber.Ctx) error {
 // @Produce json
 // @Param projectId query string false "Project ID filter" example("proj-123")
 // @Param appName query string false "App name filter (supports both appName and app_name)" example("my-app")
-// @Param status query string false "Build status filter (pending, running, completed, failed)" example("completed")
+// @Param status query string false "Build status filter (queued, running, building, deploying, completed, failed, cancelled, timed_out, interrupted)" example("completed")
 // @Param sort query string false "Sort order (desc for newest first, asc for oldest first)" example("desc")
 // @Param page query int false "Page number (default: 1)" example(1)
 // @Param limit query int false "Number of builds per page (default: 10, max: 100)" example(10)
@@ -350,6 +384,18 @@ func (h *LogHandler) StreamLogs(c *websocket.Conn) {
 
 	log.Printf("Starting log stream for build ID: %s", buildID)
 
+	h.streamsMu.Lock()
+	h.streams[c] = buildID
+	h.streamsMu.Unlock()
+	defer func() {
+		h.streamsMu.Lock()
+		delete(h.streams, c)
+		h.streamsMu.Unlock()
+	}()
+
+	// Log, completion and queue updates arrive concurrently, the connection takes one writer at a time
+	var writeMu sync.Mutex
+
 	// Subscribe to logs for this specific build
 	logSub, err := h.natsClient.SubscribeToLogs(buildID, func(logMsg *common.LogMessage) {
 		// Convert log message to JSON and send via WebSocket
@@ -360,8 +406,10 @@ func (h *LogHandler) StreamLogs(c *websocket.Conn) {
 		}
 
 		// Set write deadline for 5-minute timeout
+		writeMu.Lock()
 		c.SetWriteDeadline(time.Now().Add(5 * time.Minute))
 		err = c.WriteMessage(websocket.TextMessage, data)
+		writeMu.Unlock()
 		if err != nil {
 			log.Printf("Failed to write WebSocket message: %v", err)
 		}
@@ -385,8 +433,10 @@ func (h *LogHandler) StreamLogs(c *websocket.Conn) {
 		}
 
 		// Set write deadline for 5-minute timeout
+		writeMu.Lock()
 		c.SetWriteDeadline(time.Now().Add(5 * time.Minute))
 		err = c.WriteMessage(websocket.TextMessage, data)
+		writeMu.Unlock()
 		if err != nil {
 			log.Printf("Failed to write WebSocket completion message: %v", err)
 		}
@@ -409,8 +459,17 @@ func (h *LogHandler) StreamLogs(c *websocket.Conn) {
 
 	// Send initial connection confirmation
 	confirmMsg := fmt.Sprintf(`{"message":"Connected to log stream for build %s"}`, buildID)
+	writeMu.Lock()
 	c.SetWriteDeadline(time.Now().Add(5 * time.Minute))
 	c.WriteMessage(websocket.TextMessage, []byte(confirmMsg))
+	writeMu.Unlock()
+
+	// Tell the client where the build is in the queue until a builder picks it up
+	stopQueueUpdates := make(chan struct{})
+	defer close(stopQueueUpdates)
+	if h.queueService != nil {
+		go h.pushQueueUpdates(c, buildID, &writeMu, stopQueueUpdates)
+	}
 
 	// Keep connection alive and handle client messages with 5-minute timeout
 	timeout := 5 * time.Minute
@@ -430,8 +489,10 @@ func (h *LogHandler) StreamLogs(c *websocket.Conn) {
 
 		// Handle ping/pong or other control messages
 		if messageType == websocket.PingMessage {
+			writeMu.Lock()
 			c.SetWriteDeadline(time.Now().Add(timeout))
 			c.WriteMessage(websocket.PongMessage, nil)
+			writeMu.Unlock()
 		} else if messageType == websocket.TextMessage {
 			// Handle any client commands if needed
 			log.Printf("Received message from client: %s", string(message))
@@ -439,6 +500,49 @@ func (h *LogHandler) StreamLogs(c *websocket.Conn) {
 	}
 }
 
+// pushQueueUpdates sends the queue position of a build on its log stream
+// while the build waits for a builder
+func (h *LogHandler) pushQueueUpdates(c *websocket.Conn, buildID string, writeMu *sync.Mutex, stop <-chan struct{}) {
+	ticker := time.NewTicker(queueUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := h.queueService.QueueInfo(buildID)
+		if err != nil {
+			if !errors.Is(err, services.ErrBuildNotQueued) {
+				log.Printf("Failed to get queue position for build %s: %v", buildID, err)
+			}
+			return
+		}
+		if info.Status != common.BUILD_STATUS_QUEUED && info.Status != common.BUILD_STATUS_INTERRUPTED {
+			return
+		}
+
+		if info.Waiting {
+			data, err := json.Marshal(queueUpdateMessage{Type: "queue_position", BuildQueueResponse: info})
+			if err != nil {
+				log.Printf("Failed to marshal queue update: %v", err)
+				return
+			}
+
+			writeMu.Lock()
+			c.SetWriteDeadline(time.Now().Add(5 * time.Minute))
+			err = c.WriteMessage(websocket.TextMessage, data)
+			writeMu.Unlock()
+			if err != nil {
+				log.Printf("Failed to write WebSocket queue update: %v", err)
+				return
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // WebSocketUpgrade checks if the request can be upgraded to WebSocket
 func (h *LogHandler) WebSocketUpgrade(c *fiber.Ctx) error {
 	// Check if it's a WebSocket upgrade request
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"mira/cmd/api/models"
+	"mira/cmd/api/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireProjectAccess lets a request through only when its Authorization
+// header holds a Crane Cloud access token of the project named by the
+// projectId path parameter. It guards the routes changing project settings.
+func RequireProjectAccess(validation *services.ValidationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectID := c.Params("projectId")
+		accessToken := bearerToken(c)
+		if accessToken == "" {
+			return missingAccessToken(c)
+		}
+
+		if err := validation.ValidateProjectAccess(c.Context(), projectID, accessToken); err != nil {
+			return projectAccessFailed(c, projectID, err)
+		}
+
+		return c.Next()
+	}
+}
+
+// projectAccessFailed answers a request whose access token could not be
+// validated for the project
+func projectAccessFailed(c *fiber.Ctx, projectID string, err error) error {
+	if errors.Is(err, services.ErrProjectAccessDenied) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+			Error: "Access token does not grant access to the project",
+		})
+	}
+	log.Printf("Failed to validate access to project %s: %v", projectID, err)
+	return c.Status(500).JSON(models.ErrorResponse{
+		Error: "Failed to validate project access",
+	})
+}
+
+// bearerToken returns the access token of the Authorization header
+func bearerToken(c *fiber.Ctx) string {
+	return strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+}
+
+// missingAccessToken answers a request without an Authorization header
+func missingAccessToken(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+		Error: "A Crane Cloud access token is required in the Authorization header",
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"mira/cmd/api/models"
+	"mira/cmd/api/schemas"
+	"mira/cmd/api/services"
+	common "mira/cmd/common"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegistryHandler configures the container registry each project pushes its images to
+type RegistryHandler struct {
+	registries *services.RegistryService
+}
+
+// NewRegistryHandler creates a new registry handler
+func NewRegistryHandler(registries *services.RegistryService) *RegistryHandler {
+	return &RegistryHandler{
+		registries: registries,
+	}
+}
+
+// GetRegistry returns the container registry of a project
+// @Summary Get the container registry of a project
+// @Description Returns the registry the images of a project are pushed to. Projects without one use the default Docker Hub account of the image builders. Passwords are never returned.
+// @Tags registries
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Success 200 {object} models.RegistryResponse "Registry retrieved successfully"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/registry [get]
+func (h *RegistryHandler) GetRegistry(c *fiber.Ctx) error {
+	projectID := c.Params("projectId")
+	if h.registries == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	stored, err := h.registries.Get(projectID)
+	if err != nil {
+		log.Printf("Failed to get registry of project %s: %v", projectID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to retrieve registry",
+		})
+	}
+	if stored == nil {
+		registry := common.DefaultImageRegistry()
+		return c.JSON(models.RegistryResponse{
+			ProjectID: projectID,
+			Type:      registry.Type,
+			Host:      registry.RegistryHost(),
+			Namespace: registry.Namespace,
+			Default:   true,
+		})
+	}
+
+	return c.JSON(stored.ToRegistryResponse())
+}
+
+// SetRegistry configures the container registry of a project
+// @Summary Set the container registry of a project
+// @Description Sets the registry the images of a project are pushed to: Docker Hub, GHCR, a private registry or a local registry:2. Credentials are encrypted with MIRA_SPEC_ENCRYPTION_KEY and stay sealed in build requests until an image builder, configured with the same key, decrypts them. Insecure registries are pushed to over plain HTTP. Requires a Crane Cloud access token of the project in the Authorization header.
+// @Tags registries
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param request body schemas.RegistryRequest true "Registry configuration"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.RegistryResponse "Registry saved"
+// @Failure 400 {object} models.ErrorResponse "Invalid request"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/registry [put]
+func (h *RegistryHandler) SetRegistry(c *fiber.Ctx) error {
+	projectID := c.Params("projectId")
+	if h.registries == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	var req schemas.RegistryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+	}
+	if validationErrors := schemas.ValidateRegistryRequest(&req); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":      "Validation failed",
+			"validation": validationErrors,
+		})
+	}
+
+	stored, err := h.registries.Save(projectID, &common.ImageRegistry{
+		Type:      req.Type,
+		Host:      req.Host,
+		Namespace: req.Namespace,
+		Username:  req.Username,
+		Password:  req.Password,
+		Insecure:  req.Insecure,
+		Private:   req.Private,
+	})
+	if errors.Is(err, services.ErrRegistryEncryptionDisabled) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Registry credentials cannot be stored",
+			"details": err.Error(),
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to save registry of project %s: %v", projectID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to save registry",
+		})
+	}
+
+	return c.JSON(stored.ToRegistryResponse())
+}
+
+// DeleteRegistry removes the container registry of a project
+// @Summary Remove the container registry of a project
+// @Description Removes the registry of a project and its credentials. Later builds push to the default Docker Hub account of the image builders. Requires a Crane Cloud access token of the project in the Authorization header.
+// @Tags registries
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Security ApiKeyAuth
+// @Success 204 "Registry removed"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 404 {object} models.ErrorResponse "Project has no registry"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/registry [delete]
+func (h *RegistryHandler) DeleteRegistry(c *fiber.Ctx) error {
+	projectID := c.Params("projectId")
+	if h.registries == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	deleted, err := h.registries.Delete(projectID)
+	if err != nil {
+		log.Printf("Failed to delete registry of project %s: %v", projectID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to delete registry",
+		})
+	}
+	if !deleted {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Project has no registry",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// projectRegistry sets the registry of the project on a build request, so
+// the image builder pushes there with the project credentials
+func projectRegistry(registries *services.RegistryService, buildReq *common.BuildRequest) error {
+	if registries == nil {
+		return nil
+	}
+	registry, err := registries.Resolve(buildReq.Spec.ProjectID)
+	if err != nil {
+		return err
+	}
+	buildReq.Spec.Registry = registry
+	return nil
+}
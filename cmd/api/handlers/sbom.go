@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"mira/cmd/api/models"
+	"mira/cmd/api/services"
+	common "mira/cmd/common"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SBOMHandler serves the software bill of materials of built images
+type SBOMHandler struct {
+	sboms *services.SBOMService
+}
+
+// NewSBOMHandler creates a new SBOM handler
+func NewSBOMHandler(sboms *services.SBOMService) *SBOMHandler {
+	return &SBOMHandler{
+		sboms: sboms,
+	}
+}
+
+// GetBuildSBOM returns the SBOM of the image of a build
+// @Summary Get the SBOM of a build
+// @Description Returns the SBOM documents the buildpacks wrote for the image of a build, one per buildpack or layer and format. Only builds using the buildpacks backend have an SBOM, which is kept for MIRA_SBOM_TTL.
+// @Tags builds
+// @Accept json
+// @Produce json
+// @Param buildId path string true "Build ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param format query string false "Only return documents in this format (cyclonedx, spdx, syft)" example("cyclonedx")
+// @Success 200 {object} models.BuildSBOMResponse "SBOM retrieved successfully"
+// @Failure 400 {object} models.ErrorResponse "Invalid format"
+// @Failure 404 {object} models.ErrorResponse "Build has no SBOM"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /builds/{buildId}/sbom [get]
+func (h *SBOMHandler) GetBuildSBOM(c *fiber.Ctx) error {
+	buildID := c.Params("buildId")
+	format := strings.ToLower(c.Query("format"))
+	if format != "" && !isSBOMFormat(format) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid format",
+			"details": "format must be one of " + strings.Join(common.SBOMFormats, ", "),
+		})
+	}
+	if h.sboms == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	documents, err := h.sboms.Documents(buildID, format)
+	if err != nil {
+		log.Printf("Failed to get SBOM of build %s: %v", buildID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to retrieve SBOM",
+		})
+	}
+	if documents == nil {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Build has no SBOM",
+		})
+	}
+
+	response := models.BuildSBOMResponse{
+		BuildID:   buildID,
+		Format:    format,
+		Documents: []models.SBOMDocumentResponse{},
+	}
+	for _, document := range documents {
+		response.Documents = append(response.Documents, models.SBOMDocumentResponse{
+			Buildpack: document.Buildpack,
+			Layer:     document.Layer,
+			Format:    document.Format,
+			Document:  document.Content,
+		})
+	}
+	return c.JSON(response)
+}
+
+// FindPackageBuilds returns the builds whose images contain a package
+// @Summary Find builds containing a package
+// @Description Searches the packages indexed from the SBOMs of built images, e.g. to find every build shipping a vulnerable version of a library. Newest builds come first.
+// @Tags builds
+// @Accept json
+// @Produce json
+// @Param name query string true "Package name" example("lodash")
+// @Param version query string false "Package version" example("4.17.20")
+// @Param project_id query string false "Project ID filter" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param page query int false "Page number (default: 1)" example(1)
+// @Param limit query int false "Number of builds per page (default: 10, max: 100)" example(10)
+// @Success 200 {object} models.PackageBuildsResponse "Builds retrieved successfully"
+// @Failure 400 {object} models.ErrorResponse "Invalid query parameters"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /sbom/packages [get]
+func (h *SBOMHandler) FindPackageBuilds(c *fiber.Ctx) error {
+	name := c.Query("name")
+	version := c.Query("version")
+	projectID := c.Query("project_id")
+	if name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "name query parameter is required",
+		})
+	}
+
+	page := 1
+	if pageStr := c.Query("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	limit := 10
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			if l > 100 {
+				l = 100 // Max limit
+			}
+			limit = l
+		}
+	}
+
+	if h.sboms == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	packages, total, err := h.sboms.FindPackages(name, version, projectID, page, limit)
+	if err != nil {
+		log.Printf("Failed to find builds containing %s %s: %v", name, version, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to retrieve builds",
+		})
+	}
+
+	response := models.PackageBuildsResponse{
+		Builds: []models.PackageBuildResponse{},
+		Count:  len(packages),
+		Total:  total,
+		Page:   page,
+		Limit:  limit,
+		Pages:  int(math.Ceil(float64(total) / float64(limit))),
+	}
+	for _, pkg := range packages {
+		response.Builds = append(response.Builds, models.PackageBuildResponse{
+			BuildID:   pkg.BuildID,
+			ProjectID: pkg.ProjectID,
+			AppName:   pkg.AppName,
+			Name:      pkg.Name,
+			Version:   pkg.Version,
+			Type:      pkg.Type,
+			PURL:      pkg.PURL,
+			CreatedAt: pkg.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return c.JSON(response)
+}
+
+// isSBOMFormat reports whether format is one of the SBOM_FORMAT_* values
+func isSBOMFormat(format string) bool {
+	for _, f := range common.SBOMFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"log"
+
+	"mira/cmd/api/models"
+	"mira/cmd/api/schemas"
+	"mira/cmd/api/services"
+	common "mira/cmd/common"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SecurityPolicyHandler configures the vulnerability scan policy of each project
+type SecurityPolicyHandler struct {
+	policies *services.SecurityPolicyService
+}
+
+// NewSecurityPolicyHandler creates a new security policy handler
+func NewSecurityPolicyHandler(policies *services.SecurityPolicyService) *SecurityPolicyHandler {
+	return &SecurityPolicyHandler{
+		policies: policies,
+	}
+}
+
+// GetSecurityPolicy returns the security policy of a project
+// @Summary Get the security policy of a project
+// @Description Returns the policy applied to the vulnerability scan of the images of a project. Scans of projects without a policy only report what they find.
+// @Tags security
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Success 200 {object} models.SecurityPolicyResponse "Security policy retrieved successfully"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/security-policy [get]
+func (h *SecurityPolicyHandler) GetSecurityPolicy(c *fiber.Ctx) error {
+	projectID := c.Params("projectId")
+	if h.policies == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	stored, err := h.policies.Get(projectID)
+	if err != nil {
+		log.Printf("Failed to get security policy of project %s: %v", projectID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to retrieve security policy",
+		})
+	}
+	if stored == nil {
+		return c.JSON(models.SecurityPolicyResponse{
+			ProjectID: projectID,
+			Default:   true,
+		})
+	}
+
+	return c.JSON(stored.ToSecurityPolicyResponse())
+}
+
+// SetSecurityPolicy configures the security policy of a project
+// @Summary Set the security policy of a project
+// @Description Sets the policy applied to the vulnerability scan of the images of a project. Builds whose image has a vulnerability at the fail_on severity or above fail in the security step and are not deployed, unless the vulnerability is ignored. Images are only scanned on image builders with a scanner configured. Requires a Crane Cloud access token of the project in the Authorization header.
+// @Tags security
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Param request body schemas.SecurityPolicyRequest true "Security policy"
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SecurityPolicyResponse "Security policy saved"
+// @Failure 400 {object} models.ErrorResponse "Invalid request"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/security-policy [put]
+func (h *SecurityPolicyHandler) SetSecurityPolicy(c *fiber.Ctx) error {
+	projectID := c.Params("projectId")
+	if h.policies == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	var req schemas.SecurityPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid JSON format",
+			"details": err.Error(),
+		})
+	}
+	if validationErrors := schemas.ValidateSecurityPolicyRequest(&req); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":      "Validation failed",
+			"validation": validationErrors,
+		})
+	}
+
+	stored, err := h.policies.Save(projectID, &common.SecurityPolicy{
+		FailOn: req.FailOn,
+		Ignore: req.Ignore,
+	})
+	if err != nil {
+		log.Printf("Failed to save security policy of project %s: %v", projectID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to save security policy",
+		})
+	}
+
+	return c.JSON(stored.ToSecurityPolicyResponse())
+}
+
+// DeleteSecurityPolicy removes the security policy of a project
+// @Summary Remove the security policy of a project
+// @Description Removes the security policy of a project. Later scans of its images only report what they find. Requires a Crane Cloud access token of the project in the Authorization header.
+// @Tags security
+// @Accept json
+// @Produce json
+// @Param projectId path string true "Project ID" example("550e8400-e29b-41d4-a716-446655440000")
+// @Security ApiKeyAuth
+// @Success 204 "Security policy removed"
+// @Failure 401 {object} models.ErrorResponse "Missing access token"
+// @Failure 403 {object} models.ErrorResponse "Access token does not grant access to the project"
+// @Failure 404 {object} models.ErrorResponse "Project has no security policy"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /projects/{projectId}/security-policy [delete]
+func (h *SecurityPolicyHandler) DeleteSecurityPolicy(c *fiber.Ctx) error {
+	projectID := c.Params("projectId")
+	if h.policies == nil {
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "MongoDB service is not available",
+		})
+	}
+
+	deleted, err := h.policies.Delete(projectID)
+	if err != nil {
+		log.Printf("Failed to delete security policy of project %s: %v", projectID, err)
+		return c.Status(500).JSON(models.ErrorResponse{
+			Error: "Failed to delete security policy",
+		})
+	}
+	if !deleted {
+		return c.Status(404).JSON(models.ErrorResponse{
+			Error: "Project has no security policy",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// projectSecurityPolicy sets the security policy of the project on a build
+// request, so the image builder applies it to the scan of the image
+func projectSecurityPolicy(policies *services.SecurityPolicyService, buildReq *common.BuildRequest) error {
+	if policies == nil {
+		return nil
+	}
+	policy, err := policies.Resolve(buildReq.Spec.ProjectID)
+	if err != nil {
+		return err
+	}
+	buildReq.Spec.SecurityPolicy = policy
+	return nil
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"time"
 
+	common "mira/cmd/common"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -72,6 +74,202 @@ type MongoBuildStatus struct {
 	ImageName   string             `bson:"image_name,omitempty" json:"image_name,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// ImageDigest is the digest of the pushed image, ImageName is pinned to it
+	ImageDigest string `bson:"image_digest,omitempty" json:"image_digest,omitempty"`
+	// ImageTags are the tags the image was pushed with
+	ImageTags []string `bson:"image_tags,omitempty" json:"image_tags,omitempty"`
+	// CommitSHA is the commit the git source resolved to
+	CommitSHA string `bson:"commit_sha,omitempty" json:"commit_sha,omitempty"`
+	// Framework is the main framework the builder detected in the source code
+	Framework string `bson:"framework,omitempty" json:"framework,omitempty"`
+	// Attempt is the delivery attempt of the build request that reported the status
+	Attempt int `bson:"attempt,omitempty" json:"attempt,omitempty"`
+	// StatusHistory records when the build entered each status
+	StatusHistory []MongoStatusTransition `bson:"status_history,omitempty" json:"status_history,omitempty"`
+
+	// Artifact describes the image and how long each step of the build took
+	Artifact *MongoBuildArtifact `bson:"artifact,omitempty" json:"artifact,omitempty"`
+	// Scan is the vulnerability scan of the image
+	Scan *MongoScanReport `bson:"scan,omitempty" json:"scan,omitempty"`
+	// SBOM summarises the SBOM stored for the image of the build
+	SBOM *MongoBuildSBOM `bson:"sbom,omitempty" json:"sbom,omitempty"`
+	// Mode is the build mode the build was requested with
+	Mode string `bson:"mode,omitempty" json:"mode,omitempty"`
+	// ParentBuildID is the build this one was retried from
+	ParentBuildID string `bson:"parent_build_id,omitempty" json:"parent_build_id,omitempty"`
+	// Request is the original build request, stored when the API accepted it
+	Request *MongoBuildRequest `bson:"request,omitempty" json:"-"`
+}
+
+// MongoBuildArtifact describes the image a build produced
+type MongoBuildArtifact struct {
+	Digest        string              `bson:"digest,omitempty" json:"digest,omitempty"`
+	SizeBytes     int64               `bson:"size_bytes,omitempty" json:"size_bytes,omitempty"`
+	Layers        int                 `bson:"layers,omitempty" json:"layers,omitempty"`
+	Backend       string              `bson:"backend,omitempty" json:"backend,omitempty"`
+	BuilderImage  string              `bson:"builder_image,omitempty" json:"builder_image,omitempty"`
+	Buildpacks    []MongoBuildpackRef `bson:"buildpacks,omitempty" json:"buildpacks,omitempty"`
+	Framework     string              `bson:"framework,omitempty" json:"framework,omitempty"`
+	StepDurations []MongoStepDuration `bson:"step_durations,omitempty" json:"step_durations,omitempty"`
+}
+
+// MongoBuildpackRef is a buildpack that took part in a build
+type MongoBuildpackRef struct {
+	ID      string `bson:"id" json:"id"`
+	Version string `bson:"version,omitempty" json:"version,omitempty"`
+}
+
+// MongoStepDuration is how long a pipeline step of a build ran
+type MongoStepDuration struct {
+	Step       string `bson:"step" json:"step"`
+	DurationMS int64  `bson:"duration_ms" json:"duration_ms"`
+}
+
+// NewMongoBuildArtifact converts the artifact of a build status for storage
+func NewMongoBuildArtifact(artifact *common.BuildArtifact) *MongoBuildArtifact {
+	stored := &MongoBuildArtifact{
+		Digest:       artifact.Digest,
+		SizeBytes:    artifact.SizeBytes,
+		Layers:       artifact.Layers,
+		Backend:      artifact.Backend,
+		BuilderImage: artifact.BuilderImage,
+		Framework:    artifact.Framework,
+	}
+	for _, buildpack := range artifact.Buildpacks {
+		stored.Buildpacks = append(stored.Buildpacks, MongoBuildpackRef(buildpack))
+	}
+	for _, step := range artifact.StepDurations {
+		stored.StepDurations = append(stored.StepDurations, MongoStepDuration(step))
+	}
+	return stored
+}
+
+// MongoScanReport is the vulnerability scan of the image of a build
+type MongoScanReport struct {
+	Scanner         string               `bson:"scanner" json:"scanner"`
+	Packages        int                  `bson:"packages" json:"packages"`
+	Vulnerabilities []MongoVulnerability `bson:"vulnerabilities,omitempty" json:"vulnerabilities,omitempty"`
+	Counts          map[string]int       `bson:"counts,omitempty" json:"counts,omitempty"`
+	Blocked         bool                 `bson:"blocked" json:"blocked"`
+	ScannedAt       time.Time            `bson:"scanned_at" json:"scanned_at"`
+}
+
+// MongoVulnerability is a vulnerability found by the scan of an image
+type MongoVulnerability struct {
+	ID           string `bson:"id" json:"id"`
+	Package      string `bson:"package" json:"package"`
+	Version      string `bson:"version,omitempty" json:"version,omitempty"`
+	Severity     string `bson:"severity" json:"severity"`
+	FixedVersion string `bson:"fixed_version,omitempty" json:"fixed_version,omitempty"`
+	Summary      string `bson:"summary,omitempty" json:"summary,omitempty"`
+}
+
+// NewMongoScanReport converts the scan report of a build status for storage
+func NewMongoScanReport(report *common.ScanReport) *MongoScanReport {
+	stored := &MongoScanReport{
+		Scanner:   report.Scanner,
+		Packages:  report.Packages,
+		Counts:    report.Counts,
+		Blocked:   report.Blocked,
+		ScannedAt: report.ScannedAt,
+	}
+	for _, v := range report.Vulnerabilities {
+		stored.Vulnerabilities = append(stored.Vulnerabilities, MongoVulnerability(v))
+	}
+	return stored
+}
+
+// MongoSecurityPolicy is the vulnerability scan policy of a project
+type MongoSecurityPolicy struct {
+	ProjectID string    `bson:"_id"`
+	FailOn    string    `bson:"fail_on,omitempty"`
+	Ignore    []string  `bson:"ignore,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// MongoBuildSBOM summarises the SBOM documents stored for a build
+type MongoBuildSBOM struct {
+	Formats      []string  `bson:"formats" json:"formats"`
+	Documents    int       `bson:"documents" json:"documents"`
+	PackageCount int       `bson:"package_count" json:"package_count"`
+	StoredAt     time.Time `bson:"stored_at" json:"stored_at"`
+}
+
+// MongoBuildPackage indexes a package found in the SBOM of a build
+type MongoBuildPackage struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	BuildID   string             `bson:"build_id" json:"build_id"`
+	ProjectID string             `bson:"project_id,omitempty" json:"project_id,omitempty"`
+	AppName   string             `bson:"app_name,omitempty" json:"app_name,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Version   string             `bson:"version,omitempty" json:"version,omitempty"`
+	Type      string             `bson:"type,omitempty" json:"type,omitempty"`
+	PURL      string             `bson:"purl,omitempty" json:"purl,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// MongoStatusTransition records a build entering a status
+type MongoStatusTransition struct {
+	Status    string    `bson:"status" json:"status"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// MongoBuildRequest is the original build request stored with its build.
+// Credentials are never stored; env values are kept encrypted or dropped.
+type MongoBuildRequest struct {
+	Name            string   `bson:"name"`
+	ProjectID       string   `bson:"project_id"`
+	BuildCommand    string   `bson:"build_command"`
+	OutputDir       string   `bson:"output_dir"`
+	RootDir         string   `bson:"root_dir,omitempty"`
+	SSR             bool     `bson:"ssr"`
+	Port            int      `bson:"port,omitempty"`
+	SourceType      string   `bson:"source_type"`
+	RepoURL         string   `bson:"repo_url,omitempty"`
+	Branch          string   `bson:"branch,omitempty"`
+	Tag             string   `bson:"tag,omitempty"`
+	Revision        string   `bson:"revision,omitempty"`
+	GitUsername     string   `bson:"git_username,omitempty"`
+	BlobSource      string   `bson:"blob_source,omitempty"`
+	BlobKey         string   `bson:"blob_key,omitempty"`
+	BlobFormat      string   `bson:"blob_format,omitempty"`
+	Priority        string   `bson:"priority,omitempty"`
+	Backend         string   `bson:"backend,omitempty"`
+	BuilderImage    string   `bson:"builder_image,omitempty"`
+	Buildpacks      []string `bson:"buildpacks,omitempty"`
+	BuilderStrategy string   `bson:"builder_strategy,omitempty"`
+	StartCommand    string   `bson:"start_command,omitempty"`
+	Mode            string   `bson:"mode,omitempty"`
+	// EnvKeys lists the env variable names of the request
+	EnvKeys []string `bson:"env_keys,omitempty"`
+	// EncryptedEnv holds the env values, empty when no encryption key is configured
+	EncryptedEnv string `bson:"encrypted_env,omitempty"`
+}
+
+// MongoIdempotencyKey records the build created for an Idempotency-Key header
+type MongoIdempotencyKey struct {
+	// Key is the project ID and the header value joined by a colon
+	Key string `bson:"_id"`
+	// RequestHash is the SHA-256 of the request the key was first used with
+	RequestHash string    `bson:"request_hash"`
+	BuildID     string    `bson:"build_id"`
+	Name        string    `bson:"name"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+// MongoRegistry is the container registry configured for a project
+type MongoRegistry struct {
+	ProjectID string `bson:"_id"`
+	Type      string `bson:"type"`
+	Host      string `bson:"host,omitempty"`
+	Namespace string `bson:"namespace,omitempty"`
+	Username  string `bson:"username,omitempty"`
+	// EncryptedPassword holds the registry password sealed with MIRA_SPEC_ENCRYPTION_KEY
+	EncryptedPassword string    `bson:"encrypted_password,omitempty"`
+	Insecure          bool      `bson:"insecure"`
+	Private           bool      `bson:"private"`
+	UpdatedAt         time.Time `bson:"updated_at"`
 }
 
 // ToBuildStatusResponse converts MongoBuildStatus to BuildStatusResponse
@@ -83,6 +281,51 @@ func (m MongoBuildStatus) ToBuildStatusResponse() BuildStatusResponse {
 		Status:    m.Status,
 		Error:     m.Error,
 		ImageName: m.ImageName,
+
+		ImageDigest:   m.ImageDigest,
+		ImageTags:     m.ImageTags,
+		Framework:     m.Framework,
+		CommitSHA:     m.CommitSHA,
+		Mode:          m.Mode,
+		ParentBuildID: m.ParentBuildID,
+	}
+	if m.SBOM != nil {
+		response.SBOMFormats = m.SBOM.Formats
+	}
+	if m.Artifact != nil {
+		response.Artifact = &BuildArtifactResponse{
+			Digest:       m.Artifact.Digest,
+			SizeBytes:    m.Artifact.SizeBytes,
+			Layers:       m.Artifact.Layers,
+			Backend:      m.Artifact.Backend,
+			BuilderImage: m.Artifact.BuilderImage,
+			Framework:    m.Artifact.Framework,
+		}
+		for _, buildpack := range m.Artifact.Buildpacks {
+			response.Artifact.Buildpacks = append(response.Artifact.Buildpacks, BuildpackResponse(buildpack))
+		}
+		for _, step := range m.Artifact.StepDurations {
+			response.Artifact.StepDurations = append(response.Artifact.StepDurations, StepDurationResponse(step))
+		}
+	}
+	if m.Scan != nil {
+		response.Scan = &ScanReportResponse{
+			Scanner:   m.Scan.Scanner,
+			Packages:  m.Scan.Packages,
+			Counts:    m.Scan.Counts,
+			Blocked:   m.Scan.Blocked,
+			ScannedAt: m.Scan.ScannedAt.Format("2006-01-02T15:04:05Z07:00"),
+		}
+		for _, v := range m.Scan.Vulnerabilities {
+			response.Scan.Vulnerabilities = append(response.Scan.Vulnerabilities, VulnerabilityResponse(v))
+		}
+	}
+
+	for _, transition := range m.StatusHistory {
+		response.StatusHistory = append(response.StatusHistory, BuildStatusTransitionResponse{
+			Status:    transition.Status,
+			Timestamp: transition.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+		})
 	}
 
 	if !m.StartedAt.IsZero() {
@@ -95,6 +338,22 @@ func (m MongoBuildStatus) ToBuildStatusResponse() BuildStatusResponse {
 	return response
 }
 
+// ToRegistryResponse converts a stored registry to its response
+func (m MongoRegistry) ToRegistryResponse() RegistryResponse {
+	registry := common.ImageRegistry{Type: m.Type, Host: m.Host, Private: m.Private}
+	return RegistryResponse{
+		ProjectID:   m.ProjectID,
+		Type:        m.Type,
+		Host:        registry.RegistryHost(),
+		Namespace:   m.Namespace,
+		Username:    m.Username,
+		HasPassword: m.EncryptedPassword != "",
+		Insecure:    m.Insecure,
+		Private:     registry.IsPrivate(),
+		UpdatedAt:   m.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
 // ToMongoBuildStatus converts a common BuildStatus to MongoBuildStatus
 func ToMongoBuildStatus(buildID, projectID, appName, status string, startedAt, completedAt time.Time, error, imageName string) MongoBuildStatus {
 	now := time.Now()
@@ -111,3 +370,13 @@ func ToMongoBuildStatus(buildID, projectID, appName, status string, startedAt, c
 		UpdatedAt:   now,
 	}
 }
+
+// ToSecurityPolicyResponse converts a stored security policy to its response
+func (m MongoSecurityPolicy) ToSecurityPolicyResponse() SecurityPolicyResponse {
+	return SecurityPolicyResponse{
+		ProjectID: m.ProjectID,
+		FailOn:    m.FailOn,
+		Ignore:    m.Ignore,
+		UpdatedAt: m.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 // BuildResponse represents the response when starting a containerization build
 type BuildResponse struct {
 	Message string    `json:"message" example:"Image generation started"`
@@ -74,7 +76,70 @@ type BuildStatusResponse struct {
 	StartedAt   string `json:"started_at,omitempty" example:"2024-01-01T12:00:00Z"`
 	CompletedAt string `json:"completed_at,omitempty" example:"2024-01-01T12:30:00Z"`
 	Error       string `json:"error,omitempty" example:"Build failed"`
-	ImageName   string `json:"image_name,omitempty" example:"my-app:latest"`
+	ImageName   string `json:"image_name,omitempty" example:"my-app@sha256:9f2c4e0d5b1a7c3e8f6d2b4a1c9e7f5d3b2a1c0e9f8d7c6b5a4e3d2c1b0a9f8e"`
+
+	ImageDigest   string                          `json:"image_digest,omitempty" example:"sha256:9f2c4e0d5b1a7c3e8f6d2b4a1c9e7f5d3b2a1c0e9f8d7c6b5a4e3d2c1b0a9f8e"`
+	ImageTags     []string                        `json:"image_tags,omitempty" example:"3f9a1c2,550e8400-e29b-41d4-a716-446655440000,latest"`
+	Framework     string                          `json:"framework,omitempty" example:"react"`
+	CommitSHA     string                          `json:"commit_sha,omitempty" example:"3f9a1c2e8b7d6a5f4e3d2c1b0a9f8e7d6c5b4a39"`
+	Mode          string                          `json:"mode,omitempty" example:"full"`
+	SBOMFormats   []string                        `json:"sbom_formats,omitempty" example:"cyclonedx,spdx"`
+	Artifact      *BuildArtifactResponse          `json:"artifact,omitempty"`
+	Scan          *ScanReportResponse             `json:"scan,omitempty"`
+	ParentBuildID string                          `json:"parent_build_id,omitempty" example:"3f1c2a9e-6b7d-4e8f-9a0b-1c2d3e4f5a6b"`
+	StatusHistory []BuildStatusTransitionResponse `json:"status_history,omitempty"`
+}
+
+// BuildStatusTransitionResponse represents a build entering a status
+type BuildStatusTransitionResponse struct {
+	Status    string `json:"status" example:"running"`
+	Timestamp string `json:"timestamp" example:"2024-01-01T12:00:05Z"`
+}
+
+// BuildArtifactResponse describes the image a build produced and how long each step took
+type BuildArtifactResponse struct {
+	Digest string `json:"digest,omitempty" example:"sha256:9f2c4e0d5b1a7c3e8f6d2b4a1c9e7f5d3b2a1c0e9f8d7c6b5a4e3d2c1b0a9f8e"`
+	// SizeBytes is the compressed size in the registry, or the uncompressed size of images that were not pushed
+	SizeBytes     int64                  `json:"size_bytes,omitempty" example:"104857600"`
+	Layers        int                    `json:"layers,omitempty" example:"12"`
+	Backend       string                 `json:"backend,omitempty" example:"buildpacks"`
+	BuilderImage  string                 `json:"builder_image,omitempty" example:"paketobuildpacks/builder-jammy-base"`
+	Buildpacks    []BuildpackResponse    `json:"buildpacks,omitempty"`
+	Framework     string                 `json:"framework,omitempty" example:"react"`
+	StepDurations []StepDurationResponse `json:"step_durations,omitempty"`
+}
+
+// BuildpackResponse represents a buildpack that took part in a build
+type BuildpackResponse struct {
+	ID      string `json:"id" example:"paketo-buildpacks/node-engine"`
+	Version string `json:"version,omitempty" example:"3.2.1"`
+}
+
+// StepDurationResponse represents how long a pipeline step of a build ran
+type StepDurationResponse struct {
+	Step       string `json:"step" example:"build"`
+	DurationMS int64  `json:"duration_ms" example:"93250"`
+}
+
+// ScanReportResponse represents the vulnerability scan of the image of a build
+type ScanReportResponse struct {
+	Scanner         string                  `json:"scanner" example:"vulndb"`
+	Packages        int                     `json:"packages" example:"412"`
+	Vulnerabilities []VulnerabilityResponse `json:"vulnerabilities,omitempty"`
+	Counts          map[string]int          `json:"counts,omitempty"`
+	// Blocked is true when the security policy of the project failed the build
+	Blocked   bool   `json:"blocked" example:"false"`
+	ScannedAt string `json:"scanned_at" example:"2024-01-01T12:20:00Z"`
+}
+
+// VulnerabilityResponse represents a vulnerability found in an image
+type VulnerabilityResponse struct {
+	ID           string `json:"id" example:"CVE-2021-23337"`
+	Package      string `json:"package" example:"lodash"`
+	Version      string `json:"version,omitempty" example:"4.17.20"`
+	Severity     string `json:"severity" example:"high"`
+	FixedVersion string `json:"fixed_version,omitempty" example:"4.17.21"`
+	Summary      string `json:"summary,omitempty" example:"Command injection in lodash"`
 }
 
 // BuildsResponse represents the response for builds list
@@ -86,3 +151,142 @@ type BuildsResponse struct {
 	Limit  int                   `json:"limit" example:"10"`
 	Pages  int                   `json:"pages" example:"5"`
 }
+
+// BuilderLoadResponse represents the load of a single image builder
+type BuilderLoadResponse struct {
+	BuilderID     string `json:"builder_id" example:"mira-image-builder-7d9c-1a2b3c4d"`
+	Active        int    `json:"active" example:"2"`
+	Queued        int    `json:"queued" example:"1"`
+	MaxConcurrent int    `json:"max_concurrent" example:"2"`
+	QueueSize     int    `json:"queue_size" example:"2"`
+	LastSeen      string `json:"last_seen" example:"2024-01-01T12:00:00Z"`
+}
+
+// BuildersResponse represents the response for the live image builders
+type BuildersResponse struct {
+	Builders      []BuilderLoadResponse `json:"builders"`
+	Count         int                   `json:"count" example:"2"`
+	Active        int                   `json:"active" example:"3"`
+	Queued        int                   `json:"queued" example:"1"`
+	MaxConcurrent int                   `json:"max_concurrent" example:"4"`
+}
+
+// BuildCancelResponse represents the response when requesting a build cancellation
+type BuildCancelResponse struct {
+	Message string          `json:"message" example:"Build cancellation requested"`
+	Data    BuildCancelData `json:"data"`
+}
+
+// BuildCancelData contains the build the cancellation was requested for
+type BuildCancelData struct {
+	BuildID string `json:"build_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+}
+
+// BuildQueueResponse represents where a build is in the build queue and when it should start and finish
+type BuildQueueResponse struct {
+	BuildID string `json:"build_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Status  string `json:"status" example:"queued"`
+	// Waiting is true while no builder picked the build up yet
+	Waiting bool `json:"waiting" example:"true"`
+	// Priority is the queue lane the build waits in, higher lanes are served first
+	Priority string `json:"priority,omitempty" example:"normal"`
+	// Position is the 1-based position among builds waiting for a builder, 0 once picked up
+	Position        int    `json:"position" example:"3"`
+	QueueLength     int    `json:"queue_length" example:"5"`
+	ActiveBuilders  int    `json:"active_builders" example:"2"`
+	RunningBuilds   int    `json:"running_builds" example:"4"`
+	Capacity        int    `json:"capacity" example:"4"`
+	EstimatedStart  string `json:"estimated_start,omitempty" example:"2024-01-01T12:04:00Z"`
+	EstimatedFinish string `json:"estimated_finish,omitempty" example:"2024-01-01T12:09:30Z"`
+	// EstimateBasis tells which past builds the estimate is based on: app, framework, all or default
+	EstimateBasis string `json:"estimate_basis,omitempty" example:"app"`
+}
+
+// BuildCacheEntryResponse describes a build cache of an app
+type BuildCacheEntryResponse struct {
+	Name   string `json:"name" example:"mira-cache-550e8400-e29b-41d4-a716-446655440000-my-app.build"`
+	Format string `json:"format" example:"volume"`
+	Exists bool   `json:"exists" example:"true"`
+	// SizeBytes is -1 when the size is unknown
+	SizeBytes int64  `json:"size_bytes" example:"104857600"`
+	CreatedAt string `json:"created_at,omitempty" example:"2024-01-01T12:00:00Z"`
+	Cleared   bool   `json:"cleared,omitempty" example:"false"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BuilderCacheResponse describes the build caches of an app on one image builder
+type BuilderCacheResponse struct {
+	BuilderID string                    `json:"builder_id" example:"mira-image-builder-7d9c-1a2b3c4d"`
+	Caches    []BuildCacheEntryResponse `json:"caches"`
+	Error     string                    `json:"error,omitempty"`
+}
+
+// BuildCacheResponse represents the build caches of an app across the image builders
+type BuildCacheResponse struct {
+	ProjectID string                 `json:"project_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	AppName   string                 `json:"app_name" example:"my-app"`
+	Builders  []BuilderCacheResponse `json:"builders"`
+}
+
+// RegistryResponse represents the container registry of a project. Passwords are never returned.
+type RegistryResponse struct {
+	ProjectID   string `json:"project_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Type        string `json:"type" example:"ghcr"`
+	Host        string `json:"host,omitempty" example:"ghcr.io"`
+	Namespace   string `json:"namespace,omitempty" example:"my-org"`
+	Username    string `json:"username,omitempty" example:"my-user"`
+	HasPassword bool   `json:"has_password" example:"true"`
+	Insecure    bool   `json:"insecure" example:"false"`
+	Private     bool   `json:"private" example:"true"`
+	// Default is true when the project has no registry and uses the default Docker Hub account
+	Default   bool   `json:"default" example:"false"`
+	UpdatedAt string `json:"updated_at,omitempty" example:"2024-01-01T12:00:00Z"`
+}
+
+// SBOMDocumentResponse is an SBOM document a buildpack wrote for an image
+type SBOMDocumentResponse struct {
+	Buildpack string `json:"buildpack" example:"paketo-buildpacks/npm-install"`
+	Layer     string `json:"layer,omitempty" example:"launch-modules"`
+	Format    string `json:"format" example:"cyclonedx"`
+	// Document is the SBOM as written by the buildpack
+	Document json.RawMessage `json:"document" swaggertype:"object"`
+}
+
+// BuildSBOMResponse represents the SBOM of the image of a build
+type BuildSBOMResponse struct {
+	BuildID   string                 `json:"build_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Format    string                 `json:"format,omitempty" example:"cyclonedx"`
+	Documents []SBOMDocumentResponse `json:"documents"`
+}
+
+// PackageBuildResponse is a build whose image contains a package
+type PackageBuildResponse struct {
+	BuildID   string `json:"build_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	ProjectID string `json:"project_id,omitempty" example:"550e8400-e29b-41d4-a716-446655440000"`
+	AppName   string `json:"app_name,omitempty" example:"my-app"`
+	Name      string `json:"name" example:"lodash"`
+	Version   string `json:"version,omitempty" example:"4.17.20"`
+	Type      string `json:"type,omitempty" example:"library"`
+	PURL      string `json:"purl,omitempty" example:"pkg:npm/lodash@4.17.20"`
+	CreatedAt string `json:"created_at" example:"2024-01-01T12:00:00Z"`
+}
+
+// PackageBuildsResponse represents the builds containing a package
+type PackageBuildsResponse struct {
+	Builds []PackageBuildResponse `json:"builds"`
+	Count  int                    `json:"count" example:"2"`
+	Total  int64                  `json:"total" example:"2"`
+	Page   int                    `json:"page" example:"1"`
+	Limit  int                    `json:"limit" example:"10"`
+	Pages  int                    `json:"pages" example:"1"`
+}
+
+// SecurityPolicyResponse represents the vulnerability scan policy of a project
+type SecurityPolicyResponse struct {
+	ProjectID string   `json:"project_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	FailOn    string   `json:"fail_on,omitempty" example:"critical"`
+	Ignore    []string `json:"ignore,omitempty" example:"CVE-2021-23337"`
+	// Default is true when the project has no policy and scans only report
+	Default   bool   `json:"default" example:"false"`
+	UpdatedAt string `json:"updated_at,omitempty" example:"2024-01-01T12:00:00Z"`
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"log"
+
 	handlers "mira/cmd/api/handlers"
 	"mira/cmd/api/services"
 	common "mira/cmd/common"
@@ -9,28 +11,53 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-// SetupRoutes configures all application routes
-func SetupRoutes(app *fiber.App, natsClient *common.NATSClient, mongoConfig *config.MongoDBConfig) {
+// SetupRoutes configures all application routes. It returns a function that
+// closes the open log streams so the server can shut down.
+func SetupRoutes(app *fiber.App, natsClient *common.NATSClient, mongoConfig *config.MongoDBConfig, serverConfig *config.ServerConfig) func() {
 	// Initialize MongoDB service
 	var mongoService *services.MongoLogService
+	var buildRequests *services.BuildRequestService
+	var idempotency *services.IdempotencyService
+	var registries *services.RegistryService
+	var sboms *services.SBOMService
+	var policies *services.SecurityPolicyService
 	if mongoConfig != nil && mongoConfig.Client != nil {
 		mongoService = services.NewMongoLogService(mongoConfig)
+		buildRequests = services.NewBuildRequestService(mongoService)
+		idempotency = services.NewIdempotencyService(mongoConfig, serverConfig.IdempotencyWindow)
+		registries = services.NewRegistryService(mongoConfig)
+		sboms = services.NewSBOMService(mongoConfig, natsClient)
+		policies = services.NewSecurityPolicyService(mongoConfig)
+	}
+	blobs, err := common.NewBlobStore(serverConfig.BlobStore, serverConfig.BlobDir, serverConfig.BlobRetention, natsClient)
+	if err != nil {
+		log.Printf("Warning: source archive uploads are disabled: %v", err)
 	}
+	builderRegistry := services.NewBuilderRegistry(natsClient)
+	queueService := services.NewQueueService(natsClient, mongoService, builderRegistry)
 
 	// Setup all route groups
 	// home route
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Welcome to MIRA API Server access the docs at /apidocs/")
 	})
-	setupImageRoutes(app, natsClient)
-	setupLogRoutes(app, natsClient, mongoService)
+	setupImageRoutes(app, natsClient, buildRequests, idempotency, registries, policies, blobs)
+	logHandler := setupLogRoutes(app, natsClient, mongoService, queueService)
+	setupBuildRoutes(app, natsClient, mongoService, buildRequests, queueService, registries, policies)
+	setupBuilderRoutes(app, builderRegistry)
+	setupCacheRoutes(app, natsClient, registries)
+	setupRegistryRoutes(app, registries)
+	setupSBOMRoutes(app, sboms)
+	setupSecurityPolicyRoutes(app, policies)
 	setupGitUserRoutes(app)
 	setupGitOAuthRoutes(app)
+
+	return logHandler.CloseStreams
 }
 
 // setupImageRoutes configures image containerization routes
-func setupImageRoutes(app *fiber.App, natsClient *common.NATSClient) {
-	imageHandler := handlers.NewImageHandler(natsClient)
+func setupImageRoutes(app *fiber.App, natsClient *common.NATSClient, buildRequests *services.BuildRequestService, idempotency *services.IdempotencyService, registries *services.RegistryService, policies *services.SecurityPolicyService, blobs common.BlobStore) {
+	imageHandler := handlers.NewImageHandler(natsClient, buildRequests, idempotency, registries, policies, blobs)
 	if imageHandler == nil {
 		panic("Failed to create image handler")
 	}
@@ -42,8 +69,8 @@ func setupImageRoutes(app *fiber.App, natsClient *common.NATSClient) {
 }
 
 // setupLogRoutes configures WebSocket log streaming routes
-func setupLogRoutes(app *fiber.App, natsClient *common.NATSClient, mongoService *services.MongoLogService) {
-	logHandler := handlers.NewLogHandler(natsClient, mongoService)
+func setupLogRoutes(app *fiber.App, natsClient *common.NATSClient, mongoService *services.MongoLogService, queueService *services.QueueService) *handlers.LogHandler {
+	logHandler := handlers.NewLogHandler(natsClient, mongoService, queueService)
 
 	// WebSocket endpoint for streaming logs
 	app.Get("/api/logs/:buildId", logHandler.WebSocketUpgrade)
@@ -54,6 +81,66 @@ func setupLogRoutes(app *fiber.App, natsClient *common.NATSClient, mongoService
 	app.Get("/api/logs", logHandler.GetBuildLogsFromMongoDB)
 	app.Get("/api/logs/stats", logHandler.GetLogStats)
 	app.Get("/api/builds", logHandler.GetBuilds)
+
+	return logHandler
+}
+
+// setupBuildRoutes configures routes acting on individual builds
+func setupBuildRoutes(app *fiber.App, natsClient *common.NATSClient, mongoService *services.MongoLogService, buildRequests *services.BuildRequestService, queueService *services.QueueService, registries *services.RegistryService, policies *services.SecurityPolicyService) {
+	buildHandler := handlers.NewBuildHandler(natsClient, mongoService, buildRequests, queueService, registries, policies)
+
+	app.Delete("/api/builds/:buildId", buildHandler.CancelBuild)
+	app.Post("/api/builds/:buildId/cancel", buildHandler.CancelBuild)
+	app.Post("/api/builds/:buildId/retry", buildHandler.RetryBuild)
+	app.Get("/api/builds/:buildId/queue", buildHandler.GetQueuePosition)
+}
+
+// setupCacheRoutes configures routes for the build caches of apps
+func setupCacheRoutes(app *fiber.App, natsClient *common.NATSClient, registries *services.RegistryService) {
+	cacheHandler := handlers.NewCacheHandler(natsClient, registries)
+	// Clearing the cache removes volumes on the builders and the cache image
+	// in the registry of the project
+	requireProjectAccess := handlers.RequireProjectAccess(services.NewValidationService())
+
+	app.Get("/api/projects/:projectId/apps/:appName/cache", requireProjectAccess, cacheHandler.GetBuildCache)
+	app.Delete("/api/projects/:projectId/apps/:appName/cache", requireProjectAccess, cacheHandler.ClearBuildCache)
+}
+
+// setupRegistryRoutes configures the container registry routes of projects
+func setupRegistryRoutes(app *fiber.App, registries *services.RegistryService) {
+	registryHandler := handlers.NewRegistryHandler(registries)
+	// Changing the registry changes where images and credentials are pushed
+	requireProjectAccess := handlers.RequireProjectAccess(services.NewValidationService())
+
+	app.Get("/api/projects/:projectId/registry", registryHandler.GetRegistry)
+	app.Put("/api/projects/:projectId/registry", requireProjectAccess, registryHandler.SetRegistry)
+	app.Delete("/api/projects/:projectId/registry", requireProjectAccess, registryHandler.DeleteRegistry)
+}
+
+// setupSecurityPolicyRoutes configures the security policy routes of projects
+func setupSecurityPolicyRoutes(app *fiber.App, policies *services.SecurityPolicyService) {
+	policyHandler := handlers.NewSecurityPolicyHandler(policies)
+	// Changing the policy can turn off the gate blocking vulnerable deployments
+	requireProjectAccess := handlers.RequireProjectAccess(services.NewValidationService())
+
+	app.Get("/api/projects/:projectId/security-policy", policyHandler.GetSecurityPolicy)
+	app.Put("/api/projects/:projectId/security-policy", requireProjectAccess, policyHandler.SetSecurityPolicy)
+	app.Delete("/api/projects/:projectId/security-policy", requireProjectAccess, policyHandler.DeleteSecurityPolicy)
+}
+
+// setupSBOMRoutes configures the routes serving the SBOMs of built images
+func setupSBOMRoutes(app *fiber.App, sboms *services.SBOMService) {
+	sbomHandler := handlers.NewSBOMHandler(sboms)
+
+	app.Get("/api/builds/:buildId/sbom", sbomHandler.GetBuildSBOM)
+	app.Get("/api/sbom/packages", sbomHandler.FindPackageBuilds)
+}
+
+// setupBuilderRoutes configures image builder status routes
+func setupBuilderRoutes(app *fiber.App, builderRegistry *services.BuilderRegistry) {
+	builderHandler := handlers.NewBuilderHandler(builderRegistry)
+
+	app.Get("/api/builders", builderHandler.GetBuilders)
 }
 
 // setupGitUserRoutes configures Git user repository routes
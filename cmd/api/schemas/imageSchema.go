@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	common "mira/cmd/common"
 )
 
 // Validation constants
@@ -18,6 +20,12 @@ const (
 	MaxEnvVarCount        = 50
 	MaxEnvVarKeyLength    = 100
 	MaxEnvVarValueLength  = 1000
+	MaxImageRefLength     = 255
+	MaxBuildpackCount     = 20
+	MaxRegistryHostLength = 253
+	MaxIgnoredVulnCount   = 200
+	MaxVulnIDLength       = 100
+	MaxGitRefLength       = 255
 )
 
 // Validation patterns
@@ -26,6 +34,18 @@ var (
 	validNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
 	// Project ID pattern (alphanumeric with dashes/underscores)
 	validProjectIdPattern = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[1-5][a-fA-F0-9]{3}-[89abAB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$`)
+	// Image or buildpack reference, e.g. paketo-buildpacks/python or heroku/builder:24
+	validImageRefPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/:@+-]*$`)
+	// Registry host with an optional port, e.g. registry.example.com or localhost:5000
+	validRegistryHostPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]{1,5})?$`)
+	// Repository namespace, e.g. my-org or team/apps
+	validNamespacePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	// Git branch or tag name, checked further in validateGitRef
+	validGitRefPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	// Full or abbreviated commit SHA
+	validCommitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+	// Vulnerability ID, e.g. CVE-2021-23337 or GHSA-35jh-r3h4-6jhm
+	validVulnIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
 	// Safe build command pattern (no shell injection characters)
 	dangerousCommandPattern = regexp.MustCompile(`[;&|<>$\x60\\]`)
 )
@@ -50,6 +70,21 @@ type GenerateImageRequest struct {
 	SSR             bool              `json:"ssr" example:"false" doc:"Enable server-side rendering"`
 	Env             map[string]string `json:"env" doc:"Environment variables for the build"`
 	Repo            string            `json:"repo" example:"https://github.com/user/repo.git" validate:"required" doc:"Git repository URL"`
+	Branch          string            `json:"branch,omitempty" example:"main" doc:"Git branch to build, defaults to the default branch of the repository"`
+	Tag             string            `json:"tag,omitempty" example:"v1.2.0" doc:"Git tag to build, instead of a branch"`
+	RootDirectory   string            `json:"root_directory,omitempty" example:"frontend" doc:"Directory of the app within the repository, defaults to the repository root. The build command and output directory are relative to it"`
+	Commit          string            `json:"commit,omitempty" example:"3f9a1c2e8b7d6a5f4e3d2c1b0a9f8e7d6c5b4a39" doc:"Commit SHA to build, instead of a branch or tag"`
+	Priority        string            `json:"priority,omitempty" example:"normal" enums:"high,normal,low" doc:"Build queue priority, defaults to normal"`
+	Backend         string            `json:"backend,omitempty" example:"buildpacks" enums:"buildpacks,dockerfile" doc:"Image build backend, defaults to dockerfile when the repository has a Dockerfile and buildpacks otherwise"`
+	BuilderImage    string            `json:"builder_image,omitempty" example:"paketobuildpacks/builder-jammy-base" doc:"Buildpacks builder image, defaults to the one for the detected language"`
+	Buildpacks      []string          `json:"buildpacks,omitempty" example:"paketo-buildpacks/python" doc:"Buildpacks to run, default to the ones for the detected language"`
+	BuilderStrategy string            `json:"builder_strategy,omitempty" example:"mira" enums:"language,mira" doc:"Build with the builder for the detected language or with the Mira builder, defaults to the builder configuration"`
+	StartCommand    string            `json:"start_command,omitempty" example:"npm start" doc:"Command starting server-side rendered apps built with the Mira builder"`
+	Mode            string            `json:"mode,omitempty" example:"full" enums:"build_only,build_and_push,full" doc:"How far the build goes: build_only keeps the image on the builder, build_and_push pushes it without deploying, full also validates the app name and deploys to Crane Cloud. Defaults to full"`
+
+	// Archive is the file name of the source archive uploaded with multipart
+	// requests, which are built from it instead of a git repository
+	Archive string `json:"-"`
 }
 
 // Validation functions
@@ -97,6 +132,20 @@ func validateOutputDirectory(outputDir string) error {
 	return nil
 }
 
+func validateRootDirectory(rootDir string) error {
+	if len(rootDir) > MaxOutputDirLength {
+		return ValidationError{Field: "root_directory", Message: fmt.Sprintf("must be %d characters or less", MaxOutputDirLength)}
+	}
+	cleanPath := filepath.Clean(rootDir)
+	if strings.Contains(cleanPath, "..") {
+		return ValidationError{Field: "root_directory", Message: "cannot contain parent directory references (..)"}
+	}
+	if filepath.IsAbs(cleanPath) {
+		return ValidationError{Field: "root_directory", Message: "must be a relative path"}
+	}
+	return nil
+}
+
 func validateAccessToken(token string) error {
 	if token == "" {
 		return ValidationError{Field: "access_token", Message: "is required"}
@@ -162,6 +211,37 @@ func validateGitRepo(repo string) error {
 	return nil
 }
 
+// validateGitRef checks the branch, tag or commit to build. At most one can
+// be set, and names follow the git ref format rules.
+func validateGitRef(branch, tag, commit string) error {
+	set := 0
+	for _, ref := range []string{branch, tag, commit} {
+		if ref != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return ValidationError{Field: "branch", Message: "only one of branch, tag and commit can be set"}
+	}
+
+	for field, name := range map[string]string{"branch": branch, "tag": tag} {
+		if name == "" {
+			continue
+		}
+		if len(name) > MaxGitRefLength || !validGitRefPattern.MatchString(name) ||
+			strings.Contains(name, "..") || strings.Contains(name, "//") ||
+			strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+			strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") {
+			return ValidationError{Field: field, Message: "must be a valid git " + field + " name"}
+		}
+	}
+
+	if commit != "" && !validCommitSHAPattern.MatchString(commit) {
+		return ValidationError{Field: "commit", Message: "must be a commit SHA of 7 to 40 hexadecimal characters"}
+	}
+	return nil
+}
+
 func validateEnvVars(env map[string]string) error {
 	if len(env) > MaxEnvVarCount {
 		return ValidationError{Field: "env", Message: fmt.Sprintf("cannot have more than %d environment variables", MaxEnvVarCount)}
@@ -187,6 +267,78 @@ func validateEnvVars(env map[string]string) error {
 	return nil
 }
 
+func validatePriority(priority string) error {
+	if priority != "" && !common.IsValidBuildPriority(priority) {
+		return ValidationError{Field: "priority", Message: fmt.Sprintf("must be one of %s", strings.Join(common.BuildPriorities, ", "))}
+	}
+	return nil
+}
+
+func validateBackend(backend string) error {
+	switch backend {
+	case "", common.BUILD_BACKEND_BUILDPACKS, common.BUILD_BACKEND_DOCKERFILE:
+		return nil
+	}
+	return ValidationError{Field: "backend", Message: fmt.Sprintf("must be %s or %s", common.BUILD_BACKEND_BUILDPACKS, common.BUILD_BACKEND_DOCKERFILE)}
+}
+
+func validateBuilderOverrides(builderImage string, buildpacks []string) error {
+	if builderImage != "" {
+		if len(builderImage) > MaxImageRefLength {
+			return ValidationError{Field: "builder_image", Message: fmt.Sprintf("must be %d characters or less", MaxImageRefLength)}
+		}
+		if !validImageRefPattern.MatchString(builderImage) {
+			return ValidationError{Field: "builder_image", Message: "must be a valid image reference"}
+		}
+	}
+
+	if len(buildpacks) > MaxBuildpackCount {
+		return ValidationError{Field: "buildpacks", Message: fmt.Sprintf("cannot have more than %d buildpacks", MaxBuildpackCount)}
+	}
+	for _, buildpack := range buildpacks {
+		if len(buildpack) > MaxImageRefLength || !validImageRefPattern.MatchString(buildpack) {
+			return ValidationError{Field: "buildpacks", Message: fmt.Sprintf("'%s' is not a valid buildpack reference", buildpack)}
+		}
+	}
+	return nil
+}
+
+func validateBuilderStrategy(strategy string) error {
+	switch strategy {
+	case "", common.BUILDER_STRATEGY_LANGUAGE, common.BUILDER_STRATEGY_MIRA:
+		return nil
+	}
+	return ValidationError{Field: "builder_strategy", Message: fmt.Sprintf("must be %s or %s", common.BUILDER_STRATEGY_LANGUAGE, common.BUILDER_STRATEGY_MIRA)}
+}
+
+func validateMode(mode string) error {
+	if mode != "" && !common.IsValidBuildMode(mode) {
+		return ValidationError{Field: "mode", Message: fmt.Sprintf("must be one of %s", strings.Join(common.BuildModes, ", "))}
+	}
+	return nil
+}
+
+func validateStartCommand(startCommand string) error {
+	if len(startCommand) > MaxBuildCommandLength {
+		return ValidationError{Field: "start_command", Message: fmt.Sprintf("must be %d characters or less", MaxBuildCommandLength)}
+	}
+	if dangerousCommandPattern.MatchString(startCommand) {
+		return ValidationError{Field: "start_command", Message: "contains potentially dangerous shell characters"}
+	}
+	return nil
+}
+
+// validateSourceArchive checks the uploaded archive of a request, which cannot also name a git repository
+func validateSourceArchive(req *GenerateImageRequest) error {
+	if common.SourceArchiveFormat(req.Archive) == "" {
+		return ValidationError{Field: "file", Message: "must be a .zip, .tar.gz or .tgz archive"}
+	}
+	if req.Repo != "" || req.Branch != "" || req.Tag != "" || req.Commit != "" {
+		return ValidationError{Field: "repo", Message: "cannot be set when a source archive is uploaded"}
+	}
+	return nil
+}
+
 // ValidateGenerateImageRequest performs comprehensive validation
 func ValidateGenerateImageRequest(req *GenerateImageRequest) []ValidationError {
 	var errors []ValidationError
@@ -204,6 +356,8 @@ func ValidateGenerateImageRequest(req *GenerateImageRequest) []ValidationError {
 		errors = append(errors, err.(ValidationError))
 	}
 
+	// Every mode builds with the settings of the project, so the token is
+	// required even when the image is not deployed
 	if err := validateAccessToken(req.AccessToken); err != nil {
 		errors = append(errors, err.(ValidationError))
 	}
@@ -212,8 +366,22 @@ func ValidateGenerateImageRequest(req *GenerateImageRequest) []ValidationError {
 		errors = append(errors, err.(ValidationError))
 	}
 
-	// Validate git repository
-	if err := validateGitRepo(req.Repo); err != nil {
+	// Validate the source: an uploaded archive or a git repository
+	if req.Archive != "" {
+		if err := validateSourceArchive(req); err != nil {
+			errors = append(errors, err.(ValidationError))
+		}
+	} else {
+		if err := validateGitRepo(req.Repo); err != nil {
+			errors = append(errors, err.(ValidationError))
+		}
+
+		if err := validateGitRef(req.Branch, req.Tag, req.Commit); err != nil {
+			errors = append(errors, err.(ValidationError))
+		}
+	}
+
+	if err := validateRootDirectory(req.RootDirectory); err != nil {
 		errors = append(errors, err.(ValidationError))
 	}
 
@@ -224,5 +392,141 @@ func ValidateGenerateImageRequest(req *GenerateImageRequest) []ValidationError {
 		}
 	}
 
+	if err := validatePriority(req.Priority); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if err := validateBackend(req.Backend); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if err := validateBuilderOverrides(req.BuilderImage, req.Buildpacks); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if err := validateBuilderStrategy(req.BuilderStrategy); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if err := validateStartCommand(req.StartCommand); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if err := validateMode(req.Mode); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	return errors
+}
+
+// RetryBuildRequest represents the JSON request body for retrying a build.
+// Credentials are not stored with builds and have to be supplied again.
+type RetryBuildRequest struct {
+	AccessToken string            `json:"access_token" validate:"required" doc:"Crane Cloud authentication token"`
+	GitPassword string            `json:"git_password,omitempty" doc:"Password or token for private git repositories"`
+	Env         map[string]string `json:"env" doc:"Environment variables overriding or re-supplying the stored ones"`
+	Priority    string            `json:"priority,omitempty" example:"high" enums:"high,normal,low" doc:"Build queue priority, defaults to the priority of the original build"`
+	Mode        string            `json:"mode,omitempty" example:"full" enums:"build_only,build_and_push,full" doc:"Build mode, defaults to the mode of the original build"`
+}
+
+// ValidateRetryBuildRequest validates a retry request
+func ValidateRetryBuildRequest(req *RetryBuildRequest) []ValidationError {
+	var errors []ValidationError
+
+	if err := validateAccessToken(req.AccessToken); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if req.Env != nil {
+		if err := validateEnvVars(req.Env); err != nil {
+			errors = append(errors, err.(ValidationError))
+		}
+	}
+
+	if err := validatePriority(req.Priority); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	if err := validateMode(req.Mode); err != nil {
+		errors = append(errors, err.(ValidationError))
+	}
+
+	return errors
+}
+
+// RegistryRequest represents the JSON request body for configuring the container registry of a project
+type RegistryRequest struct {
+	Type      string `json:"type" example:"ghcr" enums:"dockerhub,ghcr,private,local" validate:"required" doc:"Registry type"`
+	Host      string `json:"host,omitempty" example:"registry.example.com:5000" doc:"Registry host, required for private and local registries"`
+	Namespace string `json:"namespace,omitempty" example:"my-org" doc:"User or organisation owning the image repositories, required for dockerhub and ghcr"`
+	Username  string `json:"username,omitempty" example:"my-user" doc:"Registry username"`
+	Password  string `json:"password,omitempty" doc:"Registry password or token, kept when omitted and the username is unchanged"`
+	Insecure  bool   `json:"insecure,omitempty" example:"false" doc:"Push over plain HTTP, only for private and local registries"`
+	Private   bool   `json:"private,omitempty" example:"true" doc:"Images need the credentials to be pulled, always true for private registries"`
+}
+
+// ValidateRegistryRequest validates a registry configuration
+func ValidateRegistryRequest(req *RegistryRequest) []ValidationError {
+	var errors []ValidationError
+
+	if !common.IsValidRegistryType(req.Type) {
+		errors = append(errors, ValidationError{Field: "type", Message: fmt.Sprintf("must be one of %s", strings.Join(common.RegistryTypes, ", "))})
+	}
+
+	hosted := req.Type == common.REGISTRY_PRIVATE || req.Type == common.REGISTRY_LOCAL
+	switch {
+	case hosted && req.Host == "":
+		errors = append(errors, ValidationError{Field: "host", Message: "is required for private and local registries"})
+	case !hosted && req.Host != "":
+		errors = append(errors, ValidationError{Field: "host", Message: "can only be set for private and local registries"})
+	case len(req.Host) > MaxRegistryHostLength || (req.Host != "" && !validRegistryHostPattern.MatchString(req.Host)):
+		errors = append(errors, ValidationError{Field: "host", Message: "must be a host name with an optional port"})
+	}
+
+	if req.Namespace == "" && !hosted {
+		errors = append(errors, ValidationError{Field: "namespace", Message: "is required for dockerhub and ghcr registries"})
+	} else if req.Namespace != "" && (len(req.Namespace) > MaxImageRefLength || !validNamespacePattern.MatchString(req.Namespace)) {
+		errors = append(errors, ValidationError{Field: "namespace", Message: "must contain only lowercase letters, numbers, separators and slashes"})
+	}
+
+	if req.Password != "" && req.Username == "" {
+		errors = append(errors, ValidationError{Field: "username", Message: "is required with a password"})
+	}
+	if len(req.Username) > MaxTokenLength || len(req.Password) > MaxTokenLength {
+		errors = append(errors, ValidationError{Field: "password", Message: fmt.Sprintf("credentials must be %d characters or less", MaxTokenLength)})
+	}
+
+	if req.Insecure && !hosted {
+		errors = append(errors, ValidationError{Field: "insecure", Message: "can only be set for private and local registries"})
+	}
+
+	return errors
+}
+
+// SecurityPolicyRequest represents the JSON request body for configuring the vulnerability scan policy of a project
+type SecurityPolicyRequest struct {
+	FailOn string   `json:"fail_on,omitempty" example:"critical" enums:"critical,high,medium,low" doc:"Fail builds with vulnerabilities at this severity or above, scans only report when empty"`
+	Ignore []string `json:"ignore,omitempty" example:"CVE-2021-23337" doc:"IDs of vulnerabilities that never fail a build"`
+}
+
+// ValidateSecurityPolicyRequest validates a security policy
+func ValidateSecurityPolicyRequest(req *SecurityPolicyRequest) []ValidationError {
+	var errors []ValidationError
+
+	req.FailOn = strings.ToLower(strings.TrimSpace(req.FailOn))
+	if req.FailOn != "" && (req.FailOn == common.SEVERITY_UNKNOWN || !common.IsValidSeverity(req.FailOn)) {
+		errors = append(errors, ValidationError{Field: "fail_on", Message: "must be one of critical, high, medium, low"})
+	}
+
+	if len(req.Ignore) > MaxIgnoredVulnCount {
+		errors = append(errors, ValidationError{Field: "ignore", Message: fmt.Sprintf("must contain %d vulnerabilities or less", MaxIgnoredVulnCount)})
+	}
+	for _, id := range req.Ignore {
+		if len(id) > MaxVulnIDLength || !validVulnIDPattern.MatchString(id) {
+			errors = append(errors, ValidationError{Field: "ignore", Message: fmt.Sprintf("'%s' is not a valid vulnerability ID", id)})
+			break
+		}
+	}
+
 	return errors
 }
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"mira/cmd/api/models"
+	common "mira/cmd/common"
+)
+
+// BuildRequestService stores accepted build requests so that builds can be
+// retried later. Access tokens and git passwords are never stored. Env values
+// are encrypted with MIRA_SPEC_ENCRYPTION_KEY, or dropped when it is not set.
+type BuildRequestService struct {
+	mongoService *MongoLogService
+	aead         cipher.AEAD
+}
+
+// NewBuildRequestService creates a new build request service
+func NewBuildRequestService(mongoService *MongoLogService) *BuildRequestService {
+	service := &BuildRequestService{
+		mongoService: mongoService,
+		aead:         common.NewSpecCipher(),
+	}
+	if service.aead == nil {
+		log.Printf("MIRA_SPEC_ENCRYPTION_KEY not set, env values of stored build requests will have to be re-supplied on retry")
+	}
+	return service
+}
+
+// Save stores the request of a newly accepted build. parentBuildID is empty
+// unless the build is a retry.
+func (s *BuildRequestService) Save(buildReq *common.BuildRequest, parentBuildID string) error {
+	stored := &models.MongoBuildRequest{
+		Name:            buildReq.Name,
+		ProjectID:       buildReq.Spec.ProjectID,
+		BuildCommand:    buildReq.Spec.BuildCommand,
+		OutputDir:       buildReq.Spec.OutputDir,
+		RootDir:         buildReq.Spec.RootDir,
+		SSR:             buildReq.Spec.SSR,
+		Port:            buildReq.Spec.Port,
+		SourceType:      buildReq.Spec.Source.Type,
+		RepoURL:         buildReq.Spec.Source.GitRepo.URL,
+		Branch:          buildReq.Spec.Source.GitRepo.Branch,
+		Tag:             buildReq.Spec.Source.GitRepo.Tag,
+		Revision:        buildReq.Spec.Source.GitRepo.Revision,
+		GitUsername:     buildReq.Spec.Source.GitRepo.Username,
+		BlobSource:      buildReq.Spec.Source.BlobFile.Source,
+		BlobKey:         buildReq.Spec.Source.BlobFile.Key,
+		BlobFormat:      buildReq.Spec.Source.BlobFile.Format,
+		Priority:        buildReq.Priority,
+		Backend:         buildReq.Spec.Backend,
+		BuilderImage:    buildReq.Spec.BuilderImage,
+		Buildpacks:      buildReq.Spec.Buildpacks,
+		BuilderStrategy: buildReq.Spec.BuilderStrategy,
+		StartCommand:    buildReq.Spec.StartCommand,
+		Mode:            buildReq.Spec.Mode,
+	}
+
+	for key := range buildReq.Spec.Env {
+		stored.EnvKeys = append(stored.EnvKeys, key)
+	}
+	sort.Strings(stored.EnvKeys)
+
+	if len(buildReq.Spec.Env) > 0 && s.aead != nil {
+		encrypted, err := s.encrypt(buildReq.Spec.Env)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt env: %v", err)
+		}
+		stored.EncryptedEnv = encrypted
+	}
+
+	return s.mongoService.SaveBuildRequest(buildReq.ID, parentBuildID, stored)
+}
+
+// Rebuild creates a new build request from the stored request of a build.
+// The caller re-supplies the credentials, env values override the stored ones.
+// It returns the names of env variables whose values were not stored and
+// were not re-supplied.
+func (s *BuildRequestService) Rebuild(stored *models.MongoBuildRequest, buildID, accessToken, gitPassword string, env map[string]string) (*common.BuildRequest, []string, error) {
+	buildReq := &common.BuildRequest{
+		ID:        buildID,
+		Name:      stored.Name,
+		Priority:  common.NormalizeBuildPriority(stored.Priority),
+		Timestamp: time.Now(),
+	}
+	buildReq.Spec.BuildCommand = stored.BuildCommand
+	buildReq.Spec.OutputDir = stored.OutputDir
+	buildReq.Spec.RootDir = stored.RootDir
+	buildReq.Spec.ProjectID = stored.ProjectID
+	buildReq.Spec.AccessToken = accessToken
+	buildReq.Spec.SSR = stored.SSR
+	buildReq.Spec.Port = stored.Port
+	buildReq.Spec.Backend = stored.Backend
+	buildReq.Spec.BuilderImage = stored.BuilderImage
+	buildReq.Spec.Buildpacks = stored.Buildpacks
+	buildReq.Spec.BuilderStrategy = stored.BuilderStrategy
+	buildReq.Spec.StartCommand = stored.StartCommand
+	buildReq.Spec.Mode = common.NormalizeBuildMode(stored.Mode)
+	buildReq.Spec.Source.Type = stored.SourceType
+	buildReq.Spec.Source.GitRepo.URL = stored.RepoURL
+	buildReq.Spec.Source.GitRepo.Branch = stored.Branch
+	buildReq.Spec.Source.GitRepo.Tag = stored.Tag
+	buildReq.Spec.Source.GitRepo.Revision = stored.Revision
+	buildReq.Spec.Source.GitRepo.Username = stored.GitUsername
+	buildReq.Spec.Source.GitRepo.Password = gitPassword
+	buildReq.Spec.Source.BlobFile.Source = stored.BlobSource
+	buildReq.Spec.Source.BlobFile.Key = stored.BlobKey
+	buildReq.Spec.Source.BlobFile.Format = stored.BlobFormat
+
+	buildReq.Spec.Env = make(map[string]string)
+	if stored.EncryptedEnv != "" {
+		if s.aead == nil {
+			return nil, nil, fmt.Errorf("stored env is encrypted but MIRA_SPEC_ENCRYPTION_KEY is not set")
+		}
+		decrypted, err := s.decrypt(stored.EncryptedEnv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt env: %v", err)
+		}
+		buildReq.Spec.Env = decrypted
+	}
+	for key, value := range env {
+		buildReq.Spec.Env[key] = value
+	}
+
+	var missing []string
+	for _, key := range stored.EnvKeys {
+		if _, ok := buildReq.Spec.Env[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	return buildReq, missing, nil
+}
+
+// encrypt seals the env map
+func (s *BuildRequestService) encrypt(env map[string]string) (string, error) {
+	plaintext, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return common.SealSecret(s.aead, plaintext)
+}
+
+// decrypt reverses encrypt
+func (s *BuildRequestService) decrypt(encoded string) (map[string]string, error) {
+	plaintext, err := common.OpenSecret(s.aead, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var env map[string]string
+	if err := json.Unmarshal(plaintext, &env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	common "mira/cmd/common"
+)
+
+// builderLoadTTL is how long a load report counts before its builder is considered gone
+const builderLoadTTL = 30 * time.Second
+
+// BuilderRegistry keeps the latest load report of every live image builder
+type BuilderRegistry struct {
+	mu    sync.RWMutex
+	loads map[string]common.BuilderLoad
+}
+
+// NewBuilderRegistry creates a builder registry fed by builder load reports on NATS
+func NewBuilderRegistry(natsClient *common.NATSClient) *BuilderRegistry {
+	registry := &BuilderRegistry{
+		loads: make(map[string]common.BuilderLoad),
+	}
+
+	_, err := natsClient.SubscribeToBuilderLoad(registry.record)
+	if err != nil {
+		log.Printf("Failed to subscribe to builder load reports: %v", err)
+	}
+
+	return registry
+}
+
+// record stores a load report and drops builders that stopped reporting
+func (r *BuilderRegistry) record(load *common.BuilderLoad) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.loads[load.BuilderID] = *load
+	for id, l := range r.loads {
+		if time.Since(l.Timestamp) > builderLoadTTL {
+			delete(r.loads, id)
+		}
+	}
+}
+
+// Builders returns the load of every builder that reported recently, sorted by builder ID
+func (r *BuilderRegistry) Builders() []common.BuilderLoad {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	builders := make([]common.BuilderLoad, 0, len(r.loads))
+	for _, load := range r.loads {
+		if time.Since(load.Timestamp) <= builderLoadTTL {
+			builders = append(builders, load)
+		}
+	}
+
+	sort.Slice(builders, func(i, j int) bool {
+		return builders[i].BuilderID < builders[j].BuilderID
+	})
+	return builders
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"mira/cmd/api/models"
+	"mira/cmd/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent again with a different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+
+// IdempotencyService remembers which build an Idempotency-Key header created
+// so that retried requests return the original build instead of queueing a new one
+type IdempotencyService struct {
+	collection *mongo.Collection
+	window     time.Duration
+}
+
+// NewIdempotencyService creates a new idempotency service. Keys are honoured for window.
+func NewIdempotencyService(mongoConfig *config.MongoDBConfig, window time.Duration) *IdempotencyService {
+	collection := mongoConfig.GetCollection("idempotency_keys")
+
+	// Let MongoDB drop keys once the window has passed
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ttlIndex := mongo.IndexModel{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(window.Seconds())).SetName("idempotency_created_at_ttl_idx"),
+		}
+		if _, err := collection.Indexes().CreateOne(ctx, ttlIndex); err != nil {
+			log.Printf("Failed to create index idempotency_created_at_ttl_idx: %v", err)
+		}
+	}()
+
+	return &IdempotencyService{
+		collection: collection,
+		window:     window,
+	}
+}
+
+// HashRequest returns the hash a request is compared by when its key is reused
+func HashRequest(request interface{}) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Reserve claims an idempotency key of a project for a new build. It returns
+// nil when the key is free and the build should go ahead, or the record of the
+// original build when the key was already used for the same request within
+// the window. ErrIdempotencyKeyReused is returned for a different request.
+func (s *IdempotencyService) Reserve(projectID, key, requestHash, buildID, name string) (*models.MongoIdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record := models.MongoIdempotencyKey{
+		Key:         projectID + ":" + key,
+		RequestHash: requestHash,
+		BuildID:     buildID,
+		Name:        name,
+		CreatedAt:   time.Now(),
+	}
+
+	// The key may expire or be released between the attempts
+	const maxAttempts = 3
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		_, err := s.collection.InsertOne(ctx, record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to store idempotency key: %v", err)
+		}
+
+		var existing models.MongoIdempotencyKey
+		err = s.collection.FindOne(ctx, bson.M{"_id": record.Key}).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load idempotency key: %v", err)
+		}
+
+		// The TTL monitor only runs once a minute, expired keys may still be around
+		if time.Since(existing.CreatedAt) > s.window {
+			result, err := s.collection.ReplaceOne(ctx,
+				bson.M{"_id": record.Key, "created_at": existing.CreatedAt}, record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to store idempotency key: %v", err)
+			}
+			if result.MatchedCount == 1 {
+				return nil, nil
+			}
+			continue
+		}
+
+		if existing.RequestHash != requestHash {
+			return nil, ErrIdempotencyKeyReused
+		}
+		return &existing, nil
+	}
+
+	return nil, fmt.Errorf("failed to reserve idempotency key after %d attempts", maxAttempts)
+}
+
+// Release frees a key reserved for a build that was never queued, so that the
+// client can try again with the same key
+func (s *IdempotencyService) Release(projectID, key, buildID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": projectID + ":" + key, "build_id": buildID})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
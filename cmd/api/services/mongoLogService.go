@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"mira/cmd/api/models"
+	common "mira/cmd/common"
 	"mira/cmd/config"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -94,7 +95,13 @@ func NewMongoLogService(mongoConfig *config.MongoDBConfig) *MongoLogService {
 			Options: options.Index().SetName("builds_app_name_created_idx"),
 		}
 
-		buildsIndexes := []mongo.IndexModel{buildsProjectIndex, buildsAppNameIndex}
+		// Status updates rely on a single record per build
+		buildsIDIndex := mongo.IndexModel{
+			Keys:    bson.D{{Key: "build_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("builds_build_id_idx"),
+		}
+
+		buildsIndexes := []mongo.IndexModel{buildsProjectIndex, buildsAppNameIndex, buildsIDIndex}
 		for _, idx := range buildsIndexes {
 			_, err := buildsCollection.Indexes().CreateOne(ctx, idx)
 			if err != nil {
@@ -372,31 +379,201 @@ func (s *MongoLogService) GetLogStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
-// SaveBuildStatus saves a build status to MongoDB
-func (s *MongoLogService) SaveBuildStatus(buildID, projectID, appName, status string, startedAt, completedAt time.Time, error, imageName string) error {
+// InvalidTransitionError reports a build status update that does not follow the build state machine
+type InvalidTransitionError struct {
+	BuildID string
+	From    string
+	To      string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid status transition for build %s: %s -> %s", e.BuildID, e.From, e.To)
+}
+
+// SaveBuildStatus saves a build status to MongoDB. Updates that do not follow
+// the build state machine are rejected with an InvalidTransitionError, repeated
+// updates of the current status are ignored. Every accepted transition is
+// recorded in the status history.
+func (s *MongoLogService) SaveBuildStatus(buildStatus *common.BuildStatus) error {
 	buildsCollection := s.mongoConfig.GetCollection("builds")
 	if buildsCollection == nil {
 		return fmt.Errorf("MongoDB builds collection is not available")
 	}
 
-	mongoBuildStatus := models.ToMongoBuildStatus(buildID, projectID, appName, status, startedAt, completedAt, error, imageName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	transitionedAt := buildStatus.Timestamp
+	if transitionedAt.IsZero() {
+		transitionedAt = now
+	}
+
+	set := bson.M{
+		"status":     buildStatus.Status,
+		"updated_at": now,
+	}
+	if buildStatus.ProjectID != "" {
+		set["project_id"] = buildStatus.ProjectID
+	}
+	if buildStatus.AppName != "" {
+		set["app_name"] = buildStatus.AppName
+	}
+	if !buildStatus.StartedAt.IsZero() {
+		set["started_at"] = buildStatus.StartedAt
+	}
+	if !buildStatus.CompletedAt.IsZero() {
+		set["completed_at"] = buildStatus.CompletedAt
+	}
+	if buildStatus.Error != "" {
+		set["error"] = buildStatus.Error
+	}
+	if buildStatus.ImageName != "" {
+		set["image_name"] = buildStatus.ImageName
+	}
+	if buildStatus.ImageDigest != "" {
+		set["image_digest"] = buildStatus.ImageDigest
+	}
+	if len(buildStatus.ImageTags) > 0 {
+		set["image_tags"] = buildStatus.ImageTags
+	}
+	if buildStatus.Framework != "" {
+		set["framework"] = buildStatus.Framework
+	}
+	if buildStatus.CommitSHA != "" {
+		set["commit_sha"] = buildStatus.CommitSHA
+	}
+	if buildStatus.Attempt > 0 {
+		set["attempt"] = buildStatus.Attempt
+	}
+	if buildStatus.Scan != nil {
+		set["scan"] = models.NewMongoScanReport(buildStatus.Scan)
+	}
+	if buildStatus.Artifact != nil {
+		set["artifact"] = models.NewMongoBuildArtifact(buildStatus.Artifact)
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"created_at": now},
+		"$push": bson.M{"status_history": models.MongoStatusTransition{
+			Status:    buildStatus.Status,
+			Timestamp: transitionedAt,
+		}},
+	}
+
+	// Several API replicas may record the same update, so the transition is
+	// applied only if the status did not change since it was read
+	for retry := 0; retry < 3; retry++ {
+		var current models.MongoBuildStatus
+		err := buildsCollection.FindOne(ctx, bson.M{"build_id": buildStatus.BuildID}).Decode(&current)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to read build status: %v", err)
+		}
+
+		if err == mongo.ErrNoDocuments {
+			// The API did not record the build when it was accepted
+			opts := options.Update().SetUpsert(true)
+			_, err = buildsCollection.UpdateOne(ctx, bson.M{"build_id": buildStatus.BuildID}, update, opts)
+			if err != nil {
+				return fmt.Errorf("failed to save build status: %v", err)
+			}
+			return nil
+		}
+
+		newAttempt := buildStatus.Attempt > current.Attempt
+		if current.Status == buildStatus.Status && !newAttempt {
+			return nil
+		}
+		if !common.CanTransitionBuildStatus(current.Status, buildStatus.Status, newAttempt) {
+			return &InvalidTransitionError{
+				BuildID: buildStatus.BuildID,
+				From:    current.Status,
+				To:      buildStatus.Status,
+			}
+		}
+
+		filter := bson.M{"build_id": buildStatus.BuildID, "status": current.Status}
+		result, err := buildsCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to save build status: %v", err)
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to save build status: build %s kept changing status", buildStatus.BuildID)
+}
+
+// SaveBuildRequest stores the original request of a build and records it as
+// queued, creating the build record if the builder has not reported on it yet
+func (s *MongoLogService) SaveBuildRequest(buildID, parentBuildID string, request *models.MongoBuildRequest) error {
+	buildsCollection := s.mongoConfig.GetCollection("builds")
+	if buildsCollection == nil {
+		return fmt.Errorf("MongoDB builds collection is not available")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Use upsert to update existing build status or create new one
+	now := time.Now()
+	set := bson.M{
+		"project_id": request.ProjectID,
+		"app_name":   request.Name,
+		"request":    request,
+		"updated_at": now,
+	}
+	if parentBuildID != "" {
+		set["parent_build_id"] = parentBuildID
+	}
+	if request.Mode != "" {
+		set["mode"] = request.Mode
+	}
+
 	filter := bson.M{"build_id": buildID}
-	update := bson.M{"$set": mongoBuildStatus}
+	update := bson.M{
+		"$set": set,
+		"$setOnInsert": bson.M{
+			"status": common.BUILD_STATUS_QUEUED,
+			"status_history": []models.MongoStatusTransition{
+				{Status: common.BUILD_STATUS_QUEUED, Timestamp: now},
+			},
+			"created_at": now,
+		},
+	}
 	opts := options.Update().SetUpsert(true)
 
 	_, err := buildsCollection.UpdateOne(ctx, filter, update, opts)
 	if err != nil {
-		return fmt.Errorf("failed to save build status: %v", err)
+		return fmt.Errorf("failed to save build request: %v", err)
 	}
 
 	return nil
 }
 
+// GetBuildRecord retrieves the stored build record, including its original request
+func (s *MongoLogService) GetBuildRecord(buildID string) (*models.MongoBuildStatus, error) {
+	buildsCollection := s.mongoConfig.GetCollection("builds")
+	if buildsCollection == nil {
+		return nil, fmt.Errorf("MongoDB builds collection is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mongoBuild models.MongoBuildStatus
+	err := buildsCollection.FindOne(ctx, bson.M{"build_id": buildID}).Decode(&mongoBuild)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil // Build not found, return nil
+		}
+		return nil, fmt.Errorf("failed to find build: %v", err)
+	}
+
+	return &mongoBuild, nil
+}
+
 // GetBuildsWithFilters retrieves builds with various filters and pagination
 func (s *MongoLogService) GetBuildsWithFilters(projectID, appName, status string, page, limit int, sortOrder string) ([]models.BuildStatusResponse, int64, error) {
 	buildsCollection := s.mongoConfig.GetCollection("builds")
@@ -485,3 +662,90 @@ func (s *MongoLogService) GetBuildByID(buildID string) (*models.BuildStatusRespo
 	buildResponse := mongoBuild.ToBuildStatusResponse()
 	return &buildResponse, nil
 }
+
+// AverageBuildDuration returns the mean duration of the most recent completed
+// builds matching the given project, app and framework, and how many builds
+// it is based on. Empty arguments match any value.
+func (s *MongoLogService) AverageBuildDuration(projectID, appName, framework string, samples int) (time.Duration, int, error) {
+	buildsCollection := s.mongoConfig.GetCollection("builds")
+	if buildsCollection == nil {
+		return 0, 0, fmt.Errorf("MongoDB builds collection is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"status":       common.BUILD_STATUS_COMPLETED,
+		"started_at":   bson.M{"$exists": true},
+		"completed_at": bson.M{"$exists": true},
+	}
+	if projectID != "" {
+		filter["project_id"] = projectID
+	}
+	if appName != "" {
+		filter["app_name"] = appName
+	}
+	if framework != "" {
+		filter["framework"] = framework
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "completed_at", Value: -1}}).
+		SetLimit(int64(samples)).
+		SetProjection(bson.M{"started_at": 1, "completed_at": 1})
+
+	cursor, err := buildsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query build durations: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var builds []models.MongoBuildStatus
+	if err := cursor.All(ctx, &builds); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode build durations: %v", err)
+	}
+
+	var total time.Duration
+	count := 0
+	for _, build := range builds {
+		if d := build.CompletedAt.Sub(build.StartedAt); d > 0 {
+			total += d
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0, nil
+	}
+
+	return total / time.Duration(count), count, nil
+}
+
+// LastFramework returns the framework detected in the most recent build of an app
+func (s *MongoLogService) LastFramework(projectID, appName string) (string, error) {
+	buildsCollection := s.mongoConfig.GetCollection("builds")
+	if buildsCollection == nil {
+		return "", fmt.Errorf("MongoDB builds collection is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"project_id": projectID,
+		"app_name":   appName,
+		"framework":  bson.M{"$nin": bson.A{nil, ""}},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	var build models.MongoBuildStatus
+	err := buildsCollection.FindOne(ctx, filter, opts).Decode(&build)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to find framework: %v", err)
+	}
+
+	return build.Framework, nil
+}
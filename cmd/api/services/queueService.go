@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"mira/cmd/api/models"
+	common "mira/cmd/common"
+)
+
+const (
+	// durationSamples is how many recent builds an estimate averages
+	durationSamples = 20
+	// defaultBuildDuration is assumed when there are no finished builds to learn from
+	defaultBuildDuration = 5 * time.Minute
+	// queueSnapshotTTL is how long a scan of the build queue answers position
+	// lookups, shorter than the interval of the queue updates on log streams
+	queueSnapshotTTL = 5 * time.Second
+)
+
+// ErrBuildNotQueued is returned for builds neither the queue nor MongoDB know about
+var ErrBuildNotQueued = errors.New("build not found")
+
+// QueueService reports queue positions and start and finish estimates for builds
+type QueueService struct {
+	natsClient      *common.NATSClient
+	mongoService    *MongoLogService
+	builderRegistry *BuilderRegistry
+
+	// snapshotMu is held while the queue is scanned, so concurrent lookups share one scan
+	snapshotMu    sync.Mutex
+	snapshot      *common.BuildQueueSnapshot
+	snapshotTaken time.Time
+}
+
+// NewQueueService creates a new queue service. mongoService may be nil, estimates then use defaults.
+func NewQueueService(natsClient *common.NATSClient, mongoService *MongoLogService, builderRegistry *BuilderRegistry) *QueueService {
+	return &QueueService{
+		natsClient:      natsClient,
+		mongoService:    mongoService,
+		builderRegistry: builderRegistry,
+	}
+}
+
+// QueueInfo returns the queue position of a build together with the builder
+// capacity and rough start and finish estimates
+func (s *QueueService) QueueInfo(buildID string) (*models.BuildQueueResponse, error) {
+	var build *models.MongoBuildStatus
+	if s.mongoService != nil {
+		var err error
+		build, err = s.mongoService.GetBuildRecord(buildID)
+		if err != nil {
+			log.Printf("Failed to get build %s: %v", buildID, err)
+		}
+	}
+
+	snapshot, err := s.queueSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	position := snapshot.Position(buildID)
+	if build == nil && !position.Found {
+		return nil, ErrBuildNotQueued
+	}
+
+	info := &models.BuildQueueResponse{
+		BuildID:     buildID,
+		Status:      common.BUILD_STATUS_QUEUED,
+		Priority:    position.Priority,
+		QueueLength: position.Pending,
+		Position:    position.Position,
+	}
+	if build != nil {
+		info.Status = build.Status
+	}
+	info.Waiting = position.Found && !position.Assigned
+
+	builderQueued := 0
+	for _, load := range s.builderRegistry.Builders() {
+		info.ActiveBuilders++
+		info.RunningBuilds += load.Active
+		info.Capacity += load.MaxConcurrent
+		builderQueued += load.Queued
+	}
+
+	if common.IsTerminalBuildStatus(info.Status) {
+		return info, nil
+	}
+
+	duration, basis := s.estimateDuration(build)
+	typical, _ := s.averageDuration("", "", "")
+	if typical == 0 {
+		typical = defaultBuildDuration
+	}
+	info.EstimateBasis = basis
+
+	now := time.Now()
+	var start time.Time
+	switch {
+	case info.Waiting:
+		if info.Capacity == 0 {
+			// No builder is online, nothing to base a start time on
+			return info, nil
+		}
+		// Every full round of builds ahead of this one delays it by a typical build
+		ahead := info.RunningBuilds + builderQueued + info.Position - 1
+		start = now.Add(time.Duration(ahead/info.Capacity) * typical)
+	case build != nil && !build.StartedAt.IsZero():
+		start = build.StartedAt
+	default:
+		// Picked up by a builder and about to start
+		start = now
+	}
+
+	finish := start.Add(duration)
+	if finish.Before(now) {
+		finish = now
+	}
+	info.EstimatedStart = start.Format("2006-01-02T15:04:05Z07:00")
+	info.EstimatedFinish = finish.Format("2006-01-02T15:04:05Z07:00")
+
+	return info, nil
+}
+
+// queueSnapshot returns the positions in the build queue, scanning it again
+// once the last snapshot is older than queueSnapshotTTL
+func (s *QueueService) queueSnapshot() (*common.BuildQueueSnapshot, error) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	if s.snapshot != nil && time.Since(s.snapshotTaken) < queueSnapshotTTL {
+		return s.snapshot, nil
+	}
+	snapshot, err := s.natsClient.BuildQueueSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = snapshot
+	s.snapshotTaken = time.Now()
+	return snapshot, nil
+}
+
+// estimateDuration estimates how long a build takes from past builds of the
+// same app, then of the same framework, then of all apps
+func (s *QueueService) estimateDuration(build *models.MongoBuildStatus) (time.Duration, string) {
+	if build != nil {
+		if d, _ := s.averageDuration(build.ProjectID, build.AppName, ""); d > 0 {
+			return d, "app"
+		}
+
+		framework := build.Framework
+		if framework == "" && s.mongoService != nil {
+			framework, _ = s.mongoService.LastFramework(build.ProjectID, build.AppName)
+		}
+		if framework != "" {
+			if d, _ := s.averageDuration("", "", framework); d > 0 {
+				return d, "framework"
+			}
+		}
+	}
+
+	if d, _ := s.averageDuration("", "", ""); d > 0 {
+		return d, "all"
+	}
+	return defaultBuildDuration, "default"
+}
+
+// averageDuration returns the average duration of matching completed builds, 0 when there are none
+func (s *QueueService) averageDuration(projectID, appName, framework string) (time.Duration, int) {
+	if s.mongoService == nil {
+		return 0, 0
+	}
+
+	d, n, err := s.mongoService.AverageBuildDuration(projectID, appName, framework, durationSamples)
+	if err != nil {
+		log.Printf("Failed to estimate build duration: %v", err)
+		return 0, 0
+	}
+	return d, n
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"time"
+
+	"mira/cmd/api/models"
+	common "mira/cmd/common"
+	"mira/cmd/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRegistryEncryptionDisabled is returned when registry credentials are
+// saved without MIRA_SPEC_ENCRYPTION_KEY, which they are encrypted with
+var ErrRegistryEncryptionDisabled = errors.New("MIRA_SPEC_ENCRYPTION_KEY must be set to store registry credentials")
+
+// RegistryService stores the container registry of each project. Projects
+// without a registry push to the default Docker Hub account of the builders.
+type RegistryService struct {
+	collection *mongo.Collection
+	aead       cipher.AEAD
+}
+
+// NewRegistryService creates a new registry service
+func NewRegistryService(mongoConfig *config.MongoDBConfig) *RegistryService {
+	return &RegistryService{
+		collection: mongoConfig.GetCollection("registries"),
+		aead:       common.NewSpecCipher(),
+	}
+}
+
+// Get returns the stored registry of a project, nil when none is configured
+func (s *RegistryService) Get(projectID string) (*models.MongoRegistry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stored models.MongoRegistry
+	err := s.collection.FindOne(ctx, bson.M{"_id": projectID}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find registry: %v", err)
+	}
+	return &stored, nil
+}
+
+// Save sets the registry of a project. An empty password keeps the stored
+// one when the username is unchanged, so clients can update other settings
+// without re-sending credentials.
+func (s *RegistryService) Save(projectID string, registry *common.ImageRegistry) (*models.MongoRegistry, error) {
+	stored := &models.MongoRegistry{
+		ProjectID: projectID,
+		Type:      registry.Type,
+		Host:      registry.Host,
+		Namespace: registry.Namespace,
+		Username:  registry.Username,
+		Insecure:  registry.Insecure,
+		Private:   registry.Private,
+		UpdatedAt: time.Now(),
+	}
+
+	if registry.Password != "" {
+		if s.aead == nil {
+			return nil, ErrRegistryEncryptionDisabled
+		}
+		encrypted, err := common.SealSecret(s.aead, []byte(registry.Password))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt registry password: %v", err)
+		}
+		stored.EncryptedPassword = encrypted
+	} else if registry.Username != "" {
+		existing, err := s.Get(projectID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && existing.Username == registry.Username {
+			stored.EncryptedPassword = existing.EncryptedPassword
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": projectID}, stored, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store registry: %v", err)
+	}
+	return stored, nil
+}
+
+// Delete removes the registry of a project, reporting whether one was configured
+func (s *RegistryService) Delete(projectID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": projectID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete registry: %v", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// Resolve returns the registry of a project, ready to be sent to the image
+// builders. The password stays sealed, the builders decrypt it with the same
+// MIRA_SPEC_ENCRYPTION_KEY. It is nil when the project has none.
+func (s *RegistryService) Resolve(projectID string) (*common.ImageRegistry, error) {
+	stored, err := s.Get(projectID)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+
+	return &common.ImageRegistry{
+		Type:              stored.Type,
+		Host:              stored.Host,
+		Namespace:         stored.Namespace,
+		Username:          stored.Username,
+		EncryptedPassword: stored.EncryptedPassword,
+		Insecure:          stored.Insecure,
+		Private:           stored.Private,
+	}, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"mira/cmd/api/models"
+	common "mira/cmd/common"
+	"mira/cmd/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SBOMService serves the SBOM documents the image builders store for each
+// build, and indexes their packages so builds can be found by package
+type SBOMService struct {
+	natsClient  *common.NATSClient
+	mongoConfig *config.MongoDBConfig
+	packages    *mongo.Collection
+}
+
+// NewSBOMService creates a new SBOM service
+func NewSBOMService(mongoConfig *config.MongoDBConfig, natsClient *common.NATSClient) *SBOMService {
+	packages := mongoConfig.GetCollection("build_packages")
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		indexes := map[string]mongo.IndexModel{
+			"build_packages_name_version_idx": {
+				Keys:    bson.D{{Key: "name", Value: 1}, {Key: "version", Value: 1}, {Key: "created_at", Value: -1}},
+				Options: options.Index().SetName("build_packages_name_version_idx"),
+			},
+			"build_packages_build_id_idx": {
+				Keys:    bson.D{{Key: "build_id", Value: 1}},
+				Options: options.Index().SetName("build_packages_build_id_idx"),
+			},
+		}
+		for name, index := range indexes {
+			if _, err := packages.Indexes().CreateOne(ctx, index); err != nil {
+				log.Printf("Failed to create index %s: %v", name, err)
+			}
+		}
+	}()
+
+	return &SBOMService{
+		natsClient:  natsClient,
+		mongoConfig: mongoConfig,
+		packages:    packages,
+	}
+}
+
+// Index records the packages of a stored build SBOM, replacing those indexed
+// for the build before, and summarises the SBOM on the build record
+func (s *SBOMService) Index(sbom *common.BuildSBOM) (int, error) {
+	documents, err := s.natsClient.GetBuildSBOM(sbom.BuildID)
+	if err != nil {
+		return 0, err
+	}
+	if documents == nil {
+		return 0, fmt.Errorf("no SBOM stored for build %s", sbom.BuildID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := s.packages.DeleteMany(ctx, bson.M{"build_id": sbom.BuildID}); err != nil {
+		return 0, fmt.Errorf("failed to delete indexed packages: %v", err)
+	}
+
+	now := time.Now()
+	packages := common.SBOMPackages(documents)
+	if len(packages) > 0 {
+		rows := make([]interface{}, 0, len(packages))
+		for _, pkg := range packages {
+			rows = append(rows, models.MongoBuildPackage{
+				BuildID:   sbom.BuildID,
+				ProjectID: sbom.ProjectID,
+				AppName:   sbom.AppName,
+				Name:      pkg.Name,
+				Version:   pkg.Version,
+				Type:      pkg.Type,
+				PURL:      pkg.PURL,
+				CreatedAt: now,
+			})
+		}
+		if _, err := s.packages.InsertMany(ctx, rows); err != nil {
+			return 0, fmt.Errorf("failed to index packages: %v", err)
+		}
+	}
+
+	summary := models.MongoBuildSBOM{
+		Formats:      sbom.Formats,
+		Documents:    len(documents),
+		PackageCount: len(packages),
+		StoredAt:     now,
+	}
+	update := bson.M{
+		"$set":         bson.M{"sbom": summary, "updated_at": now},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.Update().SetUpsert(true)
+	buildsCollection := s.mongoConfig.GetCollection("builds")
+	if _, err := buildsCollection.UpdateOne(ctx, bson.M{"build_id": sbom.BuildID}, update, opts); err != nil {
+		return 0, fmt.Errorf("failed to save build SBOM: %v", err)
+	}
+
+	return len(packages), nil
+}
+
+// Documents returns the SBOM documents of a build, only those in format
+// unless it is empty. It returns nil when the build has no SBOM.
+func (s *SBOMService) Documents(buildID, format string) ([]common.SBOMDocument, error) {
+	documents, err := s.natsClient.GetBuildSBOM(buildID)
+	if err != nil || documents == nil || format == "" {
+		return documents, err
+	}
+
+	filtered := []common.SBOMDocument{}
+	for _, document := range documents {
+		if document.Format == format {
+			filtered = append(filtered, document)
+		}
+	}
+	return filtered, nil
+}
+
+// FindPackages returns the builds whose images contain a package, newest
+// first. version and projectID narrow the search when set.
+func (s *SBOMService) FindPackages(name, version, projectID string, page, limit int) ([]models.MongoBuildPackage, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"name": name}
+	if version != "" {
+		filter["version"] = version
+	}
+	if projectID != "" {
+		filter["project_id"] = projectID
+	}
+
+	total, err := s.packages.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count packages: %v", err)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := s.packages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to find packages: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var packages []models.MongoBuildPackage
+	if err := cursor.All(ctx, &packages); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode packages: %v", err)
+	}
+	return packages, total, nil
+}
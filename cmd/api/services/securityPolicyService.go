@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mira/cmd/api/models"
+	common "mira/cmd/common"
+	"mira/cmd/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SecurityPolicyService stores the vulnerability scan policy of each project.
+// The scans of projects without a policy only report what they find.
+type SecurityPolicyService struct {
+	collection *mongo.Collection
+}
+
+// NewSecurityPolicyService creates a new security policy service
+func NewSecurityPolicyService(mongoConfig *config.MongoDBConfig) *SecurityPolicyService {
+	return &SecurityPolicyService{
+		collection: mongoConfig.GetCollection("security_policies"),
+	}
+}
+
+// Get returns the stored policy of a project, nil when none is configured
+func (s *SecurityPolicyService) Get(projectID string) (*models.MongoSecurityPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stored models.MongoSecurityPolicy
+	err := s.collection.FindOne(ctx, bson.M{"_id": projectID}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find security policy: %v", err)
+	}
+	return &stored, nil
+}
+
+// Save sets the policy of a project
+func (s *SecurityPolicyService) Save(projectID string, policy *common.SecurityPolicy) (*models.MongoSecurityPolicy, error) {
+	stored := &models.MongoSecurityPolicy{
+		ProjectID: projectID,
+		FailOn:    policy.FailOn,
+		Ignore:    policy.Ignore,
+		UpdatedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": projectID}, stored, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store security policy: %v", err)
+	}
+	return stored, nil
+}
+
+// Delete removes the policy of a project, reporting whether one was configured
+func (s *SecurityPolicyService) Delete(projectID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": projectID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete security policy: %v", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// Resolve returns the policy of a project as sent to the image builders, nil when the project has none
+func (s *SecurityPolicyService) Resolve(projectID string) (*common.SecurityPolicy, error) {
+	stored, err := s.Get(projectID)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	return &common.SecurityPolicy{FailOn: stored.FailOn, Ignore: stored.Ignore}, nil
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
@@ -23,6 +24,35 @@ type AppExistsResponse struct {
 	Message string      `json:"message"`
 }
 
+// ErrProjectAccessDenied is returned when an access token does not grant access to a project
+var ErrProjectAccessDenied = errors.New("access token does not grant access to the project")
+
+// ValidateProjectAccess checks with the CraneCloud API that the access token
+// can read the project, as only its members may change its settings
+func (v *ValidationService) ValidateProjectAccess(ctx context.Context, projectID, accessToken string) error {
+	ccApiHost := os.Getenv("CRANECLOUD_API_HOST")
+	if ccApiHost == "" {
+		return fmt.Errorf("CRANECLOUD_API_HOST environment variable not set")
+	}
+
+	resp, err := resty.New().R().
+		SetContext(ctx).
+		SetAuthToken(accessToken).
+		Get(ccApiHost + "/projects/" + projectID)
+	if err != nil {
+		return fmt.Errorf("failed to validate project access: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case 401, 403, 404:
+		return ErrProjectAccessDenied
+	}
+	if resp.IsError() {
+		return fmt.Errorf("project access validation request failed with status %s", resp.Status())
+	}
+	return nil
+}
+
 // ValidateAppName checks if an app with the given name already exists in the project
 func (v *ValidationService) ValidateAppName(appName, projectID, accessToken string) error {
 	// Get Crane Cloud API host from environment
@@ -0,0 +1,35 @@
+package common
+
+import "time"
+
+// BuildArtifact describes the image a build produced and how long each step of the build took
+type BuildArtifact struct {
+	Digest string `json:"digest,omitempty"`
+	// SizeBytes is the compressed size of the image in the registry, or its
+	// uncompressed size in the docker daemon for images that were not pushed
+	SizeBytes    int64          `json:"size_bytes,omitempty"`
+	Layers       int            `json:"layers,omitempty"`
+	Backend      string         `json:"backend,omitempty"`       // one of the BUILD_BACKEND_* values
+	BuilderImage string         `json:"builder_image,omitempty"` // buildpacks builder the image was built with
+	Buildpacks   []BuildpackRef `json:"buildpacks,omitempty"`    // buildpacks that took part in the build
+	Framework    string         `json:"framework,omitempty"`
+	// StepDurations lists the pipeline steps in the order they ran
+	StepDurations []StepDuration `json:"step_durations,omitempty"`
+}
+
+// BuildpackRef identifies a buildpack and the version of it used by a build
+type BuildpackRef struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+}
+
+// StepDuration is how long a pipeline step of a build ran
+type StepDuration struct {
+	Step       string `json:"step"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// RecordStep appends the duration of a pipeline step
+func (a *BuildArtifact) RecordStep(step string, duration time.Duration) {
+	a.StepDurations = append(a.StepDurations, StepDuration{Step: step, DurationMS: duration.Milliseconds()})
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Build cache actions
+const (
+	BUILD_CACHE_INSPECT = "inspect"
+	BUILD_CACHE_CLEAR   = "clear"
+)
+
+// BuildCacheRequest asks every image builder about the build cache of an app
+type BuildCacheRequest struct {
+	ProjectID string `json:"project_id"`
+	AppName   string `json:"app_name"`
+	Action    string `json:"action"` // one of the BUILD_CACHE_* values
+	// Registry holds the cache images of the app, the default Docker Hub account when nil
+	Registry *ImageRegistry `json:"registry,omitempty"`
+}
+
+// BuildCache describes a build cache of an app on an image builder
+type BuildCache struct {
+	Name      string    `json:"name"`
+	Format    string    `json:"format"` // "volume" or "image"
+	Exists    bool      `json:"exists"`
+	SizeBytes int64     `json:"size_bytes,omitempty"` // -1 when the size is unknown
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	Cleared   bool      `json:"cleared,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// BuildCacheReply is the answer of one image builder to a BuildCacheRequest
+type BuildCacheReply struct {
+	BuilderID string       `json:"builder_id"`
+	Caches    []BuildCache `json:"caches"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// RequestBuildCache sends a build cache request to all image builders and
+// collects the replies that arrive within wait
+func (c *NATSClient) RequestBuildCache(request *BuildCacheRequest, wait time.Duration) ([]*BuildCacheReply, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("NATS connection is not healthy")
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal build cache request: %v", err)
+	}
+
+	inbox := nats.NewInbox()
+	sub, err := c.conn.SubscribeSync(inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to build cache replies: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := c.conn.PublishRequest(SUBJECT_BUILD_CACHE, inbox, data); err != nil {
+		return nil, fmt.Errorf("failed to publish build cache request: %v", err)
+	}
+
+	// Every builder answers, so wait for the full window instead of the first reply
+	var replies []*BuildCacheReply
+	deadline := time.Now().Add(wait)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		msg, err := sub.NextMsg(remaining)
+		if err != nil {
+			break
+		}
+
+		var reply BuildCacheReply
+		if err := json.Unmarshal(msg.Data, &reply); err != nil {
+			log.Printf("Failed to unmarshal build cache reply: %v", err)
+			continue
+		}
+		replies = append(replies, &reply)
+	}
+
+	return replies, nil
+}
+
+// SubscribeToBuildCacheRequests answers build cache requests with the reply returned by handler
+func (c *NATSClient) SubscribeToBuildCacheRequests(handler func(*BuildCacheRequest) *BuildCacheReply) (*nats.Subscription, error) {
+	return c.conn.Subscribe(SUBJECT_BUILD_CACHE, func(msg *nats.Msg) {
+		var request BuildCacheRequest
+		if err := json.Unmarshal(msg.Data, &request); err != nil {
+			fmt.Printf("Failed to unmarshal build cache request: %v\n", err)
+			return
+		}
+
+		data, err := json.Marshal(handler(&request))
+		if err != nil {
+			log.Printf("Failed to marshal build cache reply: %v", err)
+			return
+		}
+		if err := msg.Respond(data); err != nil {
+			log.Printf("Failed to reply to build cache request: %v", err)
+		}
+	})
+}
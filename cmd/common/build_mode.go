@@ -0,0 +1,40 @@
+package common
+
+// Build modes. They decide how far the build pipeline goes, so that changes
+// can be built and checked without touching Crane Cloud.
+const (
+	BUILD_MODE_BUILD_ONLY     = "build_only"     // build the image into the docker daemon of the builder
+	BUILD_MODE_BUILD_AND_PUSH = "build_and_push" // build the image and push it to the registry
+	BUILD_MODE_FULL           = "full"           // validate the app name, build, push and deploy to Crane Cloud
+)
+
+// BuildModes lists the build modes from the shortest pipeline to the longest
+var BuildModes = []string{BUILD_MODE_BUILD_ONLY, BUILD_MODE_BUILD_AND_PUSH, BUILD_MODE_FULL}
+
+// IsValidBuildMode reports whether mode is one of the BUILD_MODE_* values
+func IsValidBuildMode(mode string) bool {
+	for _, m := range BuildModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeBuildMode returns mode, or the full mode when it is empty or unknown
+func NormalizeBuildMode(mode string) string {
+	if IsValidBuildMode(mode) {
+		return mode
+	}
+	return BUILD_MODE_FULL
+}
+
+// BuildModePublishes reports whether builds of a mode push their image to the registry
+func BuildModePublishes(mode string) bool {
+	return NormalizeBuildMode(mode) != BUILD_MODE_BUILD_ONLY
+}
+
+// BuildModeDeploys reports whether builds of a mode are validated against and deployed to Crane Cloud
+func BuildModeDeploys(mode string) bool {
+	return NormalizeBuildMode(mode) == BUILD_MODE_FULL
+}
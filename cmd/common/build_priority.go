@@ -0,0 +1,65 @@
+package common
+
+// Build priorities. Each priority has its own lane in the build work queue
+// and builders drain higher lanes first.
+const (
+	BUILD_PRIORITY_HIGH   = "high"
+	BUILD_PRIORITY_NORMAL = "normal"
+	BUILD_PRIORITY_LOW    = "low"
+)
+
+// BuildPriorities lists the build priorities from highest to lowest
+var BuildPriorities = []string{BUILD_PRIORITY_HIGH, BUILD_PRIORITY_NORMAL, BUILD_PRIORITY_LOW}
+
+// IsValidBuildPriority reports whether priority is one of the BUILD_PRIORITY_* values
+func IsValidBuildPriority(priority string) bool {
+	for _, p := range BuildPriorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeBuildPriority returns priority, or the normal priority when it is empty or unknown
+func NormalizeBuildPriority(priority string) string {
+	if IsValidBuildPriority(priority) {
+		return priority
+	}
+	return BUILD_PRIORITY_NORMAL
+}
+
+// BuildPriorityRank returns the position of priority in BuildPriorities, 0 being the highest
+func BuildPriorityRank(priority string) int {
+	for i, p := range BuildPriorities {
+		if p == priority {
+			return i
+		}
+	}
+	return BuildPriorityRank(BUILD_PRIORITY_NORMAL)
+}
+
+// BuildRequestSubject returns the work queue subject for build requests of a priority.
+// Normal builds keep the original subject so requests queued before lanes existed are still served.
+func BuildRequestSubject(priority string) string {
+	switch priority {
+	case BUILD_PRIORITY_HIGH:
+		return SUBJECT_BUILD_REQUEST_HIGH
+	case BUILD_PRIORITY_LOW:
+		return SUBJECT_BUILD_REQUEST_LOW
+	default:
+		return SUBJECT_BUILD_REQUEST
+	}
+}
+
+// BuildRequestConsumer returns the durable consumer that serves the lane of a priority
+func BuildRequestConsumer(priority string) string {
+	switch priority {
+	case BUILD_PRIORITY_HIGH:
+		return CONSUMER_BUILD_REQUESTS_HIGH
+	case BUILD_PRIORITY_LOW:
+		return CONSUMER_BUILD_REQUESTS_LOW
+	default:
+		return CONSUMER_BUILD_REQUESTS
+	}
+}
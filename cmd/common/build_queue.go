@@ -0,0 +1,483 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Defaults for the build request work queue
+const (
+	defaultBuildAckWait    = 60 * time.Second
+	defaultBuildMaxDeliver = 3
+)
+
+// BuildQueueAckWait returns how long JetStream waits for a builder to ack or
+// report progress on a build request before redelivering it
+func BuildQueueAckWait() time.Duration {
+	if v := os.Getenv("MIRA_BUILD_ACK_WAIT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return defaultBuildAckWait
+}
+
+// BuildQueueMaxDeliver returns how many times a build request may be delivered
+// to builders before JetStream gives up on it
+func BuildQueueMaxDeliver() int {
+	if v := os.Getenv("MIRA_BUILD_MAX_DELIVER"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultBuildMaxDeliver
+}
+
+// buildQueueSubjects returns the subjects of all priority lanes
+func buildQueueSubjects() []string {
+	subjects := make([]string, 0, len(BuildPriorities))
+	for _, priority := range BuildPriorities {
+		subjects = append(subjects, BuildRequestSubject(priority))
+	}
+	return subjects
+}
+
+// EnsureBuildQueue makes sure the build request stream and the shared durable
+// consumer of every priority lane exist, and returns the JetStream context used to reach them
+func (c *NATSClient) EnsureBuildQueue() (nats.JetStreamContext, error) {
+	js, err := c.GetJetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %v", err)
+	}
+
+	c.buildQueueMu.Lock()
+	defer c.buildQueueMu.Unlock()
+	if c.buildQueueReady {
+		return js, nil
+	}
+
+	streamConfig := &nats.StreamConfig{
+		Name:      STREAM_BUILD_REQUESTS,
+		Subjects:  buildQueueSubjects(),
+		Storage:   nats.FileStorage,
+		Retention: nats.WorkQueuePolicy,
+		// Deduplicate retried publishes of the same build ID
+		Duplicates: 2 * time.Minute,
+	}
+	stream, err := js.StreamInfo(STREAM_BUILD_REQUESTS)
+	if err != nil {
+		if !errors.Is(err, nats.ErrStreamNotFound) {
+			return nil, fmt.Errorf("failed to look up build stream: %v", err)
+		}
+		if _, err := js.AddStream(streamConfig); err != nil {
+			return nil, fmt.Errorf("failed to create build stream: %v", err)
+		}
+		log.Printf("Created JetStream build stream %s", STREAM_BUILD_REQUESTS)
+	} else if len(stream.Config.Subjects) != len(streamConfig.Subjects) {
+		// Streams created before priority lanes only carry the normal subject
+		if _, err := js.UpdateStream(streamConfig); err != nil {
+			return nil, fmt.Errorf("failed to add priority lanes to build stream: %v", err)
+		}
+		log.Printf("Added priority lanes to JetStream build stream %s", STREAM_BUILD_REQUESTS)
+	}
+
+	for _, priority := range BuildPriorities {
+		if err := ensureBuildConsumer(js, priority); err != nil {
+			return nil, err
+		}
+	}
+
+	c.buildQueueReady = true
+	return js, nil
+}
+
+// ensureBuildConsumer creates or updates the durable consumer of a priority lane
+func ensureBuildConsumer(js nats.JetStreamContext, priority string) error {
+	name := BuildRequestConsumer(priority)
+	consumerConfig := &nats.ConsumerConfig{
+		Durable:       name,
+		FilterSubject: BuildRequestSubject(priority),
+		AckPolicy:     nats.AckExplicitPolicy,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckWait:       BuildQueueAckWait(),
+		MaxDeliver:    BuildQueueMaxDeliver(),
+	}
+	if _, err := js.ConsumerInfo(STREAM_BUILD_REQUESTS, name); err != nil {
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
+			return fmt.Errorf("failed to look up build consumer %s: %v", name, err)
+		}
+		if _, err := js.AddConsumer(STREAM_BUILD_REQUESTS, consumerConfig); err != nil {
+			return fmt.Errorf("failed to create build consumer %s: %v", name, err)
+		}
+		log.Printf("Created durable build consumer %s", name)
+	} else if _, err := js.UpdateConsumer(STREAM_BUILD_REQUESTS, consumerConfig); err != nil {
+		// Keep running with the existing settings rather than refusing to start
+		log.Printf("Warning: Failed to update build consumer %s settings: %v", name, err)
+	}
+	return nil
+}
+
+// BuildDelivery is a build request pulled from the work queue. The request
+// stays owned by the builder until it is acked, nak'ed or terminated; until
+// then the delivery keeps telling JetStream that work is still in progress.
+type BuildDelivery struct {
+	Request *BuildRequest
+
+	msg  *nats.Msg
+	done chan struct{}
+	once sync.Once
+}
+
+// NumDelivered returns how many times this request has been delivered, including this one
+func (d *BuildDelivery) NumDelivered() uint64 {
+	meta, err := d.msg.Metadata()
+	if err != nil {
+		return 1
+	}
+	return meta.NumDelivered
+}
+
+// Redelivered reports whether a previous builder already received this request
+func (d *BuildDelivery) Redelivered() bool {
+	return d.NumDelivered() > 1
+}
+
+// Ack marks the build request as done. Call it once the build reached a terminal state.
+func (d *BuildDelivery) Ack() error {
+	d.finish()
+	return d.msg.Ack()
+}
+
+// Nak hands the build request back to the queue for redelivery after the given delay
+func (d *BuildDelivery) Nak(delay time.Duration) error {
+	d.finish()
+	return d.msg.NakWithDelay(delay)
+}
+
+// Term removes the build request from the queue without redelivering it
+func (d *BuildDelivery) Term() error {
+	d.finish()
+	return d.msg.Term()
+}
+
+func (d *BuildDelivery) finish() {
+	d.once.Do(func() { close(d.done) })
+}
+
+// keepAlive reports progress to JetStream until the delivery is finished so
+// that long running builds are not redelivered to another builder
+func (d *BuildDelivery) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			if err := d.msg.InProgress(); err != nil {
+				log.Printf("Failed to extend ack deadline for build %s: %v", d.Request.ID, err)
+			}
+		}
+	}
+}
+
+// BuildRequestSubscription holds a pull subscription on the shared consumer
+// of every priority lane
+type BuildRequestSubscription struct {
+	lanes   map[string]*nats.Subscription
+	notify  []*nats.Subscription
+	work    chan struct{}
+	ackWait time.Duration
+}
+
+// Fetch pulls up to batch build requests from the lane of a priority, waiting
+// until at least one is available or ctx is done. It returns no deliveries and
+// no error when the lane stayed empty.
+func (s *BuildRequestSubscription) Fetch(ctx context.Context, priority string, batch int) ([]*BuildDelivery, error) {
+	sub, ok := s.lanes[priority]
+	if !ok {
+		return nil, fmt.Errorf("unknown build priority %q", priority)
+	}
+
+	msgs, err := sub.Fetch(batch, nats.Context(ctx))
+	if err != nil {
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	deliveries := make([]*BuildDelivery, 0, len(msgs))
+	for _, msg := range msgs {
+		var request BuildRequest
+		if err := json.Unmarshal(msg.Data, &request); err != nil {
+			// A malformed request will never succeed, drop it instead of redelivering
+			fmt.Printf("Failed to unmarshal build request: %v\n", err)
+			msg.Term()
+			continue
+		}
+
+		delivery := &BuildDelivery{
+			Request: &request,
+			msg:     msg,
+			done:    make(chan struct{}),
+		}
+		go delivery.keepAlive(s.ackWait / 2)
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// WaitForWork blocks until a new build request is published on any lane,
+// timeout elapses or ctx is done. Redelivered requests are not announced, so
+// callers should fetch again after a timeout.
+func (s *BuildRequestSubscription) WaitForWork(ctx context.Context, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-s.work:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Unsubscribe stops pulling from the build consumers. The durable consumers themselves are kept.
+func (s *BuildRequestSubscription) Unsubscribe() error {
+	var firstErr error
+	for _, sub := range append(s.notify, s.laneSubscriptions()...) {
+		if err := sub.Unsubscribe(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *BuildRequestSubscription) laneSubscriptions() []*nats.Subscription {
+	subs := make([]*nats.Subscription, 0, len(s.lanes))
+	for _, sub := range s.lanes {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// SubscribeToBuildRequests binds a pull subscription to the durable consumer
+// of every priority lane shared by all image builders
+func (c *NATSClient) SubscribeToBuildRequests() (*BuildRequestSubscription, error) {
+	js, err := c.EnsureBuildQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BuildRequestSubscription{
+		lanes:   make(map[string]*nats.Subscription),
+		work:    make(chan struct{}, 1),
+		ackWait: BuildQueueAckWait(),
+	}
+
+	for _, priority := range BuildPriorities {
+		consumer := BuildRequestConsumer(priority)
+		sub, err := js.PullSubscribe(BuildRequestSubject(priority), consumer,
+			nats.Bind(STREAM_BUILD_REQUESTS, consumer))
+		if err != nil {
+			s.Unsubscribe()
+			return nil, fmt.Errorf("failed to subscribe to %s build requests: %v", priority, err)
+		}
+		s.lanes[priority] = sub
+
+		// Published requests also reach plain subscribers, which lets idle
+		// builders wake up without polling every lane
+		notify, err := c.conn.Subscribe(BuildRequestSubject(priority), func(*nats.Msg) {
+			select {
+			case s.work <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			s.Unsubscribe()
+			return nil, fmt.Errorf("failed to watch %s build requests: %v", priority, err)
+		}
+		s.notify = append(s.notify, notify)
+	}
+
+	return s, nil
+}
+
+// maxDeliveriesAdvisory is the part of the JetStream max deliveries advisory we use
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// SubscribeToExhaustedBuildRequests calls handler for every build request that
+// JetStream stopped redelivering because it hit the max deliver limit. The
+// request is removed from the queue after the handler returns. Builders share
+// a queue group so that each exhausted request is handled once.
+func (c *NATSClient) SubscribeToExhaustedBuildRequests(handler func(*BuildRequest)) (*nats.Subscription, error) {
+	js, err := c.EnsureBuildQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.conn.QueueSubscribe(SUBJECT_BUILD_MAX_DELIVERIES, CONSUMER_BUILD_REQUESTS, func(msg *nats.Msg) {
+		var advisory maxDeliveriesAdvisory
+		if err := json.Unmarshal(msg.Data, &advisory); err != nil {
+			fmt.Printf("Failed to unmarshal max deliveries advisory: %v\n", err)
+			return
+		}
+
+		stored, err := js.GetMsg(STREAM_BUILD_REQUESTS, advisory.StreamSeq)
+		if err != nil {
+			log.Printf("Failed to load exhausted build request %d: %v", advisory.StreamSeq, err)
+			return
+		}
+
+		var request BuildRequest
+		if err := json.Unmarshal(stored.Data, &request); err != nil {
+			fmt.Printf("Failed to unmarshal exhausted build request: %v\n", err)
+		} else {
+			handler(&request)
+		}
+
+		if err := js.DeleteMsg(STREAM_BUILD_REQUESTS, advisory.StreamSeq); err != nil {
+			log.Printf("Failed to remove exhausted build request %d: %v", advisory.StreamSeq, err)
+		}
+	})
+}
+
+// maxQueueScan bounds how many lookups a queue snapshot makes, across all lanes
+const maxQueueScan = 1000
+
+// queueScanTimeout bounds how long a queue snapshot takes
+const queueScanTimeout = 10 * time.Second
+
+// BuildQueuePosition describes where a build request sits in the work queue
+type BuildQueuePosition struct {
+	// Found reports whether the request is still in the work queue
+	Found bool
+	// Assigned reports whether a builder already pulled the request
+	Assigned bool
+	// Priority is the lane the request is queued in
+	Priority string
+	// Position is the 1-based position among requests no builder pulled yet, 0 once assigned
+	Position int
+	// Pending is the number of requests no builder pulled yet, across all lanes
+	Pending int
+}
+
+// BuildQueueSnapshot holds the position of every request in the work queue
+// at one point in time, so a single scan of the queue serves every lookup
+type BuildQueueSnapshot struct {
+	// Pending is the number of requests no builder pulled yet, across all lanes
+	Pending   int
+	positions map[string]BuildQueuePosition
+}
+
+// Position returns where a build request sat in the work queue when the snapshot was taken
+func (s *BuildQueueSnapshot) Position(buildID string) *BuildQueuePosition {
+	position := s.positions[buildID]
+	position.Pending = s.Pending
+	return &position
+}
+
+// BuildQueueSnapshot reads the position of every request in the work queue.
+// Builders drain higher lanes first and each lane in stream order, so the
+// position of a request is the number of unpulled requests in higher lanes
+// plus those in the same lane with a lower stream sequence, plus one.
+// Requests handed back by a builder count as assigned until they are
+// redelivered.
+func (c *NATSClient) BuildQueueSnapshot() (*BuildQueueSnapshot, error) {
+	js, err := c.EnsureBuildQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queueScanTimeout)
+	defer cancel()
+
+	// The older API only reads by subject from streams allowing direct gets
+	queue, err := jetstream.New(c.conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %v", err)
+	}
+	stream, err := queue.Stream(ctx, STREAM_BUILD_REQUESTS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up build stream: %v", err)
+	}
+
+	snapshot := &BuildQueueSnapshot{positions: make(map[string]BuildQueuePosition)}
+	consumers := make(map[string]*nats.ConsumerInfo, len(BuildPriorities))
+	for _, priority := range BuildPriorities {
+		consumer, err := js.ConsumerInfo(STREAM_BUILD_REQUESTS, BuildRequestConsumer(priority))
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up build consumer: %v", err)
+		}
+		consumers[priority] = consumer
+		snapshot.Pending += int(consumer.NumPending)
+	}
+	state := stream.CachedInfo().State
+	if state.Msgs == 0 {
+		return snapshot, nil
+	}
+
+	// Unpulled requests of each lane, in stream order
+	waiting := make(map[string][]string, len(BuildPriorities))
+
+	// Each lookup returns the next request of a lane at or after a sequence,
+	// skipping the gaps acked requests leave, so every lookup counts towards
+	// the limit and no lookup is spent on a removed request
+	lookups := 0
+	for _, priority := range BuildPriorities {
+		subject := BuildRequestSubject(priority)
+		delivered := consumers[priority].Delivered.Stream
+
+		// Every request of the lane up to its ack floor was acked and removed
+		seq := max(state.FirstSeq, consumers[priority].AckFloor.Stream+1)
+		for seq <= state.LastSeq && lookups < maxQueueScan {
+			lookups++
+			msg, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(subject))
+			if errors.Is(err, jetstream.ErrMsgNotFound) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read build queue: %v", err)
+			}
+			seq = msg.Sequence + 1
+
+			var request BuildRequest
+			if err := json.Unmarshal(msg.Data, &request); err != nil {
+				continue
+			}
+			if msg.Sequence <= delivered {
+				snapshot.positions[request.ID] = BuildQueuePosition{Found: true, Assigned: true, Priority: priority}
+				continue
+			}
+			waiting[priority] = append(waiting[priority], request.ID)
+		}
+	}
+
+	// Requests of higher lanes are served first even when they were queued later
+	ahead := 0
+	for _, priority := range BuildPriorities {
+		for i, buildID := range waiting[priority] {
+			snapshot.positions[buildID] = BuildQueuePosition{Found: true, Priority: priority, Position: ahead + i + 1}
+		}
+		ahead += len(waiting[priority])
+	}
+
+	return snapshot, nil
+}
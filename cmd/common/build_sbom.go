@@ -0,0 +1,273 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// SBOM formats written by the buildpacks
+const (
+	SBOM_FORMAT_CYCLONEDX = "cyclonedx"
+	SBOM_FORMAT_SPDX      = "spdx"
+	SBOM_FORMAT_SYFT      = "syft"
+)
+
+// SBOMFormats lists the SBOM formats, CycloneDX being the one packages are indexed from first
+var SBOMFormats = []string{SBOM_FORMAT_CYCLONEDX, SBOM_FORMAT_SPDX, SBOM_FORMAT_SYFT}
+
+// SBOM_BUCKET is the JetStream object store holding the SBOM documents of every build
+const SBOM_BUCKET = "MIRA_SBOMS"
+
+// SBOMDocument is an SBOM a buildpack wrote for the image, or for one of its layers
+type SBOMDocument struct {
+	Buildpack string          `json:"buildpack"`
+	Layer     string          `json:"layer,omitempty"` // empty for SBOMs covering the whole buildpack
+	Format    string          `json:"format"`          // one of the SBOM_FORMAT_* values
+	Content   json.RawMessage `json:"content"`
+}
+
+// SBOMPackage is a package listed in the SBOM of an image
+type SBOMPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Type    string `json:"type,omitempty"` // e.g. library or npm
+	PURL    string `json:"purl,omitempty"`
+}
+
+// BuildSBOM announces that the SBOM documents of a build were stored
+type BuildSBOM struct {
+	BuildID   string    `json:"build_id"`
+	ProjectID string    `json:"project_id,omitempty"`
+	AppName   string    `json:"app_name,omitempty"`
+	Formats   []string  `json:"formats"`
+	Documents int       `json:"documents"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SetSBOMRetention sets how long and how much of the SBOMs the SBOM store
+// keeps. It applies to the store on its next use.
+func (c *NATSClient) SetSBOMRetention(retention BlobRetention) {
+	c.sbomStoreMu.Lock()
+	defer c.sbomStoreMu.Unlock()
+	c.sbomRetention = retention
+	c.sbomStoreReady = false
+}
+
+// sbomStore returns the SBOM object store, creating it on first use and
+// applying the retention to stores created without it
+func (c *NATSClient) sbomStore() (nats.ObjectStore, error) {
+	js, err := c.GetJetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	c.sbomStoreMu.Lock()
+	defer c.sbomStoreMu.Unlock()
+
+	store, err := js.ObjectStore(SBOM_BUCKET)
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      SBOM_BUCKET,
+			Description: "SBOM documents of built images, by build ID",
+			Storage:     nats.FileStorage,
+			TTL:         c.sbomRetention.TTL,
+			MaxBytes:    c.sbomRetention.MaxBytes,
+		})
+		c.sbomStoreReady = err == nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SBOM store: %v", err)
+	}
+
+	if !c.sbomStoreReady {
+		if err := applyObjectStoreRetention(js, SBOM_BUCKET, c.sbomRetention); err != nil {
+			return nil, err
+		}
+		c.sbomStoreReady = true
+	}
+	return store, nil
+}
+
+// PutBuildSBOM stores the SBOM documents of a build
+func (c *NATSClient) PutBuildSBOM(buildID string, documents []SBOMDocument) error {
+	store, err := c.sbomStore()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(documents)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SBOM documents: %v", err)
+	}
+	if _, err := store.PutBytes(buildID, data); err != nil {
+		return fmt.Errorf("failed to store SBOM documents: %v", err)
+	}
+	return nil
+}
+
+// GetBuildSBOM returns the SBOM documents of a build, nil when none were stored
+func (c *NATSClient) GetBuildSBOM(buildID string) ([]SBOMDocument, error) {
+	store, err := c.sbomStore()
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.GetBytes(buildID)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SBOM documents: %v", err)
+	}
+
+	var documents []SBOMDocument
+	if err := json.Unmarshal(data, &documents); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SBOM documents: %v", err)
+	}
+	return documents, nil
+}
+
+// PublishBuildSBOM announces the stored SBOM of a build so the API can index its packages
+func (c *NATSClient) PublishBuildSBOM(sbom *BuildSBOM) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("NATS connection is not healthy")
+	}
+
+	sbom.Timestamp = time.Now()
+	data, err := json.Marshal(sbom)
+	if err != nil {
+		return fmt.Errorf("failed to marshal build SBOM: %v", err)
+	}
+	return c.conn.Publish(BuildSBOMSubject(sbom.BuildID), data)
+}
+
+// SBOM_INDEXERS is the queue group API replicas share so each SBOM is indexed once
+const SBOM_INDEXERS = "mira-sbom-indexers"
+
+// SubscribeToBuildSBOMs subscribes to the SBOM announcements of all builds
+func (c *NATSClient) SubscribeToBuildSBOMs(handler func(*BuildSBOM)) (*nats.Subscription, error) {
+	return c.conn.QueueSubscribe(BuildSBOMSubject("*"), SBOM_INDEXERS, func(msg *nats.Msg) {
+		var sbom BuildSBOM
+		if err := json.Unmarshal(msg.Data, &sbom); err != nil {
+			fmt.Printf("Failed to unmarshal build SBOM: %v\n", err)
+			return
+		}
+		handler(&sbom)
+	})
+}
+
+// SBOMPackages lists the packages of the SBOM documents of a build. Every
+// buildpack layer is read from a single format, CycloneDX when available.
+func SBOMPackages(documents []SBOMDocument) []SBOMPackage {
+	// Pick one document per buildpack layer
+	chosen := make(map[string]SBOMDocument)
+	var order []string
+	for _, document := range documents {
+		key := document.Buildpack + "/" + document.Layer
+		current, ok := chosen[key]
+		if !ok {
+			order = append(order, key)
+		}
+		if !ok || sbomFormatRank(document.Format) < sbomFormatRank(current.Format) {
+			chosen[key] = document
+		}
+	}
+
+	var packages []SBOMPackage
+	seen := make(map[SBOMPackage]bool)
+	for _, key := range order {
+		for _, pkg := range documentPackages(chosen[key]) {
+			if pkg.Name != "" && !seen[pkg] {
+				seen[pkg] = true
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return packages
+}
+
+// sbomFormatRank returns the position of format in SBOMFormats
+func sbomFormatRank(format string) int {
+	for i, f := range SBOMFormats {
+		if f == format {
+			return i
+		}
+	}
+	return len(SBOMFormats)
+}
+
+// cycloneDXComponent is the part of a CycloneDX component read for the package index
+type cycloneDXComponent struct {
+	Name       string               `json:"name"`
+	Version    string               `json:"version"`
+	Type       string               `json:"type"`
+	PURL       string               `json:"purl"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+// documentPackages reads the packages of a single SBOM document
+func documentPackages(document SBOMDocument) []SBOMPackage {
+	var packages []SBOMPackage
+
+	switch document.Format {
+	case SBOM_FORMAT_CYCLONEDX:
+		var bom struct {
+			Components []cycloneDXComponent `json:"components"`
+		}
+		if err := json.Unmarshal(document.Content, &bom); err != nil {
+			return nil
+		}
+		var walk func(components []cycloneDXComponent)
+		walk = func(components []cycloneDXComponent) {
+			for _, component := range components {
+				packages = append(packages, SBOMPackage{Name: component.Name, Version: component.Version, Type: component.Type, PURL: component.PURL})
+				walk(component.Components)
+			}
+		}
+		walk(bom.Components)
+
+	case SBOM_FORMAT_SPDX:
+		var doc struct {
+			Packages []struct {
+				Name         string `json:"name"`
+				VersionInfo  string `json:"versionInfo"`
+				ExternalRefs []struct {
+					ReferenceType    string `json:"referenceType"`
+					ReferenceLocator string `json:"referenceLocator"`
+				} `json:"externalRefs"`
+			} `json:"packages"`
+		}
+		if err := json.Unmarshal(document.Content, &doc); err != nil {
+			return nil
+		}
+		for _, p := range doc.Packages {
+			pkg := SBOMPackage{Name: p.Name, Version: p.VersionInfo}
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					pkg.PURL = ref.ReferenceLocator
+				}
+			}
+			packages = append(packages, pkg)
+		}
+
+	case SBOM_FORMAT_SYFT:
+		var doc struct {
+			Artifacts []struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+				Type    string `json:"type"`
+				PURL    string `json:"purl"`
+			} `json:"artifacts"`
+		}
+		if err := json.Unmarshal(document.Content, &doc); err != nil {
+			return nil
+		}
+		for _, a := range doc.Artifacts {
+			packages = append(packages, SBOMPackage{Name: a.Name, Version: a.Version, Type: a.Type, PURL: a.PURL})
+		}
+	}
+
+	return packages
+}
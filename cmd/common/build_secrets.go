@@ -0,0 +1,65 @@
+package common
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+)
+
+// buildSecrets are the fields of a build spec that must not be stored in
+// plaintext by the build queue
+type buildSecrets struct {
+	AccessToken string            `json:"accessToken,omitempty"`
+	GitPassword string            `json:"gitPassword,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// SealSecrets moves the access token, the git password and the env of the
+// spec into SealedSecrets
+func (s *ImageBuilderSpec) SealSecrets(aead cipher.AEAD) error {
+	plaintext, err := json.Marshal(buildSecrets{
+		AccessToken: s.AccessToken,
+		GitPassword: s.Source.GitRepo.Password,
+		Env:         s.Env,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal build secrets: %v", err)
+	}
+	sealed, err := SealSecret(aead, plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt build secrets: %v", err)
+	}
+
+	s.SealedSecrets = sealed
+	s.AccessToken = ""
+	s.Source.GitRepo.Password = ""
+	s.Env = nil
+	return nil
+}
+
+// OpenSecrets reverses SealSecrets
+func (s *ImageBuilderSpec) OpenSecrets(aead cipher.AEAD) error {
+	if s.SealedSecrets == "" {
+		return nil
+	}
+	if aead == nil {
+		return fmt.Errorf("build secrets are encrypted but MIRA_SPEC_ENCRYPTION_KEY is not set")
+	}
+	plaintext, err := OpenSecret(aead, s.SealedSecrets)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt build secrets: %v", err)
+	}
+	var secrets buildSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("failed to unmarshal build secrets: %v", err)
+	}
+
+	s.AccessToken = secrets.AccessToken
+	s.Source.GitRepo.Password = secrets.GitPassword
+	s.Env = secrets.Env
+	if s.Env == nil {
+		s.Env = make(map[string]string)
+	}
+	s.SealedSecrets = ""
+	return nil
+}
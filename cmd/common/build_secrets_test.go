@@ -0,0 +1,49 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildSecretsSealAndOpen(t *testing.T) {
+	t.Setenv("MIRA_SPEC_ENCRYPTION_KEY", "test-key")
+	aead := NewSpecCipher()
+
+	spec := ImageBuilderSpec{
+		AccessToken: "crane-token",
+		Env:         map[string]string{"API_KEY": "env-secret"},
+	}
+	spec.Source.GitRepo.URL = "https://example.com/app.git"
+	spec.Source.GitRepo.Password = "git-secret"
+	original := spec
+
+	if err := spec.SealSecrets(aead); err != nil {
+		t.Fatalf("SealSecrets() error = %v", err)
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"crane-token", "env-secret", "git-secret", "API_KEY"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("sealed spec contains %q: %s", secret, data)
+		}
+	}
+
+	var queued ImageBuilderSpec
+	if err := json.Unmarshal(data, &queued); err != nil {
+		t.Fatal(err)
+	}
+	if err := queued.OpenSecrets(aead); err != nil {
+		t.Fatalf("OpenSecrets() error = %v", err)
+	}
+	if !reflect.DeepEqual(queued, original) {
+		t.Errorf("opened spec = %+v, want %+v", queued, original)
+	}
+
+	if err := spec.OpenSecrets(nil); err == nil {
+		t.Error("OpenSecrets() without a key succeeded")
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+// Build statuses. A build moves through them in the order given by
+// buildTransitions: queued → running → building → deploying → completed,
+// and can end early as failed, cancelled, timed_out or interrupted. Builds
+// that are not deployed go from building straight to completed.
+const (
+	BUILD_STATUS_QUEUED      = "queued"
+	BUILD_STATUS_RUNNING     = "running"
+	BUILD_STATUS_BUILDING    = "building"
+	BUILD_STATUS_DEPLOYING   = "deploying"
+	BUILD_STATUS_COMPLETED   = "completed"
+	BUILD_STATUS_FAILED      = "failed"
+	BUILD_STATUS_CANCELLED   = "cancelled"
+	BUILD_STATUS_TIMED_OUT   = "timed_out"
+	BUILD_STATUS_INTERRUPTED = "interrupted"
+)
+
+// buildTransitions lists the statuses each non-terminal status may move to
+var buildTransitions = map[string][]string{
+	BUILD_STATUS_QUEUED: {
+		BUILD_STATUS_RUNNING, BUILD_STATUS_FAILED, BUILD_STATUS_CANCELLED,
+	},
+	BUILD_STATUS_RUNNING: {
+		BUILD_STATUS_BUILDING, BUILD_STATUS_FAILED, BUILD_STATUS_CANCELLED,
+		BUILD_STATUS_TIMED_OUT, BUILD_STATUS_INTERRUPTED,
+	},
+	BUILD_STATUS_BUILDING: {
+		BUILD_STATUS_DEPLOYING, BUILD_STATUS_COMPLETED, BUILD_STATUS_FAILED, BUILD_STATUS_CANCELLED,
+		BUILD_STATUS_TIMED_OUT, BUILD_STATUS_INTERRUPTED,
+	},
+	BUILD_STATUS_DEPLOYING: {
+		BUILD_STATUS_COMPLETED, BUILD_STATUS_FAILED, BUILD_STATUS_CANCELLED,
+		BUILD_STATUS_TIMED_OUT, BUILD_STATUS_INTERRUPTED,
+	},
+	// Interrupted builds are back in the queue until another builder picks them up
+	BUILD_STATUS_INTERRUPTED: {
+		BUILD_STATUS_RUNNING, BUILD_STATUS_FAILED, BUILD_STATUS_CANCELLED,
+	},
+}
+
+// IsTerminalBuildStatus reports whether a build status is final
+func IsTerminalBuildStatus(status string) bool {
+	switch status {
+	case BUILD_STATUS_COMPLETED, BUILD_STATUS_FAILED, BUILD_STATUS_CANCELLED, BUILD_STATUS_TIMED_OUT:
+		return true
+	}
+	return false
+}
+
+// CanTransitionBuildStatus reports whether a build may move from one status to
+// another. from is empty for builds without a recorded status. A newer
+// delivery attempt may restart a build that a crashed builder left behind in
+// any non-terminal status.
+func CanTransitionBuildStatus(from, to string, newAttempt bool) bool {
+	if from == "" {
+		return true
+	}
+	if newAttempt && to == BUILD_STATUS_RUNNING && !IsTerminalBuildStatus(from) {
+		return true
+	}
+	for _, next := range buildTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
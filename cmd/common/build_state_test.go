@@ -0,0 +1,80 @@
+package common
+
+import "testing"
+
+func TestCanTransitionBuildStatus(t *testing.T) {
+	tests := []struct {
+		from, to   string
+		newAttempt bool
+		want       bool
+	}{
+		// The regular lifecycle
+		{"", BUILD_STATUS_QUEUED, false, true},
+		{"", BUILD_STATUS_RUNNING, false, true},
+		{BUILD_STATUS_QUEUED, BUILD_STATUS_RUNNING, false, true},
+		{BUILD_STATUS_RUNNING, BUILD_STATUS_BUILDING, false, true},
+		{BUILD_STATUS_BUILDING, BUILD_STATUS_DEPLOYING, false, true},
+		{BUILD_STATUS_DEPLOYING, BUILD_STATUS_COMPLETED, false, true},
+		// Builds that are not deployed finish after building
+		{BUILD_STATUS_BUILDING, BUILD_STATUS_COMPLETED, false, true},
+
+		// Early endings
+		{BUILD_STATUS_QUEUED, BUILD_STATUS_CANCELLED, false, true},
+		{BUILD_STATUS_QUEUED, BUILD_STATUS_FAILED, false, true},
+		{BUILD_STATUS_RUNNING, BUILD_STATUS_TIMED_OUT, false, true},
+		{BUILD_STATUS_BUILDING, BUILD_STATUS_INTERRUPTED, false, true},
+		{BUILD_STATUS_DEPLOYING, BUILD_STATUS_CANCELLED, false, true},
+		{BUILD_STATUS_INTERRUPTED, BUILD_STATUS_RUNNING, false, true},
+		{BUILD_STATUS_INTERRUPTED, BUILD_STATUS_CANCELLED, false, true},
+
+		// Skipped or backwards steps
+		{BUILD_STATUS_QUEUED, BUILD_STATUS_BUILDING, false, false},
+		{BUILD_STATUS_QUEUED, BUILD_STATUS_COMPLETED, false, false},
+		{BUILD_STATUS_QUEUED, BUILD_STATUS_TIMED_OUT, false, false},
+		{BUILD_STATUS_RUNNING, BUILD_STATUS_DEPLOYING, false, false},
+		{BUILD_STATUS_RUNNING, BUILD_STATUS_COMPLETED, false, false},
+		{BUILD_STATUS_BUILDING, BUILD_STATUS_RUNNING, false, false},
+		{BUILD_STATUS_DEPLOYING, BUILD_STATUS_BUILDING, false, false},
+		{BUILD_STATUS_INTERRUPTED, BUILD_STATUS_BUILDING, false, false},
+
+		// Terminal statuses are final
+		{BUILD_STATUS_COMPLETED, BUILD_STATUS_RUNNING, false, false},
+		{BUILD_STATUS_FAILED, BUILD_STATUS_COMPLETED, false, false},
+		{BUILD_STATUS_CANCELLED, BUILD_STATUS_FAILED, false, false},
+		{BUILD_STATUS_TIMED_OUT, BUILD_STATUS_QUEUED, false, false},
+
+		// A newer delivery restarts builds a crashed builder left behind
+		{BUILD_STATUS_BUILDING, BUILD_STATUS_RUNNING, true, true},
+		{BUILD_STATUS_DEPLOYING, BUILD_STATUS_RUNNING, true, true},
+		{BUILD_STATUS_RUNNING, BUILD_STATUS_RUNNING, true, true},
+		{BUILD_STATUS_DEPLOYING, BUILD_STATUS_BUILDING, true, false},
+		{BUILD_STATUS_COMPLETED, BUILD_STATUS_RUNNING, true, false},
+		{BUILD_STATUS_CANCELLED, BUILD_STATUS_RUNNING, true, false},
+	}
+
+	for _, tc := range tests {
+		got := CanTransitionBuildStatus(tc.from, tc.to, tc.newAttempt)
+		if got != tc.want {
+			t.Errorf("CanTransitionBuildStatus(%q, %q, %v) = %v, want %v", tc.from, tc.to, tc.newAttempt, got, tc.want)
+		}
+	}
+}
+
+func TestIsTerminalBuildStatus(t *testing.T) {
+	terminal := map[string]bool{
+		BUILD_STATUS_QUEUED:      false,
+		BUILD_STATUS_RUNNING:     false,
+		BUILD_STATUS_BUILDING:    false,
+		BUILD_STATUS_DEPLOYING:   false,
+		BUILD_STATUS_INTERRUPTED: false,
+		BUILD_STATUS_COMPLETED:   true,
+		BUILD_STATUS_FAILED:      true,
+		BUILD_STATUS_CANCELLED:   true,
+		BUILD_STATUS_TIMED_OUT:   true,
+	}
+	for status, want := range terminal {
+		if got := IsTerminalBuildStatus(status); got != want {
+			t.Errorf("IsTerminalBuildStatus(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
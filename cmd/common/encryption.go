@@ -0,0 +1,61 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+)
+
+// NewSpecCipher returns the cipher secrets stored in MongoDB or sent to the
+// image builders are sealed with, derived from MIRA_SPEC_ENCRYPTION_KEY. It
+// is nil when no key is set.
+func NewSpecCipher() cipher.AEAD {
+	key := os.Getenv("MIRA_SPEC_ENCRYPTION_KEY")
+	if key == "" {
+		return nil
+	}
+
+	// Derive a 256-bit key so any passphrase can be configured
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		log.Printf("Failed to create spec cipher: %v", err)
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Printf("Failed to create spec cipher: %v", err)
+		return nil
+	}
+	return aead
+}
+
+// SealSecret encrypts plaintext and returns it base64 encoded with its nonce prepended
+func SealSecret(aead cipher.AEAD, plaintext []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenSecret reverses SealSecret
+func OpenSecret(aead cipher.AEAD, encoded string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
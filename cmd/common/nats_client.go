@@ -2,12 +2,14 @@ package common
 
 import (
 	"context"
+	"crypto/cipher"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -15,8 +17,21 @@ import (
 
 // NATSClient provides utilities for NATS connections
 type NATSClient struct {
-	conn *nats.Conn
-	url  string
+	conn   *nats.Conn
+	url    string
+	closed chan struct{}
+
+	buildQueueMu    sync.Mutex
+	buildQueueReady bool
+
+	// sbomRetention bounds the SBOM store, checked once per client
+	sbomRetention  BlobRetention
+	sbomStoreMu    sync.Mutex
+	sbomStoreReady bool
+
+	// specCipher seals the secrets of build requests before they are queued
+	specCipher       cipher.AEAD
+	plaintextWarning sync.Once
 }
 
 // NewNATSClient creates a new NATS client
@@ -26,6 +41,7 @@ func NewNATSClient() (*NATSClient, error) {
 		natsURL = "nats://localhost:4222"
 	}
 
+	closed := make(chan struct{})
 	conn, err := nats.Connect(natsURL,
 		nats.ReconnectWait(2*time.Second),
 		nats.MaxReconnects(10),
@@ -35,14 +51,19 @@ func NewNATSClient() (*NATSClient, error) {
 		nats.ReconnectHandler(func(nc *nats.Conn) {
 			fmt.Printf("NATS reconnected to %v\n", nc.ConnectedUrl())
 		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			close(closed)
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
 	}
 
 	return &NATSClient{
-		conn: conn,
-		url:  natsURL,
+		conn:       conn,
+		url:        natsURL,
+		closed:     closed,
+		specCipher: NewSpecCipher(),
 	}, nil
 }
 
@@ -70,6 +91,27 @@ func (c *NATSClient) Close() {
 	}
 }
 
+// Drain unsubscribes from everything, lets pending messages be handled and
+// then closes the connection, waiting at most timeout for that to happen
+func (c *NATSClient) Drain(timeout time.Duration) error {
+	if c.conn == nil {
+		return nil
+	}
+
+	if err := c.conn.Drain(); err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	select {
+	case <-c.closed:
+		return nil
+	case <-time.After(timeout):
+		c.conn.Close()
+		return fmt.Errorf("NATS connection did not drain within %s", timeout)
+	}
+}
+
 // IsConnected checks if the NATS connection is healthy
 func (c *NATSClient) IsConnected() bool {
 	return c.conn != nil && c.conn.IsConnected()
@@ -91,13 +133,32 @@ func (c *NATSClient) PublishBuildRequest(request *BuildRequest) error {
 	return c.PublishBuildRequestWithContext(ctx, request)
 }
 
-// PublishBuildRequestWithContext publishes a build request with context support and retry logic
+// PublishBuildRequestWithContext publishes a build request to the lane of its priority in
+// the JetStream work queue with context support and retry logic
 func (c *NATSClient) PublishBuildRequestWithContext(ctx context.Context, request *BuildRequest) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("NATS connection is not healthy")
 	}
 
-	data, err := json.Marshal(request)
+	js, err := c.EnsureBuildQueue()
+	if err != nil {
+		return fmt.Errorf("build queue is not available: %v", err)
+	}
+
+	// The queue is kept on disk until the request is acked, so credentials
+	// and env values only enter it sealed. The caller keeps its copy in the clear.
+	queued := *request
+	if c.specCipher != nil {
+		if err := queued.Spec.SealSecrets(c.specCipher); err != nil {
+			return err
+		}
+	} else {
+		c.plaintextWarning.Do(func() {
+			log.Printf("MIRA_SPEC_ENCRYPTION_KEY not set, build requests are queued with their credentials and env values in plaintext")
+		})
+	}
+
+	data, err := json.Marshal(&queued)
 	if err != nil {
 		return fmt.Errorf("failed to marshal build request: %v", err)
 	}
@@ -113,7 +174,8 @@ func (c *NATSClient) PublishBuildRequestWithContext(ctx context.Context, request
 		default:
 		}
 
-		err = c.conn.Publish(SUBJECT_BUILD_REQUEST, data)
+		// The build ID doubles as the message ID so retried publishes are deduplicated
+		_, err = js.Publish(BuildRequestSubject(request.Priority), data, nats.MsgId(request.ID), nats.Context(ctx))
 		if err == nil {
 			log.Printf("Build request %s published successfully on attempt %d", request.ID, attempt+1)
 			return nil
@@ -155,18 +217,6 @@ func (c *NATSClient) PublishBuildRequestAsync(request *BuildRequest, onSuccess f
 	}()
 }
 
-// SubscribeToBuildRequests subscribes to build requests
-func (c *NATSClient) SubscribeToBuildRequests(handler func(*BuildRequest)) (*nats.Subscription, error) {
-	return c.conn.Subscribe(SUBJECT_BUILD_REQUEST, func(msg *nats.Msg) {
-		var request BuildRequest
-		if err := json.Unmarshal(msg.Data, &request); err != nil {
-			fmt.Printf("Failed to unmarshal build request: %v\n", err)
-			return
-		}
-		handler(&request)
-	})
-}
-
 // PublishBuildStatus publishes build status updates with enhanced error handling
 func (c *NATSClient) PublishBuildStatus(status *BuildStatus) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -175,12 +225,15 @@ func (c *NATSClient) PublishBuildStatus(status *BuildStatus) error {
 	return c.PublishBuildStatusWithContext(ctx, status)
 }
 
-// PublishBuildStatusWithContext publishes build status with context support and retry logic
+// PublishBuildStatusWithContext publishes build status with context support and retry logic.
+// The status is stamped with the current time.
 func (c *NATSClient) PublishBuildStatusWithContext(ctx context.Context, status *BuildStatus) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("NATS connection is not healthy")
 	}
 
+	status.Timestamp = time.Now()
+
 	data, err := json.Marshal(status)
 	if err != nil {
 		return fmt.Errorf("failed to marshal build status: %v", err)
@@ -301,6 +354,63 @@ func (c *NATSClient) SubscribeToBuildCompletion(buildID string, handler func(*Bu
 	})
 }
 
+// PublishBuildCancel publishes a cancellation request for a build
+func (c *NATSClient) PublishBuildCancel(cancel *BuildCancelRequest) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("NATS connection is not healthy")
+	}
+
+	data, err := json.Marshal(cancel)
+	if err != nil {
+		return fmt.Errorf("failed to marshal build cancel request: %v", err)
+	}
+
+	if err := c.conn.Publish(BuildCancelSubject(cancel.BuildID), data); err != nil {
+		return err
+	}
+	// Make sure the request left this process before the caller reports success
+	return c.conn.Flush()
+}
+
+// SubscribeToBuildCancels subscribes to cancellation requests for all builds.
+// Every builder receives every request and acts on the builds it owns.
+func (c *NATSClient) SubscribeToBuildCancels(handler func(*BuildCancelRequest)) (*nats.Subscription, error) {
+	return c.conn.Subscribe(BuildCancelSubject("*"), func(msg *nats.Msg) {
+		var cancel BuildCancelRequest
+		if err := json.Unmarshal(msg.Data, &cancel); err != nil {
+			fmt.Printf("Failed to unmarshal build cancel request: %v\n", err)
+			return
+		}
+		handler(&cancel)
+	})
+}
+
+// PublishBuilderLoad publishes an image builder load report
+func (c *NATSClient) PublishBuilderLoad(load *BuilderLoad) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("NATS connection is not healthy")
+	}
+
+	data, err := json.Marshal(load)
+	if err != nil {
+		return fmt.Errorf("failed to marshal builder load: %v", err)
+	}
+
+	return c.conn.Publish(BuilderLoadSubject(load.BuilderID), data)
+}
+
+// SubscribeToBuilderLoad subscribes to load reports from all image builders
+func (c *NATSClient) SubscribeToBuilderLoad(handler func(*BuilderLoad)) (*nats.Subscription, error) {
+	return c.conn.Subscribe(BuilderLoadSubject("*"), func(msg *nats.Msg) {
+		var load BuilderLoad
+		if err := json.Unmarshal(msg.Data, &load); err != nil {
+			fmt.Printf("Failed to unmarshal builder load: %v\n", err)
+			return
+		}
+		handler(&load)
+	})
+}
+
 // GetBuildLogs retrieves all logs for a specific build from JetStream
 //
 // This method fetches all historical log messages for a specific build ID
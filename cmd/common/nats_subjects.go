@@ -6,12 +6,32 @@ import "fmt"
 
 const (
 	// Core subjects
-	SUBJECT_BUILD_REQUEST = "mira.build.requests"
+	SUBJECT_BUILD_REQUEST = "mira.build.requests" // normal priority lane
+
+	// Priority lanes of the build work queue
+	SUBJECT_BUILD_REQUEST_HIGH = SUBJECT_BUILD_REQUEST + ".high"
+	SUBJECT_BUILD_REQUEST_LOW  = SUBJECT_BUILD_REQUEST + ".low"
 
 	// Subject patterns (use with fmt.Sprintf)
-	SUBJECT_BUILD_STATUS_PATTERN     = "mira.status.%s"     // %s = buildID
-	SUBJECT_BUILD_LOGS_PATTERN       = "mira.logs.%s"       // %s = buildID
-	SUBJECT_BUILD_COMPLETION_PATTERN = "mira.completion.%s" // %s = buildID
+	SUBJECT_BUILD_STATUS_PATTERN     = "mira.status.%s"        // %s = buildID
+	SUBJECT_BUILD_LOGS_PATTERN       = "mira.logs.%s"          // %s = buildID
+	SUBJECT_BUILD_COMPLETION_PATTERN = "mira.completion.%s"    // %s = buildID
+	SUBJECT_BUILD_CANCEL_PATTERN     = "mira.cancel.%s"        // %s = buildID
+	SUBJECT_BUILDER_LOAD_PATTERN     = "mira.builders.load.%s" // %s = builderID
+	SUBJECT_BUILD_SBOM_PATTERN       = "mira.sbom.%s"          // %s = buildID
+
+	// Request/reply subject answered by every image builder for its build caches
+	SUBJECT_BUILD_CACHE = "mira.builders.cache"
+
+	// JetStream work queue for build requests
+	STREAM_BUILD_REQUESTS   = "MIRA_BUILDS"
+	CONSUMER_BUILD_REQUESTS = "mira-builders" // normal priority lane
+
+	CONSUMER_BUILD_REQUESTS_HIGH = CONSUMER_BUILD_REQUESTS + "-high"
+	CONSUMER_BUILD_REQUESTS_LOW  = CONSUMER_BUILD_REQUESTS + "-low"
+
+	// Advisory published by JetStream when a build request exhausts its deliveries, for any lane consumer
+	SUBJECT_BUILD_MAX_DELIVERIES = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES." + STREAM_BUILD_REQUESTS + ".*"
 )
 
 // Subject builders for dynamic subjects
@@ -31,28 +51,51 @@ func BuildCompletionSubject(buildID string) string {
 	return fmt.Sprintf(SUBJECT_BUILD_COMPLETION_PATTERN, buildID)
 }
 
+// BuildCancelSubject returns the subject for cancelling a specific build
+func BuildCancelSubject(buildID string) string {
+	return fmt.Sprintf(SUBJECT_BUILD_CANCEL_PATTERN, buildID)
+}
+
+// BuilderLoadSubject returns the subject on which an image builder reports its load
+func BuilderLoadSubject(builderID string) string {
+	return fmt.Sprintf(SUBJECT_BUILDER_LOAD_PATTERN, builderID)
+}
+
+// BuildSBOMSubject returns the subject announcing the stored SBOM of a specific build
+func BuildSBOMSubject(buildID string) string {
+	return fmt.Sprintf(SUBJECT_BUILD_SBOM_PATTERN, buildID)
+}
+
 // NATSSubjects contains all NATS subject information for documentation
 type NATSSubjects struct {
 	BuildRequests   string
 	BuildStatus     string
 	BuildLogs       string
 	BuildCompletion string
+	BuildCancel     string
+	BuilderLoad     string
+	BuildCache      string
+	BuildSBOM       string
 }
 
 // GetSubjectsDocumentation returns documentation about all NATS subjects
 func GetSubjectsDocumentation() NATSSubjects {
 	return NATSSubjects{
-		BuildRequests:   SUBJECT_BUILD_REQUEST + " - Queue for containerization build requests",
-		BuildStatus:     SUBJECT_BUILD_STATUS_PATTERN + " - Build status updates (running, completed, failed)",
+		BuildRequests:   SUBJECT_BUILD_REQUEST + "[.high|.low] - JetStream work queue (" + STREAM_BUILD_REQUESTS + ") for containerization build requests, one lane per priority",
+		BuildStatus:     SUBJECT_BUILD_STATUS_PATTERN + " - Build status updates (running, completed, failed, cancelled)",
 		BuildLogs:       SUBJECT_BUILD_LOGS_PATTERN + " - Real-time build logs stream",
 		BuildCompletion: SUBJECT_BUILD_COMPLETION_PATTERN + " - Build completion notifications for WebSocket clients",
+		BuildCancel:     SUBJECT_BUILD_CANCEL_PATTERN + " - Build cancellation requests for the builder running the build",
+		BuilderLoad:     SUBJECT_BUILDER_LOAD_PATTERN + " - Periodic load reports from image builders",
+		BuildCache:      SUBJECT_BUILD_CACHE + " - Build cache inspect and clear requests answered by every image builder",
+		BuildSBOM:       SUBJECT_BUILD_SBOM_PATTERN + " - Announcements of SBOMs stored in the " + SBOM_BUCKET + " object store",
 	}
 }
 
 // ValidateSubject checks if a subject follows the expected patterns
 func ValidateSubject(subject string) bool {
 	switch {
-	case subject == SUBJECT_BUILD_REQUEST:
+	case subject == SUBJECT_BUILD_REQUEST, subject == SUBJECT_BUILD_REQUEST_HIGH, subject == SUBJECT_BUILD_REQUEST_LOW:
 		return true
 	case len(subject) > len("mira.status.") && subject[:len("mira.status.")] == "mira.status.":
 		return true
@@ -60,6 +103,14 @@ func ValidateSubject(subject string) bool {
 		return true
 	case len(subject) > len("mira.completion.") && subject[:len("mira.completion.")] == "mira.completion.":
 		return true
+	case len(subject) > len("mira.cancel.") && subject[:len("mira.cancel.")] == "mira.cancel.":
+		return true
+	case len(subject) > len("mira.builders.load.") && subject[:len("mira.builders.load.")] == "mira.builders.load.":
+		return true
+	case len(subject) > len("mira.sbom.") && subject[:len("mira.sbom.")] == "mira.sbom.":
+		return true
+	case subject == SUBJECT_BUILD_CACHE:
+		return true
 	default:
 		return false
 	}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Container registry types
+const (
+	REGISTRY_DOCKERHUB = "dockerhub"
+	REGISTRY_GHCR      = "ghcr"
+	REGISTRY_PRIVATE   = "private" // a self-hosted registry whose images need credentials to pull
+	REGISTRY_LOCAL     = "local"   // a registry:2 instance next to the builders, often served over plain HTTP
+)
+
+// RegistryTypes lists the registry types in the order they are documented
+var RegistryTypes = []string{REGISTRY_DOCKERHUB, REGISTRY_GHCR, REGISTRY_PRIVATE, REGISTRY_LOCAL}
+
+// Hosts of the public registries, as registry clients name them
+const (
+	DOCKERHUB_REGISTRY_HOST = "index.docker.io"
+	GHCR_REGISTRY_HOST      = "ghcr.io"
+)
+
+// ImageRegistry is the container registry the images of a project are pushed to
+type ImageRegistry struct {
+	Type      string `json:"type"`                // one of the REGISTRY_* values
+	Host      string `json:"host,omitempty"`      // host and port of private and local registries
+	Namespace string `json:"namespace,omitempty"` // user or organisation owning the repositories
+	Username  string `json:"username,omitempty"`
+	Password  string `json:"password,omitempty"`
+	// Password sealed with MIRA_SPEC_ENCRYPTION_KEY, as the API hands it to the builders
+	EncryptedPassword string `json:"encrypted_password,omitempty"`
+	Insecure          bool   `json:"insecure,omitempty"` // served over plain HTTP or with an untrusted certificate
+	Private           bool   `json:"private,omitempty"`  // pulling the images needs the credentials
+}
+
+// IsValidRegistryType reports whether t is one of the REGISTRY_* values
+func IsValidRegistryType(t string) bool {
+	for _, registryType := range RegistryTypes {
+		if registryType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultImageRegistry returns the Docker Hub account configured with
+// DOCKERHUB_USERNAME and DOCKERHUB_TOKEN, used by projects without a registry
+func DefaultImageRegistry() *ImageRegistry {
+	username := os.Getenv("DOCKERHUB_USERNAME")
+	namespace := username
+	if namespace == "" {
+		namespace = "default"
+	}
+	return &ImageRegistry{
+		Type:      REGISTRY_DOCKERHUB,
+		Namespace: namespace,
+		Username:  username,
+		Password:  os.Getenv("DOCKERHUB_TOKEN"),
+	}
+}
+
+// RegistryHost returns the host registry clients address the registry by
+func (r *ImageRegistry) RegistryHost() string {
+	switch r.Type {
+	case REGISTRY_DOCKERHUB:
+		return DOCKERHUB_REGISTRY_HOST
+	case REGISTRY_GHCR:
+		return GHCR_REGISTRY_HOST
+	}
+	return r.Host
+}
+
+// Repository returns the repository the image of an app is pushed to, without a tag
+func (r *ImageRegistry) Repository(projectID, appName string) string {
+	var parts []string
+	// Docker Hub repositories are referred to without their host
+	if r.Type != REGISTRY_DOCKERHUB {
+		parts = append(parts, r.RegistryHost())
+	}
+	if r.Namespace != "" {
+		parts = append(parts, r.Namespace)
+	}
+	parts = append(parts, projectID+appName)
+	return strings.Join(parts, "/")
+}
+
+// IsPrivate reports whether pulling images from the registry needs its credentials
+func (r *ImageRegistry) IsPrivate() bool {
+	return r.Type == REGISTRY_PRIVATE || r.Private
+}
+
+// HasCredentials reports whether the registry was configured with credentials
+func (r *ImageRegistry) HasCredentials() bool {
+	return r.Username != "" && r.Password != ""
+}
+
+// OpenPassword decrypts the sealed password of the registry into Password
+func (r *ImageRegistry) OpenPassword(aead cipher.AEAD) error {
+	if r.EncryptedPassword == "" {
+		return nil
+	}
+	if aead == nil {
+		return fmt.Errorf("registry password is encrypted but MIRA_SPEC_ENCRYPTION_KEY is not set")
+	}
+	password, err := OpenSecret(aead, r.EncryptedPassword)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt registry password: %v", err)
+	}
+	r.Password = string(password)
+	r.EncryptedPassword = ""
+	return nil
+}
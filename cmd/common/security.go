@@ -0,0 +1,120 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Vulnerability severities, from the most to the least severe
+const (
+	SEVERITY_CRITICAL = "critical"
+	SEVERITY_HIGH     = "high"
+	SEVERITY_MEDIUM   = "medium"
+	SEVERITY_LOW      = "low"
+	SEVERITY_UNKNOWN  = "unknown"
+)
+
+// Severities lists the vulnerability severities, the most severe first
+var Severities = []string{SEVERITY_CRITICAL, SEVERITY_HIGH, SEVERITY_MEDIUM, SEVERITY_LOW, SEVERITY_UNKNOWN}
+
+// IsValidSeverity reports whether s is one of the SEVERITY_* values
+func IsValidSeverity(s string) bool {
+	for _, severity := range Severities {
+		if severity == s {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeSeverity lowercases a severity, returning unknown for unrecognised values
+func NormalizeSeverity(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !IsValidSeverity(s) {
+		return SEVERITY_UNKNOWN
+	}
+	return s
+}
+
+// severityRank returns the position of a severity in Severities, lower being more severe
+func severityRank(s string) int {
+	for i, severity := range Severities {
+		if severity == s {
+			return i
+		}
+	}
+	return len(Severities)
+}
+
+// SecurityPolicy is the policy a project applies to the vulnerability scan of its images
+type SecurityPolicy struct {
+	// FailOn fails the build when a vulnerability at this severity or above is
+	// found, the scan only reports when empty
+	FailOn string `json:"failOn,omitempty"`
+	// Ignore lists the IDs of vulnerabilities that never fail the build
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+// Violations returns the vulnerabilities of a scan the policy fails the build for
+func (p *SecurityPolicy) Violations(report *ScanReport) []Vulnerability {
+	if p == nil || p.FailOn == "" || report == nil {
+		return nil
+	}
+
+	ignored := make(map[string]bool)
+	for _, id := range p.Ignore {
+		ignored[strings.ToUpper(id)] = true
+	}
+
+	var violations []Vulnerability
+	for _, vulnerability := range report.Vulnerabilities {
+		if ignored[strings.ToUpper(vulnerability.ID)] {
+			continue
+		}
+		if severityRank(vulnerability.Severity) <= severityRank(p.FailOn) {
+			violations = append(violations, vulnerability)
+		}
+	}
+	return violations
+}
+
+// Vulnerability is a known vulnerability of a package found in an image
+type Vulnerability struct {
+	ID           string `json:"id"`
+	Package      string `json:"package"`
+	Version      string `json:"version,omitempty"`
+	Severity     string `json:"severity"` // one of the SEVERITY_* values
+	FixedVersion string `json:"fixed_version,omitempty"`
+	Summary      string `json:"summary,omitempty"`
+}
+
+func (v Vulnerability) String() string {
+	return fmt.Sprintf("%s (%s) in %s %s", v.ID, v.Severity, v.Package, v.Version)
+}
+
+// ScanReport is the result of the vulnerability scan of an image
+type ScanReport struct {
+	Scanner         string          `json:"scanner"`
+	Packages        int             `json:"packages"` // packages that were checked
+	Vulnerabilities []Vulnerability `json:"vulnerabilities,omitempty"`
+	// Counts is the number of vulnerabilities found at each severity
+	Counts map[string]int `json:"counts,omitempty"`
+	// Blocked is true when the security policy of the project failed the build
+	Blocked   bool      `json:"blocked,omitempty"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// CountsSummary describes the vulnerability counts of a scan, the most severe first
+func (r *ScanReport) CountsSummary() string {
+	var parts []string
+	for _, severity := range Severities {
+		if n := r.Counts[severity]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, severity))
+		}
+	}
+	if len(parts) == 0 {
+		return "no vulnerabilities"
+	}
+	return strings.Join(parts, ", ")
+}
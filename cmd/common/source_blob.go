@@ -0,0 +1,296 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Source archive formats builds can be started from
+const (
+	SOURCE_ARCHIVE_ZIP    = "zip"
+	SOURCE_ARCHIVE_TAR_GZ = "tar.gz"
+)
+
+// SourceArchiveFormat returns the SOURCE_ARCHIVE_* format of an archive from
+// its file name, empty when the format is not supported
+func SourceArchiveFormat(filename string) string {
+	filename = strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(filename, ".zip"):
+		return SOURCE_ARCHIVE_ZIP
+	case strings.HasSuffix(filename, ".tar.gz"), strings.HasSuffix(filename, ".tgz"):
+		return SOURCE_ARCHIVE_TAR_GZ
+	}
+	return ""
+}
+
+// Blob stores source archives uploaded to the API are kept in
+const (
+	BLOB_STORE_NATS       = "nats"       // a JetStream object store, reachable from every builder
+	BLOB_STORE_FILESYSTEM = "filesystem" // a directory the API and the builders share, e.g. a volume
+)
+
+// SOURCES_BUCKET is the JetStream object store holding uploaded source archives
+const SOURCES_BUCKET = "MIRA_SOURCES"
+
+// ErrBlobNotFound is returned when a blob is not in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobRetention bounds the source archives a blob store keeps, or the SBOMs
+// of the SBOM store. Source archives are kept after their build so it can be
+// retried, builds of an uploaded archive can only be retried until it expires.
+type BlobRetention struct {
+	// TTL is how long a blob is kept after its upload
+	TTL time.Duration
+	// MaxBytes bounds the size of the store, 0 leaves it unbounded. The NATS
+	// store rejects uploads once full, the filesystem store removes its oldest blobs.
+	MaxBytes int64
+}
+
+// BlobStore keeps the source archives uploaded to the API for the builders
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	// Open returns the content of a blob, ErrBlobNotFound when it does not exist
+	Open(key string) (io.ReadCloser, error)
+}
+
+// NewBlobStore creates the blob store of kind, one of the BLOB_STORE_* values.
+// dir is the directory of the filesystem store.
+func NewBlobStore(kind, dir string, retention BlobRetention, natsClient *NATSClient) (BlobStore, error) {
+	switch kind {
+	case BLOB_STORE_NATS, "":
+		if natsClient == nil {
+			return nil, fmt.Errorf("the %s blob store needs a NATS connection", BLOB_STORE_NATS)
+		}
+		return &natsBlobStore{client: natsClient, retention: retention}, nil
+	case BLOB_STORE_FILESYSTEM:
+		if dir == "" {
+			return nil, fmt.Errorf("the %s blob store needs a directory", BLOB_STORE_FILESYSTEM)
+		}
+		return &fileBlobStore{dir: dir, retention: retention}, nil
+	}
+	return nil, fmt.Errorf("unsupported blob store: %s", kind)
+}
+
+// validBlobKey reports whether a key can be used as a blob name in every store
+func validBlobKey(key string) bool {
+	return key != "" && key != "." && key != ".." && filepath.Base(key) == key
+}
+
+// fileBlobStore keeps blobs as files of a directory. Expired blobs, then the
+// oldest ones beyond the size limit, are swept after each upload.
+type fileBlobStore struct {
+	dir       string
+	retention BlobRetention
+}
+
+func (s *fileBlobStore) Put(key string, r io.Reader) error {
+	if !validBlobKey(key) {
+		return fmt.Errorf("invalid blob key: %q", key)
+	}
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create blob directory: %v", err)
+	}
+
+	// Write to a temporary file first so builders never read a partial blob
+	tmp, err := os.CreateTemp(s.dir, "."+key+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, key)); err != nil {
+		return fmt.Errorf("failed to store blob: %v", err)
+	}
+
+	s.sweep(key)
+	return nil
+}
+
+// sweep removes the blobs older than the TTL, then the oldest blobs until the
+// store fits its size limit. The blob just stored as keep is never removed.
+func (s *fileBlobStore) sweep(keep string) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("Failed to read blob directory %s: %v", s.dir, err)
+		return
+	}
+
+	var blobs []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		expired := s.retention.TTL > 0 && time.Since(info.ModTime()) > s.retention.TTL
+		if expired && info.Name() != keep {
+			s.remove(info.Name())
+			continue
+		}
+		// Temporary files of uploads still being written start with a dot
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		blobs = append(blobs, info)
+		total += info.Size()
+	}
+	if s.retention.MaxBytes <= 0 || total <= s.retention.MaxBytes {
+		return
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().Before(blobs[j].ModTime())
+	})
+	for _, blob := range blobs {
+		if total <= s.retention.MaxBytes {
+			break
+		}
+		if blob.Name() == keep {
+			continue
+		}
+		if s.remove(blob.Name()) {
+			total -= blob.Size()
+		}
+	}
+}
+
+// remove deletes a blob file, reporting whether it is gone
+func (s *fileBlobStore) remove(name string) bool {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove blob %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+func (s *fileBlobStore) Open(key string) (io.ReadCloser, error) {
+	if !validBlobKey(key) {
+		return nil, fmt.Errorf("invalid blob key: %q", key)
+	}
+	file, err := os.Open(filepath.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %v", err)
+	}
+	return file, nil
+}
+
+// natsBlobStore keeps blobs in the SOURCES_BUCKET object store, which
+// JetStream expires and bounds according to the retention
+type natsBlobStore struct {
+	client    *NATSClient
+	retention BlobRetention
+
+	mu    sync.Mutex
+	ready bool // the retention of the store was checked
+}
+
+// store returns the sources object store, creating it on first use and
+// applying the retention to stores created without it
+func (s *natsBlobStore) store() (nats.ObjectStore, error) {
+	js, err := s.client.GetJetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, err := js.ObjectStore(SOURCES_BUCKET)
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      SOURCES_BUCKET,
+			Description: "Source archives uploaded to the API, by blob key",
+			Storage:     nats.FileStorage,
+			TTL:         s.retention.TTL,
+			MaxBytes:    s.retention.MaxBytes,
+		})
+		s.ready = err == nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source store: %v", err)
+	}
+
+	if !s.ready {
+		if err := applyObjectStoreRetention(js, SOURCES_BUCKET, s.retention); err != nil {
+			return nil, err
+		}
+		s.ready = true
+	}
+	return store, nil
+}
+
+// applyObjectStoreRetention updates the stream backing the object store of
+// bucket when its age or size limit differs from the retention
+func applyObjectStoreRetention(js nats.JetStreamContext, bucket string, retention BlobRetention) error {
+	streamName := "OBJ_" + bucket
+	stream, err := js.StreamInfo(streamName)
+	if err != nil {
+		return fmt.Errorf("failed to look up object store %s: %v", bucket, err)
+	}
+
+	maxBytes := retention.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = -1
+	}
+	if stream.Config.MaxAge == retention.TTL && stream.Config.MaxBytes == maxBytes {
+		return nil
+	}
+
+	streamConfig := stream.Config
+	streamConfig.MaxAge = retention.TTL
+	streamConfig.MaxBytes = maxBytes
+	if _, err := js.UpdateStream(&streamConfig); err != nil {
+		return fmt.Errorf("failed to update the retention of object store %s: %v", bucket, err)
+	}
+	log.Printf("Updated the retention of JetStream object store %s", bucket)
+	return nil
+}
+
+func (s *natsBlobStore) Put(key string, r io.Reader) error {
+	if !validBlobKey(key) {
+		return fmt.Errorf("invalid blob key: %q", key)
+	}
+	store, err := s.store()
+	if err != nil {
+		return err
+	}
+	if _, err := store.Put(&nats.ObjectMeta{Name: key}, r); err != nil {
+		return fmt.Errorf("failed to store blob: %v", err)
+	}
+	return nil
+}
+
+func (s *natsBlobStore) Open(key string) (io.ReadCloser, error) {
+	store, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	result, err := store.Get(key)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileBlobStoreSweep(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention BlobRetention
+		// ages of the blobs stored before the upload of "new"
+		ages map[string]time.Duration
+		want []string
+	}{
+		{
+			name:      "expired blobs are removed",
+			retention: BlobRetention{TTL: time.Hour},
+			ages:      map[string]time.Duration{"old": 2 * time.Hour, "recent": time.Minute},
+			want:      []string{"new", "recent"},
+		},
+		{
+			name:      "oldest blobs are removed beyond the size limit",
+			retention: BlobRetention{TTL: time.Hour, MaxBytes: 20},
+			ages:      map[string]time.Duration{"a": 3 * time.Minute, "b": 2 * time.Minute, "c": time.Minute},
+			want:      []string{"c", "new"},
+		},
+		{
+			name:      "the new blob is kept even when it alone exceeds the limit",
+			retention: BlobRetention{TTL: time.Hour, MaxBytes: 5},
+			ages:      map[string]time.Duration{"a": time.Minute},
+			want:      []string{"new"},
+		},
+		{
+			name:      "stale temporary files are removed",
+			retention: BlobRetention{TTL: time.Hour},
+			ages:      map[string]time.Duration{".upload-1": 2 * time.Hour, ".upload-2": time.Minute},
+			want:      []string{".upload-2", "new"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := &fileBlobStore{dir: dir, retention: tc.retention}
+			for name, age := range tc.ages {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modified := time.Now().Add(-age)
+				if err := os.Chtimes(path, modified, modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := store.Put("new", strings.NewReader("0123456789")); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("blobs = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
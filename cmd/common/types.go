@@ -9,19 +9,47 @@ type BuildRequest struct {
 	ID        string           `json:"id"`
 	Name      string           `json:"name"`
 	Spec      ImageBuilderSpec `json:"spec"`
+	Priority  string           `json:"priority,omitempty"` // one of the BUILD_PRIORITY_* values, normal when empty
 	Timestamp time.Time        `json:"timestamp"`
 }
 
+// Image build backends
+const (
+	BUILD_BACKEND_BUILDPACKS = "buildpacks" // Cloud Native Buildpacks through pack
+	BUILD_BACKEND_DOCKERFILE = "dockerfile" // the Dockerfile of the source code on the local Docker daemon
+)
+
+// Builder strategies of the buildpacks backend
+const (
+	BUILDER_STRATEGY_LANGUAGE = "language" // builder and buildpacks chosen for the detected language
+	BUILDER_STRATEGY_MIRA     = "mira"     // the Mira builder image with the Mira Node.js buildpack
+)
+
 // ImageBuilderSpec contains the build configuration
 type ImageBuilderSpec struct {
 	Source       ImageBuilderSource `json:"source"`
 	BuildCommand string             `json:"buildCommand"`
 	OutputDir    string             `json:"outputDir"`
+	RootDir      string             `json:"rootDir,omitempty"` // app directory within the source, the build command and OutputDir are relative to it
 	ProjectID    string             `json:"projectId,omitempty"`
 	AccessToken  string             `json:"accessToken"`
 	SSR          bool               `json:"ssr"`
 	Port         int                `json:"port,omitempty"`
 	Env          map[string]string  `json:"env"`
+	Backend      string             `json:"backend,omitempty"`      // one of the BUILD_BACKEND_* values, chosen from the source code when empty
+	BuilderImage string             `json:"builderImage,omitempty"` // overrides the buildpacks builder chosen for the language
+	Buildpacks   []string           `json:"buildpacks,omitempty"`   // overrides the buildpacks chosen for the language
+	// BuilderStrategy is one of the BUILDER_STRATEGY_* values, the builder default when empty
+	BuilderStrategy string `json:"builderStrategy,omitempty"`
+	StartCommand    string `json:"startCommand,omitempty"` // starts server-side rendered apps built with the Mira builder
+	Mode            string `json:"mode,omitempty"`         // one of the BUILD_MODE_* values, full when empty
+	// Registry is the registry of the project, the default Docker Hub account when nil
+	Registry *ImageRegistry `json:"registry,omitempty"`
+	// SecurityPolicy is the scan policy of the project, scans only report when nil
+	SecurityPolicy *SecurityPolicy `json:"securityPolicy,omitempty"`
+	// SealedSecrets holds AccessToken, the git password and Env sealed with
+	// MIRA_SPEC_ENCRYPTION_KEY while the request waits in the build queue
+	SealedSecrets string `json:"sealedSecrets,omitempty"`
 }
 
 // ImageBuilderSource represents the source code location
@@ -35,14 +63,20 @@ type ImageBuilderSource struct {
 type ImageBuilderGitRepo struct {
 	URL      string `json:"url"`
 	Branch   string `json:"branch,omitempty"`
-	Revision string `json:"revision,omitempty"`
+	Tag      string `json:"tag,omitempty"`      // checked out instead of Branch when set
+	Revision string `json:"revision,omitempty"` // commit SHA, full or abbreviated
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 }
 
 // ImageBuilderBlobFile represents uploaded file configuration
 type ImageBuilderBlobFile struct {
+	// Source is the URL the archive is downloaded from, when it was not uploaded
 	Source string `json:"source"`
+	// Key is the blob store key of an archive uploaded to the API
+	Key string `json:"key,omitempty"`
+	// Format is one of the SOURCE_ARCHIVE_* values, zip when empty
+	Format string `json:"format,omitempty"`
 }
 
 // BuildResponse represents the response from build request
@@ -68,22 +102,52 @@ type BuildStatus struct {
 	BuildID     string    `json:"build_id"`
 	ProjectID   string    `json:"project_id,omitempty"`
 	AppName     string    `json:"app_name,omitempty"`
-	Status      string    `json:"status"` // pending, running, completed, failed
+	Status      string    `json:"status"` // one of the BUILD_STATUS_* values
 	StartedAt   time.Time `json:"started_at,omitempty"`
 	CompletedAt time.Time `json:"completed_at,omitempty"`
 	Error       string    `json:"error,omitempty"`
-	ImageName   string    `json:"image_name,omitempty"`
+	ImageName   string    `json:"image_name,omitempty"`   // digest-pinned reference once the image is pushed
+	ImageDigest string    `json:"image_digest,omitempty"` // digest of the pushed image
+	ImageTags   []string  `json:"image_tags,omitempty"`   // tags the image was pushed with
+	Framework   string    `json:"framework,omitempty"`    // detected from the source code
+	CommitSHA   string    `json:"commit_sha,omitempty"`   // commit the git source resolved to
+	Attempt     int       `json:"attempt,omitempty"`      // delivery attempt of the build request, starting at 1
+	Timestamp   time.Time `json:"timestamp"`              // when the build entered this status
+	// Scan is the vulnerability scan of the image, once it was scanned
+	Scan *ScanReport `json:"scan,omitempty"`
+	// Artifact describes the image and the steps that ran so far
+	Artifact *BuildArtifact `json:"artifact,omitempty"`
 }
 
 // BuildCompletionMessage represents a build completion notification sent via WebSocket
 type BuildCompletionMessage struct {
-	Type      string    `json:"type"` // "build_completion"
-	BuildID   string    `json:"build_id"`
-	Status    string    `json:"status"`  // "completed" or "failed"
-	Message   string    `json:"message"` // Human readable message
-	Error     string    `json:"error,omitempty"`
-	ImageName string    `json:"image_name,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	Type        string    `json:"type"` // "build_completion"
+	BuildID     string    `json:"build_id"`
+	Status      string    `json:"status"`  // "completed", "failed", "cancelled" or "timed_out"
+	Message     string    `json:"message"` // Human readable message
+	Error       string    `json:"error,omitempty"`
+	ImageName   string    `json:"image_name,omitempty"`
+	ImageDigest string    `json:"image_digest,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	// Artifact describes the image and how long each step of the build took
+	Artifact *BuildArtifact `json:"artifact,omitempty"`
+}
+
+// BuildCancelRequest asks the builder running a build to stop it
+type BuildCancelRequest struct {
+	BuildID     string    `json:"build_id"`
+	Reason      string    `json:"reason,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// BuilderLoad is a periodic report of how busy an image builder is
+type BuilderLoad struct {
+	BuilderID     string    `json:"builder_id"`
+	Active        int       `json:"active"`         // builds currently running
+	Queued        int       `json:"queued"`         // builds pulled from NATS and waiting for a worker
+	MaxConcurrent int       `json:"max_concurrent"` // worker pool size
+	QueueSize     int       `json:"queue_size"`     // internal queue capacity
+	Timestamp     time.Time `json:"timestamp"`
 }
 
 // Logger interface defines the methods that any logger must implement
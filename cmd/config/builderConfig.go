@@ -0,0 +1,172 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	common "mira/cmd/common"
+
+	"github.com/google/uuid"
+)
+
+// BuilderConfig holds image builder configuration
+type BuilderConfig struct {
+	// BuilderID identifies this builder in load reports
+	BuilderID string
+	// MaxConcurrentBuilds is the number of builds run in parallel
+	MaxConcurrentBuilds int
+	// QueueSize is the number of pulled builds that may wait for a free worker
+	QueueSize int
+	// LoadReportInterval is how often the builder publishes its load
+	LoadReportInterval time.Duration
+	// LaneStarvationLimit is how many builds in a row may come from higher
+	// priority lanes before a waiting lower lane is served, 0 disables it
+	LaneStarvationLimit int
+
+	// ValidationTimeout limits the app name validation stage
+	ValidationTimeout time.Duration
+	// SourceTimeout limits fetching the source code
+	SourceTimeout time.Duration
+	// BuildTimeout limits the image build stage
+	BuildTimeout time.Duration
+	// ScanTimeout limits the vulnerability scan stage
+	ScanTimeout time.Duration
+	// DeployTimeout limits the Crane Cloud deployment stage
+	DeployTimeout time.Duration
+	// BuildDeadline limits the whole pipeline
+	BuildDeadline time.Duration
+
+	// ShutdownGracePeriod is how long running builds may take to finish after SIGTERM
+	ShutdownGracePeriod time.Duration
+
+	// BuilderStrategy is the builder strategy of builds that do not request one
+	BuilderStrategy string
+	// MiraBuilderImage is the builder image used by the mira builder strategy
+	MiraBuilderImage string
+
+	// BuildCacheFormat is how the build cache of each app is kept: volume, image or off
+	BuildCacheFormat string
+
+	// ImageTags is the tag policy: the tags each image is pushed with, in
+	// order, out of sha7, buildId, semver and latest
+	ImageTags []string
+
+	// WorkspaceDir holds the workspace of each running build, keyed by build ID
+	WorkspaceDir string
+
+	// BlobStore is where the source archives uploaded to the API are read from: nats or filesystem
+	BlobStore string
+	// BlobDir is the directory of the filesystem blob store, shared with the API
+	BlobDir string
+	// BlobRetention is how long and how much of the uploaded source archives are kept
+	BlobRetention common.BlobRetention
+	// SBOMRetention is how long and how much of the SBOMs of built images are kept
+	SBOMRetention common.BlobRetention
+
+	// GitCloneDepth is the history depth of git clones made without the mirror cache, 0 clones everything
+	GitCloneDepth int
+	// GitCacheDir holds a bare mirror of each git repository the builder cloned
+	GitCacheDir string
+	// GitCacheMaxSizeMB bounds the mirror cache, least recently used mirrors
+	// are evicted beyond it. 0 disables the cache, builds then clone shallowly.
+	GitCacheMaxSizeMB int
+
+	// Scanner is the vulnerability scanner images are scanned with: vulndb or off
+	Scanner string
+	// VulnDBPath is the offline vulnerability database file of the vulndb scanner
+	VulnDBPath string
+}
+
+// NewBuilderConfig creates a new image builder configuration from the environment
+func NewBuilderConfig() *BuilderConfig {
+	builderID := os.Getenv("MIRA_BUILDER_ID")
+	if builderID == "" {
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "builder"
+		}
+		builderID = hostname + "-" + uuid.New().String()[:8]
+	}
+
+	return &BuilderConfig{
+		BuilderID:           builderID,
+		MaxConcurrentBuilds: intFromEnv("MIRA_MAX_CONCURRENT_BUILDS", 2),
+		QueueSize:           intFromEnv("MIRA_BUILD_QUEUE_SIZE", 2),
+		LoadReportInterval:  durationFromEnv("MIRA_LOAD_REPORT_INTERVAL", 10*time.Second),
+		LaneStarvationLimit: intFromEnv("MIRA_LANE_STARVATION_LIMIT", 5),
+		ValidationTimeout:   durationFromEnv("MIRA_VALIDATION_TIMEOUT", time.Minute),
+		SourceTimeout:       durationFromEnv("MIRA_SOURCE_TIMEOUT", 10*time.Minute),
+		BuildTimeout:        durationFromEnv("MIRA_BUILD_TIMEOUT", 30*time.Minute),
+		ScanTimeout:         durationFromEnv("MIRA_SCAN_TIMEOUT", 5*time.Minute),
+		DeployTimeout:       durationFromEnv("MIRA_DEPLOY_TIMEOUT", 5*time.Minute),
+		BuildDeadline:       durationFromEnv("MIRA_BUILD_DEADLINE", 45*time.Minute),
+		ShutdownGracePeriod: durationFromEnv("MIRA_SHUTDOWN_GRACE_PERIOD", 2*time.Minute),
+		BuilderStrategy:     stringFromEnv("MIRA_BUILDER_STRATEGY", common.BUILDER_STRATEGY_LANGUAGE),
+		MiraBuilderImage:    stringFromEnv("MIRA_BUILDER_IMAGE", "cranecloudplatform/mira-builder:latest"),
+		BuildCacheFormat:    stringFromEnv("MIRA_BUILD_CACHE", "volume"),
+		ImageTags:           listFromEnv("MIRA_IMAGE_TAGS", []string{"sha7", "buildId", "semver", "latest"}),
+		WorkspaceDir:        stringFromEnv("MIRA_WORKSPACE_DIR", "/usr/local/crane/workspaces"),
+		BlobStore:           stringFromEnv("MIRA_BLOB_STORE", common.BLOB_STORE_NATS),
+		BlobDir:             stringFromEnv("MIRA_BLOB_DIR", "/usr/local/crane/blobs"),
+		BlobRetention:       blobRetentionFromEnv(),
+		SBOMRetention:       sbomRetentionFromEnv(),
+		GitCloneDepth:       intFromEnv("MIRA_GIT_CLONE_DEPTH", 1),
+		GitCacheDir:         stringFromEnv("MIRA_GIT_CACHE_DIR", "/usr/local/crane/git-cache"),
+		GitCacheMaxSizeMB:   intFromEnv("MIRA_GIT_CACHE_MAX_SIZE_MB", 2048),
+		Scanner:             stringFromEnv("MIRA_SCANNER", "off"),
+		VulnDBPath:          os.Getenv("MIRA_VULN_DB_PATH"),
+	}
+}
+
+// stringFromEnv reads a string from the environment, falling back to def
+func stringFromEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// listFromEnv reads a comma separated list from the environment, falling back to def
+func listFromEnv(key string, def []string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+// intFromEnv reads a positive integer from the environment, falling back to def
+func intFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s value: %s, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// durationFromEnv reads a Go duration (e.g. "90s", "15m") from the environment, falling back to def
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s value: %s, using %s", key, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"time"
+
+	common "mira/cmd/common"
+)
+
+// ServerConfig holds API server configuration
+type ServerConfig struct {
+	// ShutdownTimeout is how long in-flight requests may take to finish after SIGTERM
+	ShutdownTimeout time.Duration
+	// NATSDrainTimeout is how long pending NATS messages may take to be handled on shutdown
+	NATSDrainTimeout time.Duration
+	// IdempotencyWindow is how long an Idempotency-Key header maps to the build it created
+	IdempotencyWindow time.Duration
+	// MaxUploadSizeMB bounds request bodies, and so the source archives uploaded to the API
+	MaxUploadSizeMB int
+	// BlobStore is where uploaded source archives are kept for the builders: nats or filesystem
+	BlobStore string
+	// BlobDir is the directory of the filesystem blob store, shared with the builders
+	BlobDir string
+	// BlobRetention is how long and how much of the uploaded source archives
+	// are kept. Builds of an uploaded archive can be retried until it expires.
+	BlobRetention common.BlobRetention
+	// SBOMRetention is how long and how much of the SBOMs of built images are kept
+	SBOMRetention common.BlobRetention
+}
+
+// NewServerConfig creates a new API server configuration from the environment
+func NewServerConfig() *ServerConfig {
+	return &ServerConfig{
+		ShutdownTimeout:   durationFromEnv("MIRA_API_SHUTDOWN_TIMEOUT", 20*time.Second),
+		NATSDrainTimeout:  durationFromEnv("MIRA_NATS_DRAIN_TIMEOUT", 5*time.Second),
+		IdempotencyWindow: durationFromEnv("MIRA_IDEMPOTENCY_WINDOW", 24*time.Hour),
+		MaxUploadSizeMB:   intFromEnv("MIRA_MAX_UPLOAD_SIZE_MB", 100),
+		BlobStore:         stringFromEnv("MIRA_BLOB_STORE", common.BLOB_STORE_NATS),
+		BlobDir:           stringFromEnv("MIRA_BLOB_DIR", "/usr/local/crane/blobs"),
+		BlobRetention:     blobRetentionFromEnv(),
+		SBOMRetention:     sbomRetentionFromEnv(),
+	}
+}
+
+// blobRetentionFromEnv reads the retention of uploaded source archives,
+// shared by the API and the builders as either may create the blob store
+func blobRetentionFromEnv() common.BlobRetention {
+	return common.BlobRetention{
+		TTL:      durationFromEnv("MIRA_BLOB_TTL", 30*24*time.Hour),
+		MaxBytes: int64(intFromEnv("MIRA_BLOB_MAX_SIZE_MB", 10240)) << 20,
+	}
+}
+
+// sbomRetentionFromEnv reads the retention of the SBOM store, shared by the
+// API and the builders as either may create it
+func sbomRetentionFromEnv() common.BlobRetention {
+	return common.BlobRetention{
+		TTL:      durationFromEnv("MIRA_SBOM_TTL", 90*24*time.Hour),
+		MaxBytes: int64(intFromEnv("MIRA_SBOM_MAX_SIZE_MB", 5120)) << 20,
+	}
+}
@@ -1,21 +1,36 @@
 package imagebuilder
 
 import (
+	"context"
 	"log"
+	"os/signal"
+	"syscall"
+	"time"
 
 	common "mira/cmd/common"
+	"mira/cmd/config"
 	"mira/cmd/image-builder/handlers"
+	"mira/cmd/image-builder/services"
 )
 
+// fetchWait is how long an idle builder waits for new work before pulling from the build queue again
+const fetchWait = 5 * time.Second
+
 // ProcessBuildRequest handles a build request received from NATS
 // This function now delegates to the structured handler
 func ProcessBuildRequest(buildReq *common.BuildRequest, natsClient *common.NATSClient) error {
-	buildHandler := handlers.NewBuildHandler(natsClient)
-	return buildHandler.ProcessBuildRequest(buildReq)
+	buildHandler := handlers.NewBuildHandler(natsClient, config.NewBuilderConfig())
+	return buildHandler.ProcessBuildRequest(buildReq, 1)
 }
 
 // Listen starts the image builder service and listens for build requests
+// until it receives SIGTERM or SIGINT
 func Listen() {
+	builderConfig := config.NewBuilderConfig()
+
+	shutdown, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	// Create NATS client
 	natsClient, err := common.NewNATSClient()
 	if err != nil {
@@ -23,33 +38,106 @@ func Listen() {
 		return
 	}
 	defer natsClient.Close()
+	natsClient.SetSBOMRetention(builderConfig.SBOMRetention)
 
-	log.Printf("MIRA Image Builder started, listening for build requests...")
-
-	// Create build handler
-	buildHandler := handlers.NewBuildHandler(natsClient)
+	log.Printf("MIRA Image Builder %s started (max %d concurrent builds, queue size %d), listening for build requests...",
+		builderConfig.BuilderID, builderConfig.MaxConcurrentBuilds, builderConfig.QueueSize)
 
-	// Subscribe to build requests
-	_, err = natsClient.SubscribeToBuildRequests(func(buildReq *common.BuildRequest) {
-		log.Printf("Received build request: %s", buildReq.ID)
+	// Remove the workspaces of builds that were running when a builder died
+	services.NewWorkspaceService(builderConfig).SweepOrphans()
 
-		// Process build request in a goroutine to handle multiple requests concurrently
-		go func() {
-			err := buildHandler.ProcessBuildRequest(buildReq)
-			if err != nil {
-				log.Printf("Build request %s failed: %v", buildReq.ID, err)
-			} else {
-				log.Printf("Build request %s completed successfully", buildReq.ID)
-			}
-		}()
-	})
+	// Create build handler and the worker pool that runs it
+	buildHandler := handlers.NewBuildHandler(natsClient, builderConfig)
+	pool := NewWorkerPool(buildHandler, builderConfig.MaxConcurrentBuilds, builderConfig.QueueSize)
+	pool.Start()
 
+	// Pull build requests from the shared durable consumer of each priority lane
+	sub, err := natsClient.SubscribeToBuildRequests()
 	if err != nil {
 		log.Printf("Error subscribing to build requests: %v", err)
 		return
 	}
+	lanes := newLaneScheduler(sub, builderConfig.LaneStarvationLimit)
+
+	// Fail builds that no builder managed to finish within the delivery limit
+	_, err = natsClient.SubscribeToExhaustedBuildRequests(buildHandler.FailExhaustedBuild)
+	if err != nil {
+		log.Printf("Error subscribing to exhausted build requests: %v", err)
+		return
+	}
+
+	// Stop builds when their cancel endpoint is called
+	_, err = natsClient.SubscribeToBuildCancels(buildHandler.CancelBuild)
+	if err != nil {
+		log.Printf("Error subscribing to build cancellations: %v", err)
+		return
+	}
+
+	// Answer build cache requests from the API
+	_, err = natsClient.SubscribeToBuildCacheRequests(services.NewCacheService(builderConfig).HandleRequest)
+	if err != nil {
+		log.Printf("Error subscribing to build cache requests: %v", err)
+		return
+	}
+
+	go reportLoad(shutdown, natsClient, pool, builderConfig)
 
-	// Keep the service running
 	log.Printf("Image builder is ready to process build requests")
-	select {} // Block forever
+	for shutdown.Err() == nil {
+		// Only pull as much work as the pool can take, leave the rest to other builders
+		free := pool.FreeSlots()
+		if free <= 0 {
+			pool.WaitForSlot(fetchWait)
+			continue
+		}
+
+		deliveries, err := lanes.Fetch(shutdown, free)
+		if err != nil {
+			if shutdown.Err() != nil {
+				break
+			}
+			log.Printf("Error fetching build requests: %v", err)
+			time.Sleep(fetchWait)
+			continue
+		}
+		if len(deliveries) == 0 {
+			sub.WaitForWork(shutdown, fetchWait)
+			continue
+		}
+
+		for _, delivery := range deliveries {
+			priority := common.NormalizeBuildPriority(delivery.Request.Priority)
+			if delivery.Redelivered() {
+				log.Printf("Received redelivered %s priority build request: %s (delivery %d)", priority, delivery.Request.ID, delivery.NumDelivered())
+			} else {
+				log.Printf("Received %s priority build request: %s", priority, delivery.Request.ID)
+			}
+			pool.Submit(delivery)
+		}
+	}
+
+	// Stop taking new work and give running builds the grace period to finish.
+	// The cancel and status subscriptions stay up until the pool is drained.
+	log.Printf("Shutdown requested, no longer accepting build requests")
+	sub.Unsubscribe()
+	pool.Shutdown(builderConfig.ShutdownGracePeriod)
+	log.Printf("Image builder %s stopped", builderConfig.BuilderID)
+}
+
+// reportLoad periodically publishes the pool load so operators can size
+// builder replicas, until the builder starts shutting down
+func reportLoad(ctx context.Context, natsClient *common.NATSClient, pool *WorkerPool, builderConfig *config.BuilderConfig) {
+	ticker := time.NewTicker(builderConfig.LoadReportInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := natsClient.PublishBuilderLoad(pool.Load(builderConfig.BuilderID)); err != nil {
+			log.Printf("Failed to publish builder load: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
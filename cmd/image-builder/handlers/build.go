@@ -1,65 +1,209 @@
 package handlers
 
 import (
+	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	common "mira/cmd/common"
+	"mira/cmd/config"
 	"mira/cmd/image-builder/models"
 	"mira/cmd/image-builder/services"
 	imageUtils "mira/cmd/image-builder/utils"
 )
 
+// ErrBuildCancelled is the cause attached to the context of a build stopped through a cancel request
+var ErrBuildCancelled = errors.New("build cancelled")
+
+// ErrBuildInterrupted is the cause attached to the context of a build stopped
+// because its builder shut down. Interrupted builds are handed back to the queue.
+var ErrBuildInterrupted = errors.New("build interrupted by builder shutdown")
+
+// errStageTimeout and errBuildDeadline are the causes attached to contexts
+// that ran past a stage timeout or the overall build deadline
+var (
+	errStageTimeout  = errors.New("stage timeout exceeded")
+	errBuildDeadline = errors.New("build deadline exceeded")
+)
+
+// StageTimeoutError reports the pipeline stage that was running when a
+// stage timeout or the overall build deadline expired
+type StageTimeoutError struct {
+	Stage    string
+	Limit    time.Duration
+	Deadline bool
+}
+
+func (e *StageTimeoutError) Error() string {
+	if e.Deadline {
+		return fmt.Sprintf("build deadline of %s exceeded during the %s stage", e.Limit, e.Stage)
+	}
+	return fmt.Sprintf("%s stage timed out after %s", e.Stage, e.Limit)
+}
+
+// pendingCancelTTL is how long a cancel request for a build that is not running
+// here is remembered, in case the build is still waiting in the queue
+const pendingCancelTTL = time.Hour
+
 // BuildHandler handles build orchestration
 type BuildHandler struct {
 	gitService        *services.GitService
 	buildService      *services.BuildService
+	scanService       *services.ScanService
+	workspaces        *services.WorkspaceService
 	deployService     *services.DeployService
 	validationService *services.ValidationService
 	natsClient        *common.NATSClient
+	config            *config.BuilderConfig
+	specCipher        cipher.AEAD // opens the secrets and registry passwords sealed by the API
+
+	mu             sync.Mutex
+	activeBuilds   map[string]context.CancelCauseFunc
+	pendingCancels map[string]time.Time
 }
 
 // NewBuildHandler creates a new build handler with all required services
-func NewBuildHandler(natsClient *common.NATSClient) *BuildHandler {
+func NewBuildHandler(natsClient *common.NATSClient, builderConfig *config.BuilderConfig) *BuildHandler {
+	blobs, err := common.NewBlobStore(builderConfig.BlobStore, builderConfig.BlobDir, builderConfig.BlobRetention, natsClient)
+	if err != nil {
+		log.Printf("Warning: builds of uploaded source archives will fail: %v", err)
+	}
 	return &BuildHandler{
-		gitService:        services.NewGitService(),
-		buildService:      services.NewBuildService(),
+		gitService:        services.NewGitService(builderConfig, blobs),
+		buildService:      services.NewBuildService(builderConfig),
+		scanService:       services.NewScanService(builderConfig),
+		workspaces:        services.NewWorkspaceService(builderConfig),
 		deployService:     services.NewDeployService(),
 		validationService: services.NewValidationService(),
 		natsClient:        natsClient,
+		config:            builderConfig,
+		specCipher:        common.NewSpecCipher(),
+		activeBuilds:      make(map[string]context.CancelCauseFunc),
+		pendingCancels:    make(map[string]time.Time),
 	}
 }
 
-// ProcessBuildRequest handles a build request received from NATS
-func (h *BuildHandler) ProcessBuildRequest(buildReq *common.BuildRequest) error {
+// CancelBuild stops a build running on this builder. Requests for builds that
+// are not running here are remembered so the build is dropped if it is
+// picked up later.
+func (h *BuildHandler) CancelBuild(cancelReq *common.BuildCancelRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cancel, ok := h.activeBuilds[cancelReq.BuildID]; ok {
+		log.Printf("Cancelling build %s", cancelReq.BuildID)
+		cancel(ErrBuildCancelled)
+		return
+	}
+
+	for buildID, requestedAt := range h.pendingCancels {
+		if time.Since(requestedAt) > pendingCancelTTL {
+			delete(h.pendingCancels, buildID)
+		}
+	}
+	h.pendingCancels[cancelReq.BuildID] = time.Now()
+}
+
+// InterruptBuilds stops every build running on this builder, used when the
+// shutdown grace period ran out
+func (h *BuildHandler) InterruptBuilds() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for buildID, cancel := range h.activeBuilds {
+		log.Printf("Interrupting build %s", buildID)
+		cancel(ErrBuildInterrupted)
+	}
+}
+
+// startBuild registers a build as running and returns its context, which
+// expires at the overall build deadline. The context is already cancelled if
+// a cancel request arrived before the build was picked up.
+func (h *BuildHandler) startBuild(buildID string) (context.Context, func()) {
+	cancelCtx, cancel := context.WithCancelCause(context.Background())
+	ctx, stop := context.WithTimeoutCause(cancelCtx, h.config.BuildDeadline, errBuildDeadline)
+
+	h.mu.Lock()
+	h.activeBuilds[buildID] = cancel
+	if _, ok := h.pendingCancels[buildID]; ok {
+		delete(h.pendingCancels, buildID)
+		cancel(ErrBuildCancelled)
+	}
+	h.mu.Unlock()
+
+	return ctx, func() {
+		h.mu.Lock()
+		delete(h.activeBuilds, buildID)
+		h.mu.Unlock()
+		stop()
+		cancel(nil)
+	}
+}
+
+// ProcessBuildRequest handles a build request received from NATS. attempt is
+// the delivery attempt of the request, starting at 1.
+func (h *BuildHandler) ProcessBuildRequest(buildReq *common.BuildRequest, attempt int) error {
 	log.SetFlags(log.Ldate | log.Ltime)
 	log.Printf("MIRA Processing build request: %s", buildReq.ID)
 
 	// Create NATS logger for this build
 	logger := common.NewMongoNATSLogger(h.natsClient.GetConnection(), buildReq.ID)
 
-	// Publish build status: started
+	ctx, done := h.startBuild(buildReq.ID)
+	defer done()
+
 	status := &common.BuildStatus{
 		BuildID:   buildReq.ID,
 		ProjectID: buildReq.Spec.ProjectID,
 		AppName:   buildReq.Name,
-		Status:    "running",
-		StartedAt: time.Now(),
+		Attempt:   attempt,
+		Artifact:  &common.BuildArtifact{},
+	}
+
+	// Drop builds that were cancelled while waiting in the queue
+	if ctx.Err() != nil {
+		return h.finishCancelled(buildReq, status, logger)
 	}
+
+	// Publish build status: started
+	status.Status = common.BUILD_STATUS_RUNNING
+	status.StartedAt = time.Now()
 	h.natsClient.PublishBuildStatus(status)
 
 	// Convert BuildRequest to internal build spec
 	buildSpec := imageUtils.ConvertToBuildSpec(buildReq)
 
 	// Execute build pipeline
-	err := h.executeBuildPipeline(buildSpec, logger)
+	err := h.executeBuildPipeline(ctx, buildSpec, status, logger)
+
+	// An image pushed before the build stopped stays in the registry, so it is
+	// reported whichever way the build ends
+	if buildSpec.ImageDigest != "" {
+		status.ImageName = imageUtils.ImageReference(buildSpec)
+		status.ImageDigest = buildSpec.ImageDigest
+	}
+
+	if err != nil && errors.Is(context.Cause(ctx), ErrBuildCancelled) {
+		return h.finishCancelled(buildReq, status, logger)
+	}
+	if err != nil && errors.Is(context.Cause(ctx), ErrBuildInterrupted) {
+		return h.finishInterrupted(buildReq, status, logger)
+	}
+	var timeoutErr *StageTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return h.finishTimedOut(buildReq, status, timeoutErr, logger)
+	}
 	if err != nil {
 		log.Printf("Error creating image: %v", err)
 		logger.ErrorWithStep("build", fmt.Sprintf("Build failed: %v", err))
 
 		// Update status: failed
-		status.Status = "failed"
+		status.Status = common.BUILD_STATUS_FAILED
 		status.CompletedAt = time.Now()
 		status.Error = err.Error()
 		h.natsClient.PublishBuildStatus(status)
@@ -68,10 +212,11 @@ func (h *BuildHandler) ProcessBuildRequest(buildReq *common.BuildRequest) error
 		completion := &common.BuildCompletionMessage{
 			Type:      "build_completion",
 			BuildID:   buildReq.ID,
-			Status:    "failed",
+			Status:    common.BUILD_STATUS_FAILED,
 			Message:   fmt.Sprintf("Build failed: %v", err),
 			Error:     err.Error(),
 			Timestamp: time.Now(),
+			Artifact:  status.Artifact,
 		}
 		h.natsClient.PublishBuildCompletion(completion)
 
@@ -79,52 +224,227 @@ func (h *BuildHandler) ProcessBuildRequest(buildReq *common.BuildRequest) error
 	}
 
 	// Log successful completion
-	imageName := imageUtils.GenerateImageName(buildSpec)
-	logger.InfoWithStep("deploy", "SUCCESSFULLY DEPLOYED IMAGE TO CRANE CLOUD: "+imageName)
-	log.Printf("Image created and deployed successfully: %s", buildSpec.Name)
+	imageName := imageUtils.ImageReference(buildSpec)
+	if common.BuildModeDeploys(buildSpec.Spec.Mode) {
+		logger.InfoWithStep("deploy", "SUCCESSFULLY DEPLOYED IMAGE TO CRANE CLOUD: "+imageName)
+		log.Printf("Image created and deployed successfully: %s", buildSpec.Name)
+	} else {
+		logger.InfoWithStep("build", "Build finished in "+buildSpec.Spec.Mode+" mode, image "+imageName+" was not deployed")
+		log.Printf("Image created successfully in %s mode: %s", buildSpec.Spec.Mode, buildSpec.Name)
+	}
 
 	// Update status: completed
-	status.Status = "completed"
+	status.Status = common.BUILD_STATUS_COMPLETED
 	status.CompletedAt = time.Now()
 	status.ImageName = imageName
+	status.ImageDigest = buildSpec.ImageDigest
 	h.natsClient.PublishBuildStatus(status)
 
 	// Publish build completion notification
+	completion := &common.BuildCompletionMessage{
+		Type:        "build_completion",
+		BuildID:     buildReq.ID,
+		Status:      common.BUILD_STATUS_COMPLETED,
+		Message:     fmt.Sprintf("Build completed successfully. Image: %s", imageName),
+		ImageName:   imageName,
+		ImageDigest: buildSpec.ImageDigest,
+		Timestamp:   time.Now(),
+		Artifact:    status.Artifact,
+	}
+	h.natsClient.PublishBuildCompletion(completion)
+
+	return nil
+}
+
+// finishCancelled reports a build as cancelled
+func (h *BuildHandler) finishCancelled(buildReq *common.BuildRequest, status *common.BuildStatus, logger common.Logger) error {
+	log.Printf("Build %s cancelled", buildReq.ID)
+	logger.InfoWithStep("build", "Build cancelled")
+
+	status.Status = common.BUILD_STATUS_CANCELLED
+	status.CompletedAt = time.Now()
+	h.natsClient.PublishBuildStatus(status)
+
+	completion := &common.BuildCompletionMessage{
+		Type:        "build_completion",
+		BuildID:     buildReq.ID,
+		Status:      common.BUILD_STATUS_CANCELLED,
+		Message:     "Build cancelled",
+		ImageName:   status.ImageName,
+		ImageDigest: status.ImageDigest,
+		Timestamp:   time.Now(),
+		Artifact:    status.Artifact,
+	}
+	h.natsClient.PublishBuildCompletion(completion)
+
+	return ErrBuildCancelled
+}
+
+// finishInterrupted reports a build stopped by a builder shutdown. No
+// completion is published because the build is queued again.
+func (h *BuildHandler) finishInterrupted(buildReq *common.BuildRequest, status *common.BuildStatus, logger common.Logger) error {
+	log.Printf("Build %s interrupted", buildReq.ID)
+	logger.ErrorWithStep("build", "Build interrupted because the image builder is shutting down, it will be retried")
+
+	status.Status = common.BUILD_STATUS_INTERRUPTED
+	status.CompletedAt = time.Now()
+	status.Error = ErrBuildInterrupted.Error()
+	h.natsClient.PublishBuildStatus(status)
+
+	return ErrBuildInterrupted
+}
+
+// finishTimedOut reports a build whose stage ran past its timeout
+func (h *BuildHandler) finishTimedOut(buildReq *common.BuildRequest, status *common.BuildStatus, timeoutErr *StageTimeoutError, logger common.Logger) error {
+	log.Printf("Build %s timed out: %v", buildReq.ID, timeoutErr)
+	logger.ErrorWithStep(timeoutErr.Stage, "Build timed out: "+timeoutErr.Error())
+
+	status.Status = common.BUILD_STATUS_TIMED_OUT
+	status.CompletedAt = time.Now()
+	status.Error = timeoutErr.Error()
+	h.natsClient.PublishBuildStatus(status)
+
+	completion := &common.BuildCompletionMessage{
+		Type:        "build_completion",
+		BuildID:     buildReq.ID,
+		Status:      common.BUILD_STATUS_TIMED_OUT,
+		Message:     "Build timed out: " + timeoutErr.Error(),
+		Error:       timeoutErr.Error(),
+		ImageName:   status.ImageName,
+		ImageDigest: status.ImageDigest,
+		Timestamp:   time.Now(),
+		Artifact:    status.Artifact,
+	}
+	h.natsClient.PublishBuildCompletion(completion)
+
+	return timeoutErr
+}
+
+// FailExhaustedBuild marks a build as failed once the work queue gave up
+// redelivering it, e.g. because every builder that picked it up died mid-build
+func (h *BuildHandler) FailExhaustedBuild(buildReq *common.BuildRequest) {
+	log.Printf("Build request %s exceeded its delivery limit, marking it as failed", buildReq.ID)
+
+	errMsg := fmt.Sprintf("build was interrupted %d times and will not be retried", common.BuildQueueMaxDeliver())
+
+	logger := common.NewMongoNATSLogger(h.natsClient.GetConnection(), buildReq.ID)
+	logger.ErrorWithStep("build", "Build failed: "+errMsg)
+
+	status := &common.BuildStatus{
+		BuildID:     buildReq.ID,
+		ProjectID:   buildReq.Spec.ProjectID,
+		AppName:     buildReq.Name,
+		Status:      common.BUILD_STATUS_FAILED,
+		CompletedAt: time.Now(),
+		Error:       errMsg,
+	}
+	h.natsClient.PublishBuildStatus(status)
+
 	completion := &common.BuildCompletionMessage{
 		Type:      "build_completion",
 		BuildID:   buildReq.ID,
-		Status:    "completed",
-		Message:   fmt.Sprintf("Build completed successfully. Image: %s", imageName),
-		ImageName: imageName,
+		Status:    common.BUILD_STATUS_FAILED,
+		Message:   "Build failed: " + errMsg,
+		Error:     errMsg,
 		Timestamp: time.Now(),
 	}
 	h.natsClient.PublishBuildCompletion(completion)
-
-	return nil
 }
 
-// executeBuildPipeline runs the complete build pipeline
-func (h *BuildHandler) executeBuildPipeline(buildSpec *models.BuildSpec, logger common.Logger) error {
-	// Step 1: Validate app name (check if app already exists)
-	err := h.validationService.ValidateAppName(buildSpec, logger)
+// executeBuildPipeline runs the build pipeline, publishing the building and
+// deploying statuses as it goes. The build mode decides whether the app name
+// is validated and the image deployed to Crane Cloud. Images are scanned
+// before they are deployed. Every step stops early
+// once ctx is done and is limited by its own stage timeout.
+func (h *BuildHandler) executeBuildPipeline(ctx context.Context, buildSpec *models.BuildSpec, status *common.BuildStatus, logger common.Logger) error {
+	buildSpec.Spec.Mode = common.NormalizeBuildMode(buildSpec.Spec.Mode)
+	deploy := common.BuildModeDeploys(buildSpec.Spec.Mode)
+	if !deploy {
+		logger.InfoWithStep("build", "Running in "+buildSpec.Spec.Mode+" mode, skipping app name validation and deployment")
+	}
+
+	// The API seals the credentials, env values and project registry password
+	// before they enter the queue
+	if err := buildSpec.Spec.OpenSecrets(h.specCipher); err != nil {
+		return fmt.Errorf("build setup failed: %w", err)
+	}
+	if buildSpec.Spec.Registry != nil {
+		if err := buildSpec.Spec.Registry.OpenPassword(h.specCipher); err != nil {
+			return fmt.Errorf("registry setup failed: %w", err)
+		}
+	}
+
+	// The source code is kept in a workspace of the build, removed whichever way it ends
+	workspace, err := h.workspaces.Create(status.BuildID)
 	if err != nil {
-		return fmt.Errorf("app name validation failed: %w", err)
+		return fmt.Errorf("workspace setup failed: %w", err)
+	}
+	defer workspace.Remove()
+	buildSpec.Workspace = workspace.Dir
+
+	// Step 1: Validate app name (check if app already exists)
+	if deploy {
+		err = h.runStage(ctx, status.Artifact, "validation", h.config.ValidationTimeout, func(ctx context.Context) error {
+			return h.validationService.ValidateAppName(ctx, buildSpec, logger)
+		})
+		if err != nil {
+			return fmt.Errorf("app name validation failed: %w", err)
+		}
 	}
 
 	// Step 2: Handle source code (git clone or file download)
-	sourcePath, err := h.handleSourceCode(buildSpec, logger)
+	var sourcePath string
+	err = h.runStage(ctx, status.Artifact, "source", h.config.SourceTimeout, func(ctx context.Context) error {
+		sourcePath, err = h.handleSourceCode(ctx, buildSpec, logger)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("source handling failed: %w", err)
 	}
 
+	// The image tags depend on the commit that was checked out
+	buildSpec.ImageTags = imageUtils.ImageTags(buildSpec, h.config.ImageTags)
+	logger.InfoWithStep("build", "Tagging image with "+strings.Join(buildSpec.ImageTags, ", "))
+
 	// Step 3: Build the image
-	err = h.buildService.BuildImage(buildSpec, sourcePath, logger)
+	status.Status = common.BUILD_STATUS_BUILDING
+	status.Framework = buildSpec.Framework
+	status.CommitSHA = buildSpec.CommitSHA
+	status.ImageTags = buildSpec.ImageTags
+	h.natsClient.PublishBuildStatus(status)
+	err = h.runStage(ctx, status.Artifact, "build", h.config.BuildTimeout, func(ctx context.Context) error {
+		if err := h.buildService.BuildImage(ctx, buildSpec, sourcePath, logger); err != nil {
+			return err
+		}
+		if err := h.buildService.DescribeImage(ctx, buildSpec, status.Artifact); err != nil {
+			logger.ErrorWithStep("build", fmt.Sprintf("Could not describe the image: %v", err))
+		}
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("image build failed: %w", err)
 	}
+	h.storeSBOM(buildSpec, status, logger)
 
-	// Step 4: Deploy to Crane Cloud
-	err = h.deployService.DeployToCraneCloud(buildSpec, logger)
+	// Step 4: Scan the image, the security policy of the project may fail the build
+	err = h.runStage(ctx, status.Artifact, "security", h.config.ScanTimeout, func(ctx context.Context) error {
+		var scanErr error
+		status.Scan, scanErr = h.scanService.ScanImage(ctx, buildSpec, logger)
+		return scanErr
+	})
+	if err != nil {
+		return fmt.Errorf("security check failed: %w", err)
+	}
+
+	// Step 5: Deploy to Crane Cloud
+	if !deploy {
+		return nil
+	}
+	status.Status = common.BUILD_STATUS_DEPLOYING
+	h.natsClient.PublishBuildStatus(status)
+	err = h.runStage(ctx, status.Artifact, "deploy", h.config.DeployTimeout, func(ctx context.Context) error {
+		return h.deployService.DeployToCraneCloud(ctx, buildSpec, logger)
+	})
 	if err != nil {
 		return fmt.Errorf("deployment failed: %w", err)
 	}
@@ -132,15 +452,72 @@ func (h *BuildHandler) executeBuildPipeline(buildSpec *models.BuildSpec, logger
 	return nil
 }
 
+// storeSBOM stores the SBOM documents of the image and announces them so the
+// API indexes their packages. A missing SBOM does not fail the build.
+func (h *BuildHandler) storeSBOM(buildSpec *models.BuildSpec, status *common.BuildStatus, logger common.Logger) {
+	if len(buildSpec.SBOM) == 0 {
+		logger.InfoWithStep("sbom", "No SBOM was produced for the image")
+		return
+	}
+
+	if err := h.natsClient.PutBuildSBOM(status.BuildID, buildSpec.SBOM); err != nil {
+		logger.ErrorWithStep("sbom", fmt.Sprintf("Failed to store the SBOM: %v", err))
+		return
+	}
+
+	sbom := &common.BuildSBOM{
+		BuildID:   status.BuildID,
+		ProjectID: status.ProjectID,
+		AppName:   status.AppName,
+		Documents: len(buildSpec.SBOM),
+	}
+	for _, format := range common.SBOMFormats {
+		for _, document := range buildSpec.SBOM {
+			if document.Format == format {
+				sbom.Formats = append(sbom.Formats, format)
+				break
+			}
+		}
+	}
+	if err := h.natsClient.PublishBuildSBOM(sbom); err != nil {
+		logger.ErrorWithStep("sbom", fmt.Sprintf("Failed to announce the SBOM: %v", err))
+		return
+	}
+	logger.InfoWithStep("sbom", fmt.Sprintf("Stored %d SBOM documents (%s)", sbom.Documents, strings.Join(sbom.Formats, ", ")))
+}
+
+// runStage runs a pipeline stage under its timeout and records how long it
+// ran on the artifact. Errors caused by the stage timeout or the overall build
+// deadline are replaced by a StageTimeoutError naming the stage.
+func (h *BuildHandler) runStage(ctx context.Context, artifact *common.BuildArtifact, stage string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	stageCtx, cancel := context.WithTimeoutCause(ctx, timeout, errStageTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := fn(stageCtx)
+	artifact.RecordStep(stage, time.Since(started))
+	if err == nil {
+		return nil
+	}
+
+	switch context.Cause(stageCtx) {
+	case errStageTimeout:
+		return &StageTimeoutError{Stage: stage, Limit: timeout}
+	case errBuildDeadline:
+		return &StageTimeoutError{Stage: stage, Limit: h.config.BuildDeadline, Deadline: true}
+	}
+	return err
+}
+
 // handleSourceCode handles git cloning or file downloading based on source type
-func (h *BuildHandler) handleSourceCode(buildSpec *models.BuildSpec, logger common.Logger) (string, error) {
+func (h *BuildHandler) handleSourceCode(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) (string, error) {
 	switch buildSpec.Source.Type {
 	case "git":
 		logger.InfoWithStep("clone", "Fetching Codebase from Git Repository")
-		return h.gitService.CloneRepository(buildSpec, logger)
+		return h.gitService.CloneRepository(ctx, buildSpec, logger)
 	case "file":
-		logger.InfoWithStep("download", "Downloading File from URL")
-		return h.gitService.HandleFileSource(buildSpec, logger)
+		logger.InfoWithStep("download", "Fetching Source Archive")
+		return h.gitService.HandleFileSource(ctx, buildSpec, logger)
 	default:
 		return "", fmt.Errorf("unsupported source type: %s", buildSpec.Source.Type)
 	}
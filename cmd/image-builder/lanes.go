@@ -0,0 +1,81 @@
+package imagebuilder
+
+import (
+	"context"
+	"time"
+
+	common "mira/cmd/common"
+)
+
+// lanePollWait is how long a pull from a single priority lane waits for work
+// before the next lane is tried
+const lanePollWait = 200 * time.Millisecond
+
+// laneScheduler decides which priority lane a builder pulls from next. Higher
+// lanes are always tried first, except that a lane passed over for
+// starvationLimit builds in a row is tried before the higher ones once.
+type laneScheduler struct {
+	sub             *common.BuildRequestSubscription
+	starvationLimit int
+	// skipped counts builds taken from higher lanes since a lane was last served or found empty
+	skipped map[string]int
+}
+
+func newLaneScheduler(sub *common.BuildRequestSubscription, starvationLimit int) *laneScheduler {
+	return &laneScheduler{
+		sub:             sub,
+		starvationLimit: starvationLimit,
+		skipped:         make(map[string]int),
+	}
+}
+
+// order returns the lanes to try, highest first, with the most starved lane moved to the front
+func (s *laneScheduler) order() []string {
+	lanes := append([]string(nil), common.BuildPriorities...)
+	if s.starvationLimit <= 0 {
+		return lanes
+	}
+
+	// The lowest lanes are the most likely to starve, look at them first
+	for i := len(lanes) - 1; i > 0; i-- {
+		if s.skipped[lanes[i]] >= s.starvationLimit {
+			starved := lanes[i]
+			copy(lanes[1:i+1], lanes[:i])
+			lanes[0] = starved
+			break
+		}
+	}
+	return lanes
+}
+
+// Fetch pulls up to batch build requests from the first lane that has any.
+// It returns no deliveries and no error when all lanes are empty.
+func (s *laneScheduler) Fetch(ctx context.Context, batch int) ([]*common.BuildDelivery, error) {
+	for _, lane := range s.order() {
+		laneCtx, cancel := context.WithTimeout(ctx, lanePollWait)
+		deliveries, err := s.sub.Fetch(laneCtx, lane, batch)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(deliveries) == 0 {
+			// Nothing is waiting, so the lane is not being starved
+			s.skipped[lane] = 0
+			continue
+		}
+
+		s.served(lane, len(deliveries))
+		return deliveries, nil
+	}
+	return nil, nil
+}
+
+// served records that n builds were taken from lane
+func (s *laneScheduler) served(lane string, n int) {
+	s.skipped[lane] = 0
+	for _, lower := range common.BuildPriorities {
+		if common.BuildPriorityRank(lower) > common.BuildPriorityRank(lane) {
+			s.skipped[lower] += n
+		}
+	}
+}
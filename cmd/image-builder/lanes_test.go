@@ -0,0 +1,97 @@
+package imagebuilder
+
+import (
+	"reflect"
+	"testing"
+
+	common "mira/cmd/common"
+)
+
+func TestLaneSchedulerOrder(t *testing.T) {
+	high, normal, low := common.BUILD_PRIORITY_HIGH, common.BUILD_PRIORITY_NORMAL, common.BUILD_PRIORITY_LOW
+
+	tests := []struct {
+		name            string
+		starvationLimit int
+		// served lists the lanes builds were taken from, one build each, in order
+		served []string
+		// empty lists the lanes then found empty
+		empty []string
+		want  []string
+	}{
+		{
+			name:            "higher lanes first",
+			starvationLimit: 3,
+			want:            []string{high, normal, low},
+		},
+		{
+			name:            "below the starvation limit",
+			starvationLimit: 3,
+			served:          []string{high, high},
+			want:            []string{high, normal, low},
+		},
+		{
+			name:            "starved normal lane goes first",
+			starvationLimit: 3,
+			served:          []string{high, high, high},
+			empty:           []string{low},
+			want:            []string{normal, high, low},
+		},
+		{
+			name:            "starved low lane goes first",
+			starvationLimit: 3,
+			served:          []string{high, normal, normal},
+			want:            []string{low, high, normal},
+		},
+		{
+			name:            "lowest starved lane goes first",
+			starvationLimit: 2,
+			served:          []string{high, high},
+			want:            []string{low, high, normal},
+		},
+		{
+			name:            "serving a lane resets its count",
+			starvationLimit: 3,
+			served:          []string{high, high, normal, high},
+			want:            []string{low, high, normal},
+		},
+		{
+			name:            "strict priority without a limit",
+			starvationLimit: 0,
+			served:          []string{high, high, high, high, high},
+			want:            []string{high, normal, low},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			scheduler := newLaneScheduler(nil, tc.starvationLimit)
+			for _, lane := range tc.served {
+				scheduler.served(lane, 1)
+			}
+			for _, lane := range tc.empty {
+				scheduler.skipped[lane] = 0
+			}
+			if got := scheduler.order(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("order() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLaneSchedulerServedCountsBatches(t *testing.T) {
+	scheduler := newLaneScheduler(nil, 5)
+	scheduler.served(common.BUILD_PRIORITY_HIGH, 3)
+	scheduler.served(common.BUILD_PRIORITY_NORMAL, 2)
+
+	want := map[string]int{
+		common.BUILD_PRIORITY_HIGH:   0,
+		common.BUILD_PRIORITY_NORMAL: 0,
+		common.BUILD_PRIORITY_LOW:    5,
+	}
+	for lane, skipped := range want {
+		if scheduler.skipped[lane] != skipped {
+			t.Errorf("skipped[%s] = %d, want %d", lane, scheduler.skipped[lane], skipped)
+		}
+	}
+}
@@ -11,6 +11,29 @@ type BuildSpec struct {
 	Name   string                    `json:"name"`
 	Spec   common.ImageBuilderSpec   `json:"spec"`
 	Source common.ImageBuilderSource `json:"source"`
+	// Framework is the main framework detected in the source code
+	Framework string `json:"framework,omitempty"`
+	// Language is the main language detected in the source code
+	Language string `json:"language,omitempty"`
+
+	// BuildID is the ID of the build request
+	BuildID string `json:"build_id"`
+	// Workspace is the directory of the build, removed once it is over
+	Workspace string `json:"-"`
+	// CommitSHA is the commit checked out from the git repository
+	CommitSHA string `json:"commit_sha,omitempty"`
+	// GitTag is the semantic version tag pointing at the checked out commit
+	GitTag string `json:"git_tag,omitempty"`
+	// ImageTags are the tags the image is pushed with, chosen by the tag policy
+	ImageTags []string `json:"image_tags,omitempty"`
+	// ImageDigest is the digest of the pushed image, set by the build backend
+	ImageDigest string `json:"image_digest,omitempty"`
+	// Backend is the build backend the image was built with
+	Backend string `json:"backend,omitempty"`
+	// BuilderImage is the buildpacks builder the image was built with
+	BuilderImage string `json:"builder_image,omitempty"`
+	// SBOM holds the SBOM documents the buildpacks wrote for the image
+	SBOM []common.SBOMDocument `json:"-"`
 }
 
 // BuildStatus represents the status of a build operation
@@ -0,0 +1,198 @@
+package imagebuilder
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/handlers"
+)
+
+// interruptWait is how long interrupted builds get to clean up and report
+const interruptWait = 30 * time.Second
+
+// WorkerPool runs pulled build requests on a fixed number of workers.
+// Requests that arrive while all workers are busy wait in a bounded internal
+// queue; once that is full the builder stops pulling from NATS so the work
+// stays available to other builders.
+type WorkerPool struct {
+	buildHandler  *handlers.BuildHandler
+	maxConcurrent int
+	queueSize     int
+
+	jobs  chan *common.BuildDelivery
+	freed chan struct{}
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	active   int
+	queued   int
+	draining bool
+}
+
+// NewWorkerPool creates a worker pool with maxConcurrent workers and an internal queue of queueSize
+func NewWorkerPool(buildHandler *handlers.BuildHandler, maxConcurrent, queueSize int) *WorkerPool {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	return &WorkerPool{
+		buildHandler:  buildHandler,
+		maxConcurrent: maxConcurrent,
+		queueSize:     queueSize,
+		jobs:          make(chan *common.BuildDelivery, maxConcurrent+queueSize),
+		freed:         make(chan struct{}, 1),
+	}
+}
+
+// Start launches the workers
+func (p *WorkerPool) Start() {
+	for i := 0; i < p.maxConcurrent; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+// Stop closes the queue and waits for the workers to finish the builds they hold
+func (p *WorkerPool) Stop() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// Shutdown drains the pool. Requests still waiting for a worker go back to
+// the queue right away, running builds get the grace period to finish and
+// are interrupted and requeued after it. No requests may be submitted once
+// Shutdown was called.
+func (p *WorkerPool) Shutdown(grace time.Duration) {
+	p.mu.Lock()
+	p.draining = true
+	active, queued := p.active, p.queued
+	p.mu.Unlock()
+
+	log.Printf("Draining worker pool: %d running, %d queued builds", active, queued)
+	close(p.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(grace):
+	}
+
+	log.Printf("Shutdown grace period of %s is over, interrupting running builds", grace)
+	p.buildHandler.InterruptBuilds()
+
+	select {
+	case <-done:
+	case <-time.After(interruptWait):
+		log.Printf("Interrupted builds did not stop within %s", interruptWait)
+	}
+}
+
+// FreeSlots returns how many more build requests the pool can take right now
+func (p *WorkerPool) FreeSlots() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxConcurrent + p.queueSize - p.active - p.queued
+}
+
+// WaitForSlot blocks until a worker finishes a build or the timeout passes
+func (p *WorkerPool) WaitForSlot(timeout time.Duration) {
+	select {
+	case <-p.freed:
+	case <-time.After(timeout):
+	}
+}
+
+// Submit hands a pulled build request to the pool. Callers must only submit
+// as many requests as FreeSlots reported.
+func (p *WorkerPool) Submit(delivery *common.BuildDelivery) {
+	p.mu.Lock()
+	p.queued++
+	p.mu.Unlock()
+
+	p.jobs <- delivery
+}
+
+// Load returns the current pool load
+func (p *WorkerPool) Load(builderID string) *common.BuilderLoad {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &common.BuilderLoad{
+		BuilderID:     builderID,
+		Active:        p.active,
+		Queued:        p.queued,
+		MaxConcurrent: p.maxConcurrent,
+		QueueSize:     p.queueSize,
+		Timestamp:     time.Now(),
+	}
+}
+
+// worker runs queued build requests until the queue is closed
+func (p *WorkerPool) worker() {
+	defer p.wg.Done()
+
+	for delivery := range p.jobs {
+		p.mu.Lock()
+		p.queued--
+		draining := p.draining
+		if !draining {
+			p.active++
+		}
+		p.mu.Unlock()
+
+		// Leave requests that did not start yet to other builders
+		if draining {
+			if err := delivery.Nak(0); err != nil {
+				log.Printf("Failed to requeue build request %s: %v", delivery.Request.ID, err)
+			}
+			continue
+		}
+
+		p.run(delivery)
+
+		p.mu.Lock()
+		p.active--
+		p.mu.Unlock()
+
+		// Wake the fetch loop without blocking if it is not waiting
+		select {
+		case p.freed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run processes a single build request and acks it once it reached a terminal state
+func (p *WorkerPool) run(delivery *common.BuildDelivery) {
+	err := p.buildHandler.ProcessBuildRequest(delivery.Request, int(delivery.NumDelivered()))
+	if err != nil {
+		log.Printf("Build request %s failed: %v", delivery.Request.ID, err)
+	} else {
+		log.Printf("Build request %s completed successfully", delivery.Request.ID)
+	}
+
+	// Interrupted builds go back to the queue for another builder
+	if errors.Is(err, handlers.ErrBuildInterrupted) {
+		if err := delivery.Nak(0); err != nil {
+			log.Printf("Failed to requeue build request %s: %v", delivery.Request.ID, err)
+		}
+		return
+	}
+
+	// Completed and failed are both terminal, the request leaves the queue either way
+	if err := delivery.Ack(); err != nil {
+		log.Printf("Failed to ack build request %s: %v", delivery.Request.ID, err)
+	}
+}
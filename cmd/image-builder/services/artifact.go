@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/models"
+	imageUtils "mira/cmd/image-builder/utils"
+
+	dockerClient "github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// buildMetadataLabel is the label the lifecycle records the buildpacks of an image in
+const buildMetadataLabel = "io.buildpacks.build.metadata"
+
+// DescribeImage fills in what is known of the image of a build: its digest,
+// size and layers, and the builder and buildpacks it was built with. Pushed
+// images are read from the registry, the others from the docker daemon.
+func (b *BuildService) DescribeImage(ctx context.Context, buildSpec *models.BuildSpec, artifact *common.BuildArtifact) error {
+	artifact.Digest = buildSpec.ImageDigest
+	artifact.Backend = buildSpec.Backend
+	artifact.BuilderImage = buildSpec.BuilderImage
+	artifact.Framework = buildSpec.Framework
+
+	var labels map[string]string
+	var err error
+	if common.BuildModePublishes(buildSpec.Spec.Mode) && buildSpec.ImageDigest != "" {
+		labels, err = describeRemoteImage(ctx, buildSpec, artifact)
+	} else {
+		labels, err = describeLocalImage(ctx, buildSpec, artifact)
+	}
+	if err != nil {
+		return err
+	}
+
+	artifact.Buildpacks = imageBuildpacks(labels)
+	return nil
+}
+
+// describeRemoteImage reads the size and layers of a pushed image from its manifest
+func describeRemoteImage(ctx context.Context, buildSpec *models.BuildSpec, artifact *common.BuildArtifact) (map[string]string, error) {
+	imageRegistry := imageUtils.ImageRegistry(buildSpec)
+	var opts []name.Option
+	if imageRegistry.Insecure {
+		opts = append(opts, name.Insecure)
+	}
+	ref, err := name.ParseReference(imageUtils.ImageReference(buildSpec), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference: %w", err)
+	}
+
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(&registryKeychain{registry: imageRegistry}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image from the registry: %w", err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image manifest: %w", err)
+	}
+	artifact.SizeBytes = manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		artifact.SizeBytes += layer.Size
+	}
+	artifact.Layers = len(manifest.Layers)
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image config: %w", err)
+	}
+	return cfg.Config.Labels, nil
+}
+
+// describeLocalImage reads the size and layers of an image kept in the docker daemon
+func describeLocalImage(ctx context.Context, buildSpec *models.BuildSpec, artifact *common.BuildArtifact) (map[string]string, error) {
+	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	inspect, _, err := docker.ImageInspectWithRaw(ctx, imageUtils.ImageRefs(buildSpec)[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the image: %w", err)
+	}
+	artifact.SizeBytes = inspect.Size
+	artifact.Layers = len(inspect.RootFS.Layers)
+	if inspect.Config == nil {
+		return nil, nil
+	}
+	return inspect.Config.Labels, nil
+}
+
+// imageBuildpacks returns the buildpacks recorded in the labels of a buildpacks image
+func imageBuildpacks(labels map[string]string) []common.BuildpackRef {
+	value, ok := labels[buildMetadataLabel]
+	if !ok {
+		return nil
+	}
+	var metadata struct {
+		Buildpacks []common.BuildpackRef `json:"buildpacks"`
+	}
+	if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+		return nil
+	}
+	return metadata.Buildpacks
+}
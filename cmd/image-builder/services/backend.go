@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/models"
+)
+
+// BuildBackend builds the image of an app from its source code and pushes it
+// to the registry. Build output is streamed to the logger.
+type BuildBackend interface {
+	// Name returns the BUILD_BACKEND_* value that selects the backend
+	Name() string
+	Build(ctx context.Context, buildSpec *models.BuildSpec, sourcePath string, logger common.Logger) error
+}
+
+// hasDockerfile reports whether the source code ships its own Dockerfile
+func hasDockerfile(sourcePath string) bool {
+	info, err := os.Stat(filepath.Join(sourcePath, "Dockerfile"))
+	return err == nil && !info.IsDir()
+}
+
+// selectBackend returns the backend requested for the build, or the
+// Dockerfile backend when the source code has a Dockerfile and buildpacks otherwise
+func (b *BuildService) selectBackend(buildSpec *models.BuildSpec, sourcePath string) (BuildBackend, error) {
+	switch buildSpec.Spec.Backend {
+	case common.BUILD_BACKEND_BUILDPACKS:
+		return b.buildpacks, nil
+	case common.BUILD_BACKEND_DOCKERFILE:
+		if !hasDockerfile(sourcePath) {
+			return nil, fmt.Errorf("dockerfile backend requested but the source code has no Dockerfile")
+		}
+		return b.dockerfile, nil
+	case "":
+		if hasDockerfile(sourcePath) {
+			return b.dockerfile, nil
+		}
+		return b.buildpacks, nil
+	default:
+		return nil, fmt.Errorf("unsupported build backend: %s", buildSpec.Spec.Backend)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	common "mira/cmd/common"
+	"mira/cmd/config"
+	"mira/cmd/image-builder/models"
+	imageUtils "mira/cmd/image-builder/utils"
+
+	"github.com/BurntSushi/toml"
+	buildpackClient "github.com/buildpacks/pack/pkg/client"
+	"github.com/buildpacks/pack/pkg/image"
+	"github.com/buildpacks/pack/pkg/logging"
+)
+
+// buildpacksBackend builds images with Cloud Native Buildpacks through pack
+type buildpacksBackend struct {
+	config *config.BuilderConfig
+	cache  *CacheService
+}
+
+// Name returns the name of the backend
+func (p *buildpacksBackend) Name() string {
+	return common.BUILD_BACKEND_BUILDPACKS
+}
+
+// Build builds and publishes the image with pack, recording its digest from
+// the lifecycle report. Pack reaches the project registry with its
+// credentials; images for insecure registries are built into the docker
+// daemon and pushed from there. Build-only images stay in the daemon. Cancelling ctx stops the build and removes
+// the lifecycle containers it started.
+func (p *buildpacksBackend) Build(ctx context.Context, buildSpec *models.BuildSpec, sourcePath string, natsLogger common.Logger) error {
+	logger := logging.NewLogWithWriters(natsLogger, natsLogger)
+
+	docker, err := newTrackingDockerClient()
+	if err != nil {
+		log.Printf("failed to create docker client: %v", err)
+		return err
+	}
+	defer docker.Close()
+
+	imageRegistry := imageUtils.ImageRegistry(buildSpec)
+	cliClient, err := buildpackClient.NewClient(
+		buildpackClient.WithLogger(logger),
+		buildpackClient.WithDockerClient(docker),
+		buildpackClient.WithKeychain(&registryKeychain{registry: imageRegistry}),
+	)
+	if err != nil {
+		log.Printf("failed to create pack client: %v", err)
+		return err
+	}
+
+	// Prepare build configuration
+	buildOpts, err := p.prepareBuildOptions(buildSpec, sourcePath)
+	if err != nil {
+		return err
+	}
+	reportDir, err := os.MkdirTemp("", "mira-report-")
+	if err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	defer os.RemoveAll(reportDir)
+	buildOpts.ReportDestinationDir = reportDir
+
+	sbomDir, err := os.MkdirTemp("", "mira-sbom-")
+	if err != nil {
+		return fmt.Errorf("failed to create SBOM directory: %w", err)
+	}
+	defer os.RemoveAll(sbomDir)
+	buildOpts.SBOMDestinationDir = sbomDir
+
+	buildSpec.BuilderImage = buildOpts.Builder
+	p.cache.Apply(&buildOpts, buildSpec)
+	p.cache.LogStatus(ctx, &buildOpts, buildSpec, natsLogger)
+	if len(buildOpts.Buildpacks) > 0 {
+		natsLogger.InfoWithStep("build", fmt.Sprintf("Using builder %s with buildpacks %s", buildOpts.Builder, strings.Join(buildOpts.Buildpacks, ", ")))
+	} else {
+		natsLogger.InfoWithStep("build", "Using builder "+buildOpts.Builder+" with its own buildpacks")
+	}
+
+	// Execute build
+	if err := cliClient.Build(ctx, buildOpts); err != nil {
+		log.Printf("failed to build image: %v", err)
+		if ctx.Err() != nil {
+			natsLogger.InfoWithStep("build", "Build stopped, removing lifecycle containers")
+			docker.removeContainers(natsLogger)
+			return fmt.Errorf("build stopped: %w", context.Cause(ctx))
+		}
+		return err
+	}
+
+	buildSpec.SBOM, err = collectSBOM(sbomDir)
+	if err != nil {
+		natsLogger.ErrorWithStep("sbom", fmt.Sprintf("Could not read the SBOM of the image: %v", err))
+	}
+
+	if !common.BuildModePublishes(buildSpec.Spec.Mode) {
+		natsLogger.InfoWithStep("build", "Image kept in the docker daemon of the builder, not pushing it in "+buildSpec.Spec.Mode+" mode")
+		return nil
+	}
+	if !buildOpts.Publish {
+		if err := pushImages(ctx, docker, imageRegistry, buildSpec, imageUtils.ImageRefs(buildSpec), natsLogger); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("build stopped: %w", context.Cause(ctx))
+			}
+			return err
+		}
+		return nil
+	}
+
+	digest, err := reportDigest(reportDir)
+	if err != nil {
+		natsLogger.ErrorWithStep("build", fmt.Sprintf("Could not read the image digest: %v", err))
+		return nil
+	}
+	buildSpec.ImageDigest = digest
+
+	return nil
+}
+
+// buildReport is the part of the lifecycle report.toml read by the backend
+type buildReport struct {
+	Image struct {
+		Digest string `toml:"digest"`
+	} `toml:"image"`
+}
+
+// reportDigest returns the image digest recorded in the report.toml written by the lifecycle exporter
+func reportDigest(reportDir string) (string, error) {
+	var report buildReport
+	if _, err := toml.DecodeFile(filepath.Join(reportDir, "report.toml"), &report); err != nil {
+		return "", err
+	}
+	if report.Image.Digest == "" {
+		return "", fmt.Errorf("report has no image digest")
+	}
+	return report.Image.Digest, nil
+}
+
+// prepareBuildOptions creates build options for the pack client
+func (p *buildpacksBackend) prepareBuildOptions(buildSpec *models.BuildSpec, sourcePath string) (buildpackClient.BuildOptions, error) {
+	refs := imageUtils.ImageRefs(buildSpec)
+	profile := selectBuildProfile(buildSpec, p.config)
+
+	// Profile defaults first, so that custom environment variables override them
+	env := make(map[string]string)
+	for key, value := range profile.Env {
+		env[key] = value
+	}
+	for key, value := range buildSpec.Spec.Env {
+		env[key] = value
+	}
+
+	return buildpackClient.BuildOptions{
+		AppPath:        sourcePath,
+		Builder:        profile.Builder,
+		Image:          refs[0],
+		AdditionalTags: refs[1:],
+		PullPolicy:     image.PullIfNotPresent,
+		Publish:        common.BuildModePublishes(buildSpec.Spec.Mode) && !imageUtils.ImageRegistry(buildSpec).Insecure,
+		Env:            env,
+		Buildpacks:     profile.Buildpacks,
+	}, nil
+}
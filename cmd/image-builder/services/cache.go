@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+	"time"
+
+	common "mira/cmd/common"
+	"mira/cmd/config"
+	"mira/cmd/image-builder/models"
+	imageUtils "mira/cmd/image-builder/utils"
+
+	"github.com/buildpacks/pack/pkg/cache"
+	buildpackClient "github.com/buildpacks/pack/pkg/client"
+	"github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Build cache formats
+const (
+	CacheFormatVolume = "volume" // docker volumes on the builder
+	CacheFormatImage  = "image"  // a cache image next to the app image in the registry
+	CacheFormatOff    = "off"    // every build starts from scratch
+)
+
+// cacheImageTag is the tag of cache images in the repository of the app image
+const cacheImageTag = "build-cache"
+
+// CacheService manages the named build cache of every app
+type CacheService struct {
+	config     *config.BuilderConfig
+	specCipher cipher.AEAD // opens the registry passwords sealed by the API
+}
+
+// NewCacheService creates a new cache service
+func NewCacheService(builderConfig *config.BuilderConfig) *CacheService {
+	return &CacheService{config: builderConfig, specCipher: common.NewSpecCipher()}
+}
+
+// cacheVolumeNames returns the build and launch cache volumes of an app
+func cacheVolumeNames(projectID, appName string) []string {
+	base := "mira-cache-" + projectID + "-" + appName
+	return []string{base + ".build", base + ".launch"}
+}
+
+// cacheImageName returns the cache image of an app in the registry of its project
+func cacheImageName(imageRegistry *common.ImageRegistry, projectID, appName string) string {
+	return imageRegistry.Repository(projectID, appName) + ":" + cacheImageTag
+}
+
+// format returns the cache format of builds. Pack only writes cache images
+// while publishing, which build-only builds and builds for insecure
+// registries do not, so those keep their cache in volumes.
+func (s *CacheService) format(publish bool) string {
+	if s.config.BuildCacheFormat == CacheFormatImage && !publish {
+		return CacheFormatVolume
+	}
+	return s.config.BuildCacheFormat
+}
+
+// Apply sets the cache of the app on the pack build options
+func (s *CacheService) Apply(buildOpts *buildpackClient.BuildOptions, buildSpec *models.BuildSpec) {
+	imageRegistry := imageUtils.ImageRegistry(buildSpec)
+	switch s.format(buildOpts.Publish) {
+	case CacheFormatVolume:
+		volumes := cacheVolumeNames(buildSpec.Spec.ProjectID, buildSpec.Name)
+		buildOpts.Cache = cache.CacheOpts{
+			Build:  cache.CacheInfo{Format: cache.CacheVolume, Source: volumes[0]},
+			Launch: cache.CacheInfo{Format: cache.CacheVolume, Source: volumes[1]},
+		}
+	case CacheFormatImage:
+		buildOpts.CacheImage = cacheImageName(imageRegistry, buildSpec.Spec.ProjectID, buildSpec.Name)
+	case CacheFormatOff:
+		buildOpts.ClearCache = true
+	}
+}
+
+// LogStatus logs whether the build will restore the cache of the app or start without one
+func (s *CacheService) LogStatus(ctx context.Context, buildOpts *buildpackClient.BuildOptions, buildSpec *models.BuildSpec, logger common.Logger) {
+	format := s.format(buildOpts.Publish)
+	if format == CacheFormatOff {
+		logger.InfoWithStep("cache", "Build cache disabled, building from scratch")
+		return
+	}
+
+	caches, err := s.inspect(ctx, format, imageUtils.ImageRegistry(buildSpec), buildSpec.Spec.ProjectID, buildSpec.Name, false)
+	if err != nil {
+		logger.InfoWithStep("cache", "Could not check the build cache: "+err.Error())
+		return
+	}
+
+	// The build cache decides whether dependencies have to be installed again
+	primary := caches[0]
+	if primary.Exists {
+		logger.InfoWithStep("cache", fmt.Sprintf("Build cache hit: restoring %s %s", primary.Format, primary.Name))
+	} else {
+		logger.InfoWithStep("cache", fmt.Sprintf("Build cache miss: %s %s will be created", primary.Format, primary.Name))
+	}
+}
+
+// HandleRequest answers a build cache request from the API
+func (s *CacheService) HandleRequest(request *common.BuildCacheRequest) *common.BuildCacheReply {
+	reply := &common.BuildCacheReply{BuilderID: s.config.BuilderID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	imageRegistry := request.Registry
+	if imageRegistry == nil {
+		imageRegistry = common.DefaultImageRegistry()
+	}
+	if err := imageRegistry.OpenPassword(s.specCipher); err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	format := s.format(!imageRegistry.Insecure)
+
+	var err error
+	switch request.Action {
+	case common.BUILD_CACHE_INSPECT:
+		reply.Caches, err = s.inspect(ctx, format, imageRegistry, request.ProjectID, request.AppName, true)
+	case common.BUILD_CACHE_CLEAR:
+		reply.Caches, err = s.clear(ctx, format, imageRegistry, request.ProjectID, request.AppName)
+	default:
+		err = fmt.Errorf("unknown build cache action: %s", request.Action)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// inspect describes the caches of an app. Computing volume sizes makes docker
+// walk every volume, so it is only done on request.
+func (s *CacheService) inspect(ctx context.Context, format string, imageRegistry *common.ImageRegistry, projectID, appName string, withSize bool) ([]common.BuildCache, error) {
+	if format == CacheFormatImage {
+		return []common.BuildCache{s.inspectImage(ctx, imageRegistry, cacheImageName(imageRegistry, projectID, appName))}, nil
+	}
+
+	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	sizes := make(map[string]int64)
+	if withSize {
+		usage, err := docker.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+		if err == nil {
+			for _, vol := range usage.Volumes {
+				if vol.UsageData != nil {
+					sizes[vol.Name] = vol.UsageData.Size
+				}
+			}
+		}
+	}
+
+	var caches []common.BuildCache
+	for _, volumeName := range cacheVolumeNames(projectID, appName) {
+		entry := common.BuildCache{Name: volumeName, Format: CacheFormatVolume, SizeBytes: -1}
+		vol, err := docker.VolumeInspect(ctx, volumeName)
+		switch {
+		case errdefs.IsNotFound(err):
+			entry.SizeBytes = 0
+		case err != nil:
+			entry.Error = err.Error()
+		default:
+			entry.Exists = true
+			entry.CreatedAt, _ = time.Parse(time.RFC3339, vol.CreatedAt)
+			if size, ok := sizes[volumeName]; ok {
+				entry.SizeBytes = size
+			}
+		}
+		caches = append(caches, entry)
+	}
+	return caches, nil
+}
+
+// inspectImage describes a cache image in the registry
+func (s *CacheService) inspectImage(ctx context.Context, imageRegistry *common.ImageRegistry, imageName string) common.BuildCache {
+	entry := common.BuildCache{Name: imageName, Format: CacheFormatImage, SizeBytes: -1}
+
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(&registryKeychain{registry: imageRegistry}))
+	if err != nil {
+		// Registries answer a missing tag in different ways, treat every failure as a miss
+		entry.SizeBytes = 0
+		return entry
+	}
+	entry.Exists = true
+
+	if manifest, err := img.Manifest(); err == nil {
+		entry.SizeBytes = manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			entry.SizeBytes += layer.Size
+		}
+	}
+	if cfg, err := img.ConfigFile(); err == nil {
+		entry.CreatedAt = cfg.Created.Time
+	}
+	return entry
+}
+
+// clear removes the caches of an app. Volumes used by a running build cannot be removed.
+func (s *CacheService) clear(ctx context.Context, format string, imageRegistry *common.ImageRegistry, projectID, appName string) ([]common.BuildCache, error) {
+	if format == CacheFormatImage {
+		return []common.BuildCache{s.clearImage(ctx, imageRegistry, cacheImageName(imageRegistry, projectID, appName))}, nil
+	}
+
+	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	var caches []common.BuildCache
+	for _, volumeName := range cacheVolumeNames(projectID, appName) {
+		entry := common.BuildCache{Name: volumeName, Format: CacheFormatVolume}
+		err := docker.VolumeRemove(ctx, volumeName, false)
+		switch {
+		case errdefs.IsNotFound(err):
+		case err != nil:
+			entry.Exists = true
+			entry.Error = err.Error()
+		default:
+			entry.Cleared = true
+		}
+		caches = append(caches, entry)
+	}
+	return caches, nil
+}
+
+// clearImage deletes a cache image from the registry
+func (s *CacheService) clearImage(ctx context.Context, imageRegistry *common.ImageRegistry, imageName string) common.BuildCache {
+	entry := common.BuildCache{Name: imageName, Format: CacheFormatImage}
+
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	auth := remote.WithAuthFromKeychain(&registryKeychain{registry: imageRegistry})
+	desc, err := remote.Head(ref, remote.WithContext(ctx), auth)
+	if err != nil {
+		return entry
+	}
+
+	// Registries only delete manifests by digest
+	if err := remote.Delete(ref.Context().Digest(desc.Digest.String()), remote.WithContext(ctx), auth); err != nil {
+		entry.Exists = true
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.Cleared = true
+	return entry
+}
@@ -22,12 +22,12 @@ func NewDeployService() *DeployService {
 }
 
 // DeployToCraneCloud deploys the built image to Crane Cloud
-func (d *DeployService) DeployToCraneCloud(buildSpec *models.BuildSpec, logger common.Logger) error {
+func (d *DeployService) DeployToCraneCloud(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) error {
 	logger.InfoWithStep("deploy", "Deploying image to Crane Cloud: "+buildSpec.Name)
 
 	deployConfig := imageUtils.CreateDeploymentConfig(buildSpec)
 
-	err := d.postToCraneCloud(deployConfig, buildSpec.Spec.AccessToken)
+	err := d.postToCraneCloud(ctx, deployConfig, buildSpec.Spec.AccessToken)
 	if err != nil {
 		logger.ErrorWithStep("deploy", "Error deploying image to Crane Cloud")
 		return fmt.Errorf("error deploying image to Crane Cloud: %w", err)
@@ -37,9 +37,7 @@ func (d *DeployService) DeployToCraneCloud(buildSpec *models.BuildSpec, logger c
 }
 
 // postToCraneCloud sends the deployment request to Crane Cloud API
-func (d *DeployService) postToCraneCloud(deployConfig *models.DeploymentConfig, accessToken string) error {
-	ctx := context.Background()
-
+func (d *DeployService) postToCraneCloud(ctx context.Context, deployConfig *models.DeploymentConfig, accessToken string) error {
 	// Get Crane Cloud API host from environment
 	ccApiHost := os.Getenv("CRANECLOUD_API_HOST")
 	if ccApiHost == "" {
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	common "mira/cmd/common"
+
+	buildpackClient "github.com/buildpacks/pack/pkg/client"
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// trackingDockerClient is the docker client handed to pack for a single build.
+// It records every lifecycle container pack creates so that they can be
+// removed when the build is stopped before pack gets to clean up.
+type trackingDockerClient struct {
+	*dockerClient.Client
+
+	mu           sync.Mutex
+	containerIDs []string
+}
+
+// newTrackingDockerClient creates a docker client configured the same way pack configures its own
+func newTrackingDockerClient() (*trackingDockerClient, error) {
+	cli, err := dockerClient.NewClientWithOpts(
+		dockerClient.FromEnv,
+		dockerClient.WithVersion(buildpackClient.DockerAPIVersion),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &trackingDockerClient{Client: cli}, nil
+}
+
+// ContainerCreate creates a container and remembers its ID
+func (t *trackingDockerClient) ContainerCreate(ctx context.Context, config *containertypes.Config, hostConfig *containertypes.HostConfig, networkingConfig *networktypes.NetworkingConfig, platform *specs.Platform, containerName string) (containertypes.CreateResponse, error) {
+	resp, err := t.Client.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+	if err == nil {
+		t.mu.Lock()
+		t.containerIDs = append(t.containerIDs, resp.ID)
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+// removeContainers kills and removes every lifecycle container created for the build that is still around
+func (t *trackingDockerClient) removeContainers(logger common.Logger) {
+	t.mu.Lock()
+	ids := append([]string(nil), t.containerIDs...)
+	t.mu.Unlock()
+
+	// The build context is already done, so use a fresh one for the cleanup
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, id := range ids {
+		err := t.Client.ContainerRemove(ctx, id, containertypes.RemoveOptions{Force: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			logger.ErrorWithStep("build", "Failed to remove lifecycle container "+shortID(id)+": "+err.Error())
+			continue
+		}
+		if err == nil {
+			logger.InfoWithStep("build", "Removed lifecycle container "+shortID(id))
+		}
+	}
+}
+
+// shortID returns the abbreviated form of a container ID
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/models"
+	imageUtils "mira/cmd/image-builder/utils"
+
+	"github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// dockerfileBackend builds images from the Dockerfile shipped with the
+// source code on the local Docker daemon
+type dockerfileBackend struct{}
+
+// Name returns the name of the backend
+func (d *dockerfileBackend) Name() string {
+	return common.BUILD_BACKEND_DOCKERFILE
+}
+
+// Build builds the image from the Dockerfile and pushes it to the registry of
+// the project with each of its tags, recording the digest reported by the
+// registry. Build env variables are passed as build args only when the
+// Dockerfile declares them with ARG, as build args are kept in the image
+// history. Cancelling ctx stops the build on the daemon.
+func (d *dockerfileBackend) Build(ctx context.Context, buildSpec *models.BuildSpec, sourcePath string, logger common.Logger) error {
+	refs := imageUtils.ImageRefs(buildSpec)
+
+	docker, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	buildContext, err := dockerBuildContext(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to create build context: %w", err)
+	}
+	defer buildContext.Close()
+
+	buildArgs, err := dockerBuildArgs(filepath.Join(sourcePath, "Dockerfile"), buildSpec.Spec.Env)
+	if err != nil {
+		return fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+	if len(buildArgs) > 0 {
+		names := make([]string, 0, len(buildArgs))
+		for name := range buildArgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		logger.InfoWithStep("build", "Passing env values as build args: "+strings.Join(names, ", "))
+	}
+
+	logger.InfoWithStep("build", "Building image "+refs[0]+" from Dockerfile")
+	resp, err := docker.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:        refs,
+		Dockerfile:  "Dockerfile",
+		BuildArgs:   buildArgs,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return d.buildError(ctx, fmt.Errorf("failed to start docker build: %w", err))
+	}
+	err = streamDockerOutput(resp.Body, logger, nil)
+	resp.Body.Close()
+	if err != nil {
+		return d.buildError(ctx, fmt.Errorf("docker build failed: %w", err))
+	}
+
+	if !common.BuildModePublishes(buildSpec.Spec.Mode) {
+		logger.InfoWithStep("build", "Image kept in the docker daemon of the builder, not pushing it in "+buildSpec.Spec.Mode+" mode")
+		return nil
+	}
+	if err := pushImages(ctx, docker, imageUtils.ImageRegistry(buildSpec), buildSpec, refs, logger); err != nil {
+		return d.buildError(ctx, err)
+	}
+
+	return nil
+}
+
+// buildError reports a stopped build the same way the buildpacks backend does
+func (d *dockerfileBackend) buildError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("build stopped: %w", context.Cause(ctx))
+	}
+	return err
+}
+
+// dockerBuildArgs returns the env values whose names the Dockerfile at path
+// declares with ARG, in any stage
+func dockerBuildArgs(path string, env map[string]string) (map[string]*string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result, err := parser.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	buildArgs := make(map[string]*string)
+	for _, instruction := range result.AST.Children {
+		if !strings.EqualFold(instruction.Value, "arg") {
+			continue
+		}
+		for arg := instruction.Next; arg != nil; arg = arg.Next {
+			name, _, _ := strings.Cut(arg.Value, "=")
+			if value, ok := env[name]; ok {
+				buildArgs[name] = &value
+			}
+		}
+	}
+	return buildArgs, nil
+}
+
+// dockerBuildContext tars the source code, leaving out the files listed in .dockerignore
+func dockerBuildContext(sourcePath string) (io.ReadCloser, error) {
+	var excludes []string
+	f, err := os.Open(filepath.Join(sourcePath, ".dockerignore"))
+	if err == nil {
+		excludes, err = ignorefile.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return archive.TarWithOptions(sourcePath, &archive.TarOptions{ExcludePatterns: excludes})
+}
+
+// streamDockerOutput writes the progress messages of a docker build or push
+// to the logger and returns the error reported by the daemon, if any. aux,
+// when set, receives the auxiliary messages such as the pushed digest.
+func streamDockerOutput(in io.Reader, logger common.Logger, aux func(jsonmessage.JSONMessage)) error {
+	return jsonmessage.DisplayJSONMessagesStream(in, logger, 0, false, aux)
+}
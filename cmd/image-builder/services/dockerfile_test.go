@@ -0,0 +1,51 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerBuildArgs(t *testing.T) {
+	dockerfile := `FROM node:20 AS build
+arg NODE_ENV=production
+ARG API_URL \
+    APP_VERSION=1.0
+RUN npm ci
+
+FROM nginx
+# ARG IN_COMMENT
+ARG PUBLIC_PATH
+ENV DB_PASSWORD=unset
+`
+	env := map[string]string{
+		"NODE_ENV":    "staging",
+		"API_URL":     "https://api.example.com",
+		"PUBLIC_PATH": "/app",
+		"IN_COMMENT":  "x",
+		"DB_PASSWORD": "secret",
+	}
+	want := map[string]string{
+		"NODE_ENV":    "staging",
+		"API_URL":     "https://api.example.com",
+		"PUBLIC_PATH": "/app",
+	}
+
+	path := filepath.Join(t.TempDir(), "Dockerfile")
+	if err := os.WriteFile(path, []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buildArgs, err := dockerBuildArgs(path, env)
+	if err != nil {
+		t.Fatalf("dockerBuildArgs() error = %v", err)
+	}
+	if len(buildArgs) != len(want) {
+		t.Errorf("dockerBuildArgs() passed %d build args, want %d", len(buildArgs), len(want))
+	}
+	for name, value := range want {
+		if got := buildArgs[name]; got == nil || *got != value {
+			t.Errorf("build arg %s = %v, want %q", name, got, value)
+		}
+	}
+}
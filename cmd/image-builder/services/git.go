@@ -1,103 +1,397 @@
 package services
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	common "mira/cmd/common"
+	"mira/cmd/config"
 	"mira/cmd/image-builder/models"
 	fileUtils "mira/cmd/utils"
 
 	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/go-resty/resty/v2"
 )
 
 // GitService handles git operations and file downloads
-type GitService struct{}
+type GitService struct {
+	cloneDepth int
+	mirrors    *mirrorCache     // nil when the mirror cache is disabled
+	blobs      common.BlobStore // source archives uploaded to the API
+}
 
 // NewGitService creates a new git service
-func NewGitService() *GitService {
-	return &GitService{}
+func NewGitService(builderConfig *config.BuilderConfig, blobs common.BlobStore) *GitService {
+	service := &GitService{cloneDepth: builderConfig.GitCloneDepth, blobs: blobs}
+	if builderConfig.GitCacheMaxSizeMB > 0 && builderConfig.GitCacheDir != "" {
+		service.mirrors = newMirrorCache(builderConfig.GitCacheDir, int64(builderConfig.GitCacheMaxSizeMB)<<20)
+	}
+	return service
 }
 
-// CloneRepository clones a git repository using improved logic from utils
-func (g *GitService) CloneRepository(buildSpec *models.BuildSpec, logger common.Logger) (string, error) {
-	destPath := "/usr/local/crane/git/" + buildSpec.Name
+// ErrGitRefNotFound is returned when the branch, tag or commit requested for a build does not exist
+var ErrGitRefNotFound = errors.New("git ref not found")
 
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-		return "", fmt.Errorf("failed to create destination directory: %w", err)
+// CloneRepository fetches the git repository of a build and checks out the
+// requested branch, tag or commit, the default branch when none is set. The
+// files come from the mirror cache when it is enabled, from a shallow clone
+// otherwise. The remote refs are listed first with the credentials of the
+// build, so missing refs fail fast and cached mirrors are only used by
+// builds that can read the repository.
+func (g *GitService) CloneRepository(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) (string, error) {
+	destPath := sourceDir(buildSpec)
+
+	gitRepo := buildSpec.Spec.Source.GitRepo
+	auth := &http.BasicAuth{
+		Username: gitRepo.Username,
+		Password: gitRepo.Password,
+	}
+
+	refs, err := listRemoteRefs(ctx, gitRepo.URL, auth)
+	if err != nil {
+		return "", err
+	}
+	refName := requestedRef(gitRepo)
+	if refName != "" {
+		logger.InfoWithStep("clone", "Checking out "+describeRef(refName))
+		if _, ok := refs[refName]; !ok {
+			return "", fmt.Errorf("%w: %s does not exist in the repository", ErrGitRefNotFound, describeRef(refName))
+		}
+	}
+
+	record := func(repo *git.Repository, hash plumbing.Hash) {
+		recordCommit(repo, hash, buildSpec, logger)
+	}
+	if g.mirrors != nil {
+		if refName == "" && gitRepo.Revision == "" {
+			if refName = defaultBranch(refs); refName == "" {
+				return "", fmt.Errorf("could not find the default branch of the repository")
+			}
+		}
+		err = g.mirrors.checkout(ctx, gitRepo, auth, refName, destPath, record, logger)
+	} else {
+		var repo *git.Repository
+		var hash plumbing.Hash
+		if repo, hash, err = g.shallowClone(ctx, gitRepo, auth, refName, destPath, logger); err == nil {
+			record(repo, hash)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	appPath, err := appRoot(destPath, buildSpec.Spec.RootDir, "clone", logger)
+	if err != nil {
+		return "", err
+	}
+	detectSource(buildSpec, appPath, "clone", logger)
+
+	return appPath, nil
+}
+
+// shallowClone clones a single branch or tag of the repository with the
+// configured history depth. Builds of a given commit clone the full history,
+// of every branch unless one was requested, since the commit may be anywhere.
+func (g *GitService) shallowClone(ctx context.Context, gitRepo common.ImageBuilderGitRepo, auth transport.AuthMethod, refName plumbing.ReferenceName, destPath string, logger common.Logger) (*git.Repository, plumbing.Hash, error) {
+	cloneOpts := &git.CloneOptions{
+		URL:           gitRepo.URL,
+		Auth:          auth,
+		ReferenceName: refName,
+		SingleBranch:  true,
+		Depth:         g.cloneDepth,
+	}
+	if gitRepo.Revision != "" {
+		cloneOpts.Depth = 0
+		cloneOpts.SingleBranch = refName != ""
+		cloneOpts.NoCheckout = true
+	}
+
+	logger.InfoWithStep("clone", "Cloning git repository")
+	repo, err := git.PlainCloneContext(ctx, destPath, false, cloneOpts)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("error cloning git repository: %w", err)
+	}
+
+	if gitRepo.Revision != "" {
+		logger.InfoWithStep("clone", "Checking out commit "+gitRepo.Revision)
+		hash, err := resolveCommit(repo, gitRepo.Revision)
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		worktree, err := repo.Worktree()
+		if err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("error opening git worktree: %w", err)
+		}
+		if err := worktree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("error checking out commit %s: %w", gitRepo.Revision, err)
+		}
+		return repo, hash, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("could not resolve the checked out commit: %w", err)
+	}
+	return repo, head.Hash(), nil
+}
+
+// requestedRef returns the branch or tag requested for a build, empty for the default branch
+func requestedRef(gitRepo common.ImageBuilderGitRepo) plumbing.ReferenceName {
+	switch {
+	case gitRepo.Tag != "":
+		return plumbing.NewTagReferenceName(gitRepo.Tag)
+	case gitRepo.Branch != "":
+		return plumbing.NewBranchReferenceName(gitRepo.Branch)
+	}
+	return ""
+}
+
+// describeRef names a branch or tag reference for the build logs
+func describeRef(refName plumbing.ReferenceName) string {
+	if refName.IsTag() {
+		return "tag " + refName.Short()
+	}
+	return "branch " + refName.Short()
+}
+
+// listRemoteRefs lists the refs of the remote repository by name
+func listRemoteRefs(ctx context.Context, url string, auth transport.AuthMethod) (map[plumbing.ReferenceName]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitConfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	list, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("error listing git repository refs: %w", err)
+	}
+	refs := make(map[plumbing.ReferenceName]*plumbing.Reference, len(list))
+	for _, ref := range list {
+		refs[ref.Name()] = ref
 	}
+	return refs, nil
+}
 
-	// Remove existing directory if it exists
-	if _, err := os.Stat(destPath); err == nil {
-		if err := os.RemoveAll(destPath); err != nil {
-			return "", fmt.Errorf("failed to remove existing directory: %w", err)
+// defaultBranch returns the branch HEAD of the remote repository points at
+func defaultBranch(refs map[plumbing.ReferenceName]*plumbing.Reference) plumbing.ReferenceName {
+	head, ok := refs[plumbing.HEAD]
+	if !ok {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+	// Servers that do not advertise the HEAD symref only give its commit
+	for _, name := range []plumbing.ReferenceName{plumbing.Main, plumbing.Master} {
+		if ref, ok := refs[name]; ok && ref.Hash() == head.Hash() {
+			return name
+		}
+	}
+	for name, ref := range refs {
+		if name.IsBranch() && ref.Hash() == head.Hash() {
+			return name
 		}
 	}
+	return ""
+}
 
-	fmt.Println("Cloning git repository")
-	_, err := git.PlainClone(destPath, false, &git.CloneOptions{
-		URL: buildSpec.Spec.Source.GitRepo.URL,
-		Auth: &http.BasicAuth{
-			Username: buildSpec.Spec.Source.GitRepo.Username,
-			Password: buildSpec.Spec.Source.GitRepo.Password,
-		},
+// resolveCommit resolves a full or abbreviated commit SHA
+func resolveCommit(repo *git.Repository, revision string) (plumbing.Hash, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err == nil {
+		_, err = repo.CommitObject(*hash)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("%w: commit %s does not exist in the repository", ErrGitRefNotFound, revision)
+	}
+	return *hash, nil
+}
+
+// semverTagPattern matches git tags that are semantic versions usable as image tags
+var semverTagPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?$`)
+
+// recordCommit records the checked out commit and the semantic version tag
+// pointing at it on the build spec, for the image tag policy
+func recordCommit(repo *git.Repository, hash plumbing.Hash, buildSpec *models.BuildSpec, logger common.Logger) {
+	buildSpec.CommitSHA = hash.String()
+	logger.InfoWithStep("clone", "Checked out commit "+buildSpec.CommitSHA)
+
+	tags, err := repo.Tags()
+	if err != nil {
+		return
+	}
+	defer tags.Close()
+	_ = tags.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if !semverTagPattern.MatchString(name) {
+			return nil
+		}
+		// Annotated tags point at a tag object rather than the commit
+		tagged := ref.Hash()
+		if tag, err := repo.TagObject(tagged); err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				return nil
+			}
+			tagged = commit.Hash
+		}
+		if tagged != hash {
+			return nil
+		}
+		buildSpec.GitTag = name
+		logger.InfoWithStep("clone", "Commit is tagged "+name)
+		return storer.ErrStop
 	})
+}
+
+// appRoot returns the directory of the app within the source code, the
+// source root when rootDir is empty. Directories outside the source code,
+// including through symlinks, are rejected.
+func appRoot(sourcePath, rootDir, step string, logger common.Logger) (string, error) {
+	if rootDir == "" || filepath.Clean(rootDir) == "." {
+		return sourcePath, nil
+	}
+	appPath := filepath.Join(sourcePath, rootDir)
+	if filepath.IsAbs(filepath.Clean(rootDir)) || !isPathSafe(appPath, sourcePath) {
+		return "", fmt.Errorf("root directory %s is outside the source code", rootDir)
+	}
+
+	resolvedSource, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve source directory: %w", err)
+	}
+	resolvedApp, err := filepath.EvalSymlinks(appPath)
 	if err != nil {
-		return "", fmt.Errorf("error cloning git repository: %w", err)
+		return "", fmt.Errorf("root directory %s does not exist in the source code", rootDir)
+	}
+	if !isPathSafe(resolvedApp, resolvedSource) {
+		return "", fmt.Errorf("root directory %s is outside the source code", rootDir)
+	}
+	if info, err := os.Stat(resolvedApp); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("root directory %s is not a directory", rootDir)
+	}
+
+	logger.InfoWithStep(step, "Building the app in "+rootDir)
+	return resolvedApp, nil
+}
+
+// detectSource records the language and main framework of the source code on the build spec
+func detectSource(buildSpec *models.BuildSpec, sourcePath, step string, logger common.Logger) {
+	buildSpec.Language = fileUtils.DetectLanguage(sourcePath)
+	if buildSpec.Language != "" {
+		logger.InfoWithStep(step, "Detected language: "+buildSpec.Language)
 	}
 
 	// Detect frameworks using existing utility
-	frameworks, err := fileUtils.DetectJavaScriptFrameworksLocal(destPath)
+	frameworks, err := fileUtils.DetectJavaScriptFrameworksLocal(sourcePath)
 	if err != nil {
-		logger.InfoWithStep("clone", "Framework detection failed, using defaults")
+		logger.InfoWithStep(step, "Framework detection failed, using defaults")
 	} else {
-		logger.InfoWithStep("clone", fmt.Sprintf("Detected frameworks: %v", frameworks))
+		logger.InfoWithStep(step, fmt.Sprintf("Detected frameworks: %v", frameworks))
+		buildSpec.Framework = primaryFramework(frameworks)
 	}
+}
 
-	return destPath, nil
+// primaryFramework returns the detected framework with the highest confidence
+func primaryFramework(frameworks []fileUtils.FrameworkInfo) string {
+	primary := ""
+	best := 0
+	for _, framework := range frameworks {
+		if score := fileUtils.GetConfidenceScore(framework.Confidence); score > best {
+			primary = framework.Name
+			best = score
+		}
+	}
+	return primary
 }
 
-// HandleFileSource handles file download and extraction
-func (g *GitService) HandleFileSource(buildSpec *models.BuildSpec, logger common.Logger) (string, error) {
-	// Download the file
-	fmt.Println("Downloading file")
-	err := g.downloadFile(buildSpec)
+// HandleFileSource fetches the source archive of a build, uploaded to the API
+// or downloaded from a URL, and extracts it
+func (g *GitService) HandleFileSource(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) (string, error) {
+	blobFile := buildSpec.Spec.Source.BlobFile
+	if blobFile.Key != "" {
+		logger.InfoWithStep("download", "Fetching uploaded source archive")
+		if err := g.fetchBlob(buildSpec); err != nil {
+			return "", fmt.Errorf("fetching uploaded archive failed: %w", err)
+		}
+	} else {
+		logger.InfoWithStep("download", "Downloading source archive")
+		if err := g.downloadFile(ctx, buildSpec); err != nil {
+			return "", fmt.Errorf("download failed: %w", err)
+		}
+	}
+
+	format := blobFile.Format
+	if format == "" {
+		format = common.SourceArchiveFormat(blobFile.Source)
+	}
+	var destPath string
+	var err error
+	switch format {
+	case common.SOURCE_ARCHIVE_TAR_GZ:
+		logger.InfoWithStep("download", "Extracting tar.gz archive")
+		destPath, err = g.untarFile(buildSpec)
+	case common.SOURCE_ARCHIVE_ZIP, "":
+		logger.InfoWithStep("download", "Extracting zip archive")
+		destPath, err = g.unzipFile(buildSpec)
+	default:
+		return "", fmt.Errorf("unsupported source archive format: %s", format)
+	}
 	if err != nil {
-		return "", fmt.Errorf("download failed: %w", err)
+		return "", fmt.Errorf("extracting archive failed: %w", err)
 	}
-	fmt.Println("Downloaded file")
 
-	// Unzip the file
-	fmt.Println("Unzipping file")
-	destPath, err := g.unzipFile(buildSpec)
+	appPath, err := appRoot(destPath, buildSpec.Spec.RootDir, "download", logger)
 	if err != nil {
-		return "", fmt.Errorf("unzip failed: %w", err)
+		return "", err
 	}
-	fmt.Println("Unzipped file")
+	detectSource(buildSpec, appPath, "download", logger)
 
-	return destPath, nil
+	return appPath, nil
 }
 
-// downloadFile downloads a zip file from a URL
-func (g *GitService) downloadFile(buildSpec *models.BuildSpec) error {
-	client := resty.New()
+// fetchBlob copies the archive uploaded to the API from the blob store into the workspace
+func (g *GitService) fetchBlob(buildSpec *models.BuildSpec) error {
+	if g.blobs == nil {
+		return fmt.Errorf("no blob store is configured")
+	}
+	blob, err := g.blobs.Open(buildSpec.Spec.Source.BlobFile.Key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
 
-	// Ensure destination directory exists
-	destDir := "/usr/local/crane/blobs"
-	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
+	out, err := os.Create(sourceArchive(buildSpec))
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, blob); err != nil {
+		return fmt.Errorf("failed to copy archive: %w", err)
 	}
+	return nil
+}
 
-	filePath := filepath.Join(destDir, buildSpec.Name+".zip")
+// downloadFile downloads a source archive from a URL
+func (g *GitService) downloadFile(ctx context.Context, buildSpec *models.BuildSpec) error {
+	client := resty.New()
 
 	resp, err := client.R().
-		SetOutput(filePath).
+		SetContext(ctx).
+		SetOutput(sourceArchive(buildSpec)).
 		Get(buildSpec.Spec.Source.BlobFile.Source)
 	if err != nil {
 		return fmt.Errorf("error downloading file: %w", err)
@@ -110,8 +404,8 @@ func (g *GitService) downloadFile(buildSpec *models.BuildSpec) error {
 
 // unzipFile unzips a zip file with better error handling
 func (g *GitService) unzipFile(buildSpec *models.BuildSpec) (string, error) {
-	saveto := "/usr/local/crane/zip/" + buildSpec.Name
-	zipFile := "/usr/local/crane/blobs/" + buildSpec.Name + ".zip"
+	saveto := sourceDir(buildSpec)
+	zipFile := sourceArchive(buildSpec)
 
 	// Ensure destination directory exists
 	if err := os.MkdirAll(saveto, os.ModePerm); err != nil {
@@ -167,6 +461,69 @@ func (g *GitService) unzipFile(buildSpec *models.BuildSpec) (string, error) {
 	return saveto, nil
 }
 
+// untarFile extracts a gzipped tar archive. Entries leaving the destination
+// are skipped, and so are links, which could otherwise lead later entries
+// outside of it, as with zip archives.
+func (g *GitService) untarFile(buildSpec *models.BuildSpec) (string, error) {
+	saveto := sourceDir(buildSpec)
+
+	// Ensure destination directory exists
+	if err := os.MkdirAll(saveto, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	archive, err := os.Open(sourceArchive(buildSpec))
+	if err != nil {
+		return "", fmt.Errorf("error opening archive: %w", err)
+	}
+	defer archive.Close()
+
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		return "", fmt.Errorf("error opening gzip stream: %w", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading archive: %w", err)
+		}
+
+		destPath := filepath.Join(saveto, header.Name)
+		if !isPathSafe(destPath, saveto) {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+				return "", fmt.Errorf("error creating directory: %w", err)
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+				return "", fmt.Errorf("error creating parent directory: %w", err)
+			}
+			destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return "", fmt.Errorf("error creating file: %w", err)
+			}
+			_, err = io.Copy(destFile, tarReader)
+			destFile.Close()
+			if err != nil {
+				return "", fmt.Errorf("error copying file: %w", err)
+			}
+		}
+	}
+
+	return saveto, nil
+}
+
 // isPathSafe checks if the path is safe (prevents zip slip attacks)
 func isPathSafe(destPath, baseDir string) bool {
 	cleanBase := filepath.Clean(baseDir)
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mira/cmd/image-builder/models"
+)
+
+// archiveEntry is a file, directory or symlink written into a test archive
+type archiveEntry struct {
+	name     string
+	body     string
+	dir      bool
+	linkname string
+}
+
+// newArchiveBuild returns a build whose workspace lies within a parent
+// directory, so files escaping the workspace can be looked for
+func newArchiveBuild(t *testing.T) (*models.BuildSpec, string) {
+	t.Helper()
+	parent := t.TempDir()
+	workspace := filepath.Join(parent, "workspace")
+	if err := os.MkdirAll(workspace, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	return &models.BuildSpec{Name: "app", Workspace: workspace}, parent
+}
+
+func writeTarGz(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		switch {
+		case entry.dir:
+			header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		case entry.linkname != "":
+			header = &tar.Header{Name: entry.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: entry.linkname}
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tarWriter.Write([]byte(entry.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zipWriter := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		body := entry.body
+		switch {
+		case entry.dir:
+			header.SetMode(os.ModeDir | 0755)
+		case entry.linkname != "":
+			header.SetMode(os.ModeSymlink | 0777)
+			body = entry.linkname
+		default:
+			header.SetMode(0644)
+		}
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// archiveCases are shared by the tar and zip extraction tests. want lists
+// the files expected in the source directory with their contents; links
+// only need to leave nothing outside of the workspace, so their cases have
+// none and may fail to extract.
+var archiveCases = []struct {
+	name    string
+	entries []archiveEntry
+	want    map[string]string
+}{
+	{
+		name: "regular files and directories",
+		entries: []archiveEntry{
+			{name: "app/", dir: true},
+			{name: "app/package.json", body: "{}"},
+			{name: "README.md", body: "readme"},
+		},
+		want: map[string]string{"app/package.json": "{}", "README.md": "readme"},
+	},
+	{
+		name: "parent directory traversal",
+		entries: []archiveEntry{
+			{name: "../escaped", body: "evil"},
+			{name: "app/../../escaped", body: "evil"},
+			{name: "index.js", body: "ok"},
+		},
+		want: map[string]string{"index.js": "ok"},
+	},
+	{
+		name: "absolute path",
+		entries: []archiveEntry{
+			{name: "/escaped", body: "inside"},
+		},
+		want: map[string]string{"escaped": "inside"},
+	},
+	{
+		name: "symlink out of the workspace",
+		entries: []archiveEntry{
+			{name: "link", linkname: "../.."},
+			{name: "link/escaped", body: "evil"},
+		},
+	},
+	{
+		name: "chained symlinks",
+		entries: []archiveEntry{
+			{name: "a/", dir: true},
+			{name: "a/b", linkname: ".."},
+			{name: "c", linkname: "a/b/../.."},
+			{name: "c/", dir: true},
+			{name: "c/escaped", body: "evil"},
+		},
+	},
+}
+
+// extractedFiles returns the regular files of the source directory with
+// their contents, failing on any symlink
+func extractedFiles(t *testing.T, saveto string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(saveto, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(saveto, path)
+		if info.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("symlink %s was extracted", rel)
+		}
+		if info.Mode().IsRegular() {
+			body, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = string(body)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// checkExtracted checks an extraction against the wanted files and that
+// nothing was written next to the workspace
+func checkExtracted(t *testing.T, buildSpec *models.BuildSpec, parent string, want map[string]string, err error) {
+	t.Helper()
+	entries, readErr := os.ReadDir(parent)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "workspace" {
+			t.Errorf("%s was written outside of the workspace", entry.Name())
+		}
+	}
+
+	if want == nil {
+		extractedFiles(t, sourceDir(buildSpec))
+		return
+	}
+	if err != nil {
+		t.Fatalf("extraction error = %v", err)
+	}
+	got := extractedFiles(t, sourceDir(buildSpec))
+	if len(got) != len(want) {
+		t.Errorf("extracted files = %v, want %v", got, want)
+	}
+	for name, body := range want {
+		if got[name] != body {
+			t.Errorf("%s = %q, want %q", name, got[name], body)
+		}
+	}
+}
+
+func TestUntarFile(t *testing.T) {
+	for _, tc := range archiveCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buildSpec, parent := newArchiveBuild(t)
+			writeTarGz(t, sourceArchive(buildSpec), tc.entries)
+
+			_, err := (&GitService{}).untarFile(buildSpec)
+			checkExtracted(t, buildSpec, parent, tc.want, err)
+		})
+	}
+}
+
+func TestUnzipFile(t *testing.T) {
+	for _, tc := range archiveCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buildSpec, parent := newArchiveBuild(t)
+			writeZip(t, sourceArchive(buildSpec), tc.entries)
+
+			_, err := (&GitService{}).unzipFile(buildSpec)
+			checkExtracted(t, buildSpec, parent, tc.want, err)
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	common "mira/cmd/common"
+
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// mirrorRefSpecs are fetched into the cached mirrors for builds of a commit
+// without a branch, as the commit may be on any branch or tag of the remote
+var mirrorRefSpecs = []gitConfig.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// mirrorCache keeps a bare mirror of each git repository the builder clones,
+// so later builds only fetch the new objects. Each build fetches only the
+// branch or tag it needs, so a mirror holds the refs that were built. Builds
+// get a worktree exported from the mirror. Least recently used mirrors are
+// evicted once the cache grows past its size limit.
+type mirrorCache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // serializes the builds using a mirror
+	inUse map[string]int         // builds using or waiting for a mirror, which is never evicted
+}
+
+func newMirrorCache(dir string, maxBytes int64) *mirrorCache {
+	return &mirrorCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		locks:    make(map[string]*sync.Mutex),
+		inUse:    make(map[string]int),
+	}
+}
+
+// mirrorPath returns where the mirror of a repository is kept
+func (c *mirrorCache) mirrorPath(url string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(strings.TrimSpace(url), "/")))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8])+".git")
+}
+
+// acquire locks the mirror at path for a build
+func (c *mirrorCache) acquire(path string) {
+	c.mu.Lock()
+	lock, ok := c.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[path] = lock
+	}
+	c.inUse[path]++
+	c.mu.Unlock()
+
+	lock.Lock()
+}
+
+// release unlocks the mirror at path
+func (c *mirrorCache) release(path string) {
+	c.mu.Lock()
+	c.locks[path].Unlock()
+	if c.inUse[path]--; c.inUse[path] == 0 {
+		delete(c.inUse, path)
+		delete(c.locks, path)
+	}
+	c.mu.Unlock()
+}
+
+// checkout updates the mirror of a repository and exports the requested
+// commit, or the head of refName, into destPath. record is called with the
+// mirror and the exported commit while the mirror is still held, so it cannot
+// be evicted under it.
+func (c *mirrorCache) checkout(ctx context.Context, gitRepo common.ImageBuilderGitRepo, auth transport.AuthMethod, refName plumbing.ReferenceName, destPath string, record func(*git.Repository, plumbing.Hash), logger common.Logger) error {
+	path := c.mirrorPath(gitRepo.URL)
+	c.acquire(path)
+	repo, hash, err := c.fetch(ctx, path, gitRepo, auth, refName, logger)
+	if err == nil {
+		err = exportTree(repo, hash, destPath)
+	}
+	if err == nil {
+		record(repo, hash)
+	}
+	c.release(path)
+	if err != nil {
+		return err
+	}
+
+	c.evict()
+	return nil
+}
+
+// fetch brings the mirror at path up to date with the remote, creating it
+// when missing, and resolves the commit to build
+func (c *mirrorCache) fetch(ctx context.Context, path string, gitRepo common.ImageBuilderGitRepo, auth transport.AuthMethod, refName plumbing.ReferenceName, logger common.Logger) (*git.Repository, plumbing.Hash, error) {
+	repo, err := git.PlainOpen(path)
+	if err == nil {
+		logger.InfoWithStep("clone", "Updating the cached mirror of the repository")
+	} else {
+		if !errors.Is(err, git.ErrRepositoryNotExists) {
+			// A mirror left broken by an interrupted fetch is created again
+			log.Printf("Recreating git mirror %s: %v", path, err)
+			if err := os.RemoveAll(path); err != nil {
+				return nil, plumbing.ZeroHash, fmt.Errorf("failed to remove broken git mirror: %w", err)
+			}
+		}
+		logger.InfoWithStep("clone", "Creating a cached mirror of the repository")
+		if repo, err = initMirror(path, gitRepo.URL); err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+	}
+
+	refSpecs := mirrorRefSpecs
+	if refName != "" {
+		refSpecs = []gitConfig.RefSpec{gitConfig.RefSpec("+" + refName.String() + ":" + refName.String())}
+	}
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RemoteURL:  gitRepo.URL,
+		RefSpecs:   refSpecs,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+		Prune:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, plumbing.ZeroHash, fmt.Errorf("error fetching git repository: %w", err)
+	}
+
+	// The modification time of a mirror orders the evictions
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	if gitRepo.Revision != "" {
+		logger.InfoWithStep("clone", "Checking out commit "+gitRepo.Revision)
+		hash, err := resolveCommit(repo, gitRepo.Revision)
+		return repo, hash, err
+	}
+
+	ref, err := repo.Reference(refName, true)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("%w: %s does not exist in the repository", ErrGitRefNotFound, describeRef(refName))
+	}
+	hash := ref.Hash()
+	// Annotated tags point at a tag object rather than the commit
+	if tag, err := repo.TagObject(hash); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return nil, plumbing.ZeroHash, fmt.Errorf("error resolving %s: %w", describeRef(refName), err)
+		}
+		hash = commit.Hash
+	}
+	return repo, hash, nil
+}
+
+// initMirror creates an empty bare mirror of a repository at path
+func initMirror(path, url string) (*git.Repository, error) {
+	repo, err := git.PlainInit(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create git mirror: %w", err)
+	}
+	_, err = repo.CreateRemote(&gitConfig.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{url},
+		Fetch: mirrorRefSpecs,
+	})
+	if err != nil {
+		os.RemoveAll(path)
+		return nil, fmt.Errorf("failed to create git mirror: %w", err)
+	}
+	return repo, nil
+}
+
+// exportTree writes the files of a commit into destPath
+func exportTree(repo *git.Repository, hash plumbing.Hash, destPath string) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("error reading commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("error reading the tree of commit %s: %w", hash, err)
+	}
+
+	if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	return tree.Files().ForEach(func(file *object.File) error {
+		path := filepath.Join(destPath, file.Name)
+		if !isPathSafe(path, destPath) {
+			return fmt.Errorf("invalid file path in repository: %s", file.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		if file.Mode == filemode.Symlink {
+			target, err := file.Contents()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", file.Name, err)
+			}
+			return os.Symlink(target, path)
+		}
+
+		perm := os.FileMode(0644)
+		if file.Mode == filemode.Executable {
+			perm = 0755
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		defer reader.Close()
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		defer out.Close()
+		if _, err := io.Copy(out, reader); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+		return nil
+	})
+}
+
+// cachedMirror is a mirror of the cache, as seen by evict
+type cachedMirror struct {
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+// evict removes the least recently used mirrors until the cache fits its
+// size limit. Mirrors in use by a build are kept.
+func (c *mirrorCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+
+	var mirrors []cachedMirror
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".git") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		mirror := cachedMirror{path: filepath.Join(c.dir, entry.Name()), lastUse: info.ModTime()}
+		mirror.size = dirSize(mirror.path)
+		total += mirror.size
+		mirrors = append(mirrors, mirror)
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].lastUse.Before(mirrors[j].lastUse)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, mirror := range mirrors {
+		if total <= c.maxBytes {
+			break
+		}
+		if c.inUse[mirror.path] > 0 {
+			continue
+		}
+		if err := os.RemoveAll(mirror.path); err != nil {
+			log.Printf("Failed to evict git mirror %s: %v", mirror.path, err)
+			continue
+		}
+		log.Printf("Evicted git mirror %s (%d bytes)", mirror.path, mirror.size)
+		total -= mirror.size
+	}
+}
+
+// dirSize returns the size of the files under path
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	common "mira/cmd/common"
+	"mira/cmd/config"
+	"mira/cmd/image-builder/models"
+	fileUtils "mira/cmd/utils"
+)
+
+// Builder images used by the build profiles
+const (
+	paketoBuilder = "paketobuildpacks/builder-jammy-base"
+	herokuBuilder = "heroku/builder:24"
+)
+
+// buildProfile describes how apps are built with buildpacks
+type buildProfile struct {
+	Builder    string
+	Buildpacks []string
+	// Env holds defaults, request env variables take precedence
+	Env map[string]string
+}
+
+// languageProfiles maps a detected language to the way its apps are built
+var languageProfiles = map[string]buildProfile{
+	fileUtils.LanguageNode: {
+		Builder:    herokuBuilder,
+		Buildpacks: []string{"heroku/nodejs"},
+	},
+	fileUtils.LanguagePython: {
+		Builder:    paketoBuilder,
+		Buildpacks: []string{"paketo-buildpacks/python"},
+		Env:        map[string]string{"BP_CPYTHON_VERSION": "3.*"},
+	},
+	fileUtils.LanguageGo: {
+		Builder:    paketoBuilder,
+		Buildpacks: []string{"paketo-buildpacks/go"},
+		Env:        map[string]string{"CGO_ENABLED": "0"},
+	},
+	fileUtils.LanguageJava: {
+		Builder:    paketoBuilder,
+		Buildpacks: []string{"paketo-buildpacks/java"},
+		Env:        map[string]string{"BP_JVM_VERSION": "17"},
+	},
+	fileUtils.LanguagePHP: {
+		Builder:    paketoBuilder,
+		Buildpacks: []string{"paketo-buildpacks/php"},
+		Env:        map[string]string{"BP_PHP_SERVER": "httpd"},
+	},
+	fileUtils.LanguageRuby: {
+		Builder:    paketoBuilder,
+		Buildpacks: []string{"paketo-buildpacks/ruby"},
+		Env:        map[string]string{"BUNDLE_WITHOUT": "development:test"},
+	},
+}
+
+// frameworkProfiles override the Node profile for server-side rendered frameworks
+var frameworkProfiles = map[string]buildProfile{
+	"next.js": {
+		Builder:    herokuBuilder,
+		Buildpacks: []string{"heroku/nodejs"},
+		Env:        map[string]string{"NEXT_TELEMETRY_DISABLED": "1"},
+	},
+	"nuxt.js": {
+		Builder:    herokuBuilder,
+		Buildpacks: []string{"heroku/nodejs"},
+		Env:        map[string]string{"NUXT_TELEMETRY_DISABLED": "1"},
+	},
+}
+
+// staticSiteProfile builds Node apps without server-side rendering into a
+// static site served by httpd
+var staticSiteProfile = buildProfile{
+	Builder:    paketoBuilder,
+	Buildpacks: []string{"paketo-buildpacks/web-servers"},
+	Env: map[string]string{
+		"BP_WEB_SERVER":                      "httpd",
+		"BP_WEB_SERVER_FORCE_HTTPS_REDIRECT": "false",
+		// Enable SPA support for React apps
+		"BP_WEB_SERVER_ENABLE_PUSH_STATE": "true",
+		"NODE_ENV":                        "production",
+	},
+}
+
+// defaultStartCommand starts server-side rendered apps built with the Mira builder
+const defaultStartCommand = "npm start"
+
+// miraProfile builds Node apps with the Mira builder. Its buildpack reads the
+// build settings from the BUILD_COMMAND, OUTPUT_DIR, APP_TYPE and START_COMMAND env variables.
+func miraProfile(buildSpec *models.BuildSpec, builderImage string) buildProfile {
+	appType := "static"
+	startCommand := ""
+	if buildSpec.Spec.SSR {
+		appType = "ssr"
+		startCommand = buildSpec.Spec.StartCommand
+		if startCommand == "" {
+			startCommand = defaultStartCommand
+		}
+	}
+
+	return buildProfile{
+		Builder: builderImage,
+		// The builder runs its own buildpack order
+		Buildpacks: nil,
+		Env: map[string]string{
+			"BUILD_COMMAND": buildSpec.Spec.BuildCommand,
+			"OUTPUT_DIR":    buildSpec.Spec.OutputDir,
+			"APP_TYPE":      appType,
+			"START_COMMAND": startCommand,
+		},
+	}
+}
+
+// builderStrategy returns the builder strategy of a build. The Mira builder
+// only knows Node apps, so a global mira default does not apply to other languages.
+func builderStrategy(buildSpec *models.BuildSpec, builderConfig *config.BuilderConfig) string {
+	if buildSpec.Spec.BuilderStrategy != "" {
+		return buildSpec.Spec.BuilderStrategy
+	}
+	if builderConfig.BuilderStrategy == common.BUILDER_STRATEGY_MIRA &&
+		buildSpec.Language != "" && buildSpec.Language != fileUtils.LanguageNode {
+		return common.BUILDER_STRATEGY_LANGUAGE
+	}
+	return builderConfig.BuilderStrategy
+}
+
+// selectBuildProfile returns the profile for the builder strategy of a build.
+// With the language strategy the profile is chosen by the language and
+// framework of the source code, unknown languages are built as Node apps.
+// The builder image and buildpacks of the request take precedence over the profile.
+func selectBuildProfile(buildSpec *models.BuildSpec, builderConfig *config.BuilderConfig) buildProfile {
+	language := buildSpec.Language
+	if language == "" {
+		language = fileUtils.LanguageNode
+	}
+
+	var profile buildProfile
+	switch {
+	case builderStrategy(buildSpec, builderConfig) == common.BUILDER_STRATEGY_MIRA:
+		profile = miraProfile(buildSpec, builderConfig.MiraBuilderImage)
+	case language == fileUtils.LanguageNode && !buildSpec.Spec.SSR:
+		profile = staticSiteProfile
+		profile.Env = map[string]string{
+			"BP_NODE_RUN_SCRIPTS": buildSpec.Spec.BuildCommand,
+			"BP_WEB_SERVER_ROOT":  buildSpec.Spec.OutputDir,
+		}
+		for key, value := range staticSiteProfile.Env {
+			profile.Env[key] = value
+		}
+	case language == fileUtils.LanguageNode:
+		profile = languageProfiles[language]
+		if frameworkProfile, ok := frameworkProfiles[buildSpec.Framework]; ok {
+			profile = frameworkProfile
+		}
+	default:
+		profile = languageProfiles[language]
+	}
+
+	if buildSpec.Spec.BuilderImage != "" {
+		profile.Builder = buildSpec.Spec.BuilderImage
+	}
+	if len(buildSpec.Spec.Buildpacks) > 0 {
+		profile.Buildpacks = buildSpec.Spec.Buildpacks
+	}
+	return profile
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/models"
+
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// registryKeychain hands the credentials of the project registry to pack and
+// the registry clients. Other registries, such as the one serving the
+// builder image, are resolved from the docker config of the builder.
+type registryKeychain struct {
+	registry *common.ImageRegistry
+}
+
+// Resolve returns the authenticator for a registry
+func (k *registryKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	if k.registry.HasCredentials() && resource.RegistryStr() == k.registry.RegistryHost() {
+		return &authn.Basic{Username: k.registry.Username, Password: k.registry.Password}, nil
+	}
+	return authn.DefaultKeychain.Resolve(resource)
+}
+
+// registryAuth returns the encoded credentials the docker daemon pushes to
+// the registry with, empty when the registry has no credentials
+func registryAuth(imageRegistry *common.ImageRegistry) (string, error) {
+	if !imageRegistry.HasCredentials() {
+		return "", nil
+	}
+	auth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      imageRegistry.Username,
+		Password:      imageRegistry.Password,
+		ServerAddress: imageRegistry.RegistryHost(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode registry credentials: %w", err)
+	}
+	return auth, nil
+}
+
+// pushImages pushes the tags of an image from the docker daemon to the
+// registry and records the digest the registry reports on the build spec
+func pushImages(ctx context.Context, docker dockerClient.APIClient, imageRegistry *common.ImageRegistry, buildSpec *models.BuildSpec, refs []string, logger common.Logger) error {
+	auth, err := registryAuth(imageRegistry)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		logger.InfoWithStep("build", "Pushing image "+ref)
+		push, err := docker.ImagePush(ctx, ref, imagetypes.PushOptions{RegistryAuth: auth})
+		if err != nil {
+			return fmt.Errorf("failed to push image: %w", err)
+		}
+		err = streamDockerOutput(push, logger, func(msg jsonmessage.JSONMessage) {
+			if digest := pushedDigest(msg); digest != "" {
+				buildSpec.ImageDigest = digest
+			}
+		})
+		push.Close()
+		if err != nil {
+			return fmt.Errorf("failed to push image: %w", err)
+		}
+	}
+	if buildSpec.ImageDigest == "" {
+		logger.ErrorWithStep("build", "Could not read the image digest from the registry")
+	}
+	return nil
+}
+
+// pushedDigest returns the image digest carried by the aux message the
+// daemon sends once a push is done, empty for other messages
+func pushedDigest(msg jsonmessage.JSONMessage) string {
+	if msg.Aux == nil {
+		return ""
+	}
+	var result struct {
+		Digest string `json:"Digest"`
+	}
+	if err := json.Unmarshal(*msg.Aux, &result); err != nil {
+		return ""
+	}
+	return result.Digest
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	common "mira/cmd/common"
+)
+
+// sbomFiles maps the SBOM file names written by buildpacks to their format
+var sbomFiles = map[string]string{
+	"sbom.cdx.json":  common.SBOM_FORMAT_CYCLONEDX,
+	"sbom.spdx.json": common.SBOM_FORMAT_SPDX,
+	"sbom.syft.json": common.SBOM_FORMAT_SYFT,
+}
+
+// collectSBOM reads the SBOM documents pack copied out of the lifecycle.
+// Only the launch SBOMs are kept, they describe what ends up in the image.
+// Their layout is launch/<buildpack>/[<layer>/]sbom.<format>.json, buildpack
+// IDs having their slashes replaced by underscores.
+func collectSBOM(sbomDir string) ([]common.SBOMDocument, error) {
+	var documents []common.SBOMDocument
+	err := filepath.WalkDir(sbomDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		format, ok := sbomFiles[entry.Name()]
+		if !ok {
+			return nil
+		}
+
+		rel, err := filepath.Rel(sbomDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		launch := -1
+		for i, part := range parts {
+			if part == "launch" {
+				launch = i
+				break
+			}
+		}
+		if launch < 0 || launch+1 >= len(parts) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(content) {
+			return nil
+		}
+		documents = append(documents, common.SBOMDocument{
+			Buildpack: strings.ReplaceAll(parts[launch+1], "_", "/"),
+			Layer:     strings.Join(parts[launch+2:], "/"),
+			Format:    format,
+			Content:   content,
+		})
+		return nil
+	})
+	return documents, err
+}
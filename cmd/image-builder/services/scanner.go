@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	common "mira/cmd/common"
+	"mira/cmd/config"
+	"mira/cmd/image-builder/models"
+)
+
+// Vulnerability scanners images can be scanned with
+const (
+	ScannerVulnDB = "vulndb" // matches the SBOM against an offline vulnerability database
+	ScannerOff    = "off"    // images are not scanned
+)
+
+// Scanner finds the known vulnerabilities of a built image
+type Scanner interface {
+	// Name returns the Scanner* value that selects the scanner
+	Name() string
+	Scan(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) (*common.ScanReport, error)
+}
+
+// ScanService scans built images and applies the security policy of their project
+type ScanService struct {
+	scanner Scanner
+}
+
+// NewScanService creates a new scan service with the scanner of the builder config
+func NewScanService(builderConfig *config.BuilderConfig) *ScanService {
+	var scanner Scanner
+	switch builderConfig.Scanner {
+	case ScannerVulnDB:
+		scanner = newVulnDBScanner(builderConfig.VulnDBPath)
+	case ScannerOff, "":
+	default:
+		log.Printf("Unsupported scanner %s, images will not be scanned", builderConfig.Scanner)
+	}
+	return &ScanService{scanner: scanner}
+}
+
+// PolicyViolationError is returned when the security policy of a project fails a build
+type PolicyViolationError struct {
+	FailOn     string
+	Violations []common.Vulnerability
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("security policy blocks %s vulnerabilities, found %d", e.FailOn, len(e.Violations))
+}
+
+// ScanImage scans the image of a build and applies the security policy of its
+// project. The report is returned along with a PolicyViolationError when the
+// policy fails the build. It is nil when no scanner is configured.
+func (s *ScanService) ScanImage(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) (*common.ScanReport, error) {
+	policy := buildSpec.Spec.SecurityPolicy
+	if s.scanner == nil {
+		if policy != nil && policy.FailOn != "" {
+			logger.InfoWithStep("security", "WARNING: the project has a security policy but no scanner is configured, the image was not scanned")
+		}
+		return nil, nil
+	}
+
+	logger.InfoWithStep("security", "Scanning the image with the "+s.scanner.Name()+" scanner")
+	report, err := s.scanner.Scan(ctx, buildSpec, logger)
+	if err != nil {
+		// A policy cannot be enforced without a scan
+		if policy != nil && policy.FailOn != "" {
+			logger.ErrorWithStep("security", fmt.Sprintf("Vulnerability scan failed: %v", err))
+			return nil, fmt.Errorf("vulnerability scan failed: %w", err)
+		}
+		logger.InfoWithStep("security", fmt.Sprintf("WARNING: vulnerability scan failed: %v", err))
+		return nil, nil
+	}
+
+	report.Scanner = s.scanner.Name()
+	report.ScannedAt = time.Now()
+	report.Counts = make(map[string]int)
+	for _, vulnerability := range report.Vulnerabilities {
+		report.Counts[vulnerability.Severity]++
+	}
+	logger.InfoWithStep("security", fmt.Sprintf("Checked %d packages, found %s", report.Packages, report.CountsSummary()))
+
+	violations := policy.Violations(report)
+	if len(violations) == 0 {
+		return report, nil
+	}
+
+	report.Blocked = true
+	var found []string
+	for _, vulnerability := range violations {
+		found = append(found, vulnerability.String())
+	}
+	logger.ErrorWithStep("security", fmt.Sprintf("BLOCKED: the security policy of the project fails builds with %s or more severe vulnerabilities: %s",
+		policy.FailOn, strings.Join(found, "; ")))
+	return report, &PolicyViolationError{FailOn: policy.FailOn, Violations: violations}
+}
@@ -27,7 +27,7 @@ type AppExistsResponse struct {
 }
 
 // ValidateAppName checks if an app with the given name already exists in the project
-func (v *ValidationService) ValidateAppName(buildSpec *models.BuildSpec, logger common.Logger) error {
+func (v *ValidationService) ValidateAppName(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) error {
 	logger.InfoWithStep("validation", "Validating app name: "+buildSpec.Name)
 
 	// Get Crane Cloud API host from environment
@@ -41,7 +41,6 @@ func (v *ValidationService) ValidateAppName(buildSpec *models.BuildSpec, logger
 	client.SetHeader("Content-Type", "application/json")
 
 	// Make API call to check if app exists
-	ctx := context.Background()
 	resp, err := client.R().
 		SetContext(ctx).
 		SetAuthToken(buildSpec.Spec.AccessToken).
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/models"
+)
+
+// vulnDBEntry is an advisory of the offline vulnerability database. A package
+// version is affected when it is listed in Versions, or when it is at least
+// Introduced and below Fixed.
+type vulnDBEntry struct {
+	ID        string   `json:"id"`
+	Package   string   `json:"package"`
+	Ecosystem string   `json:"ecosystem,omitempty"` // purl type, e.g. npm or pypi, any when empty
+	Severity  string   `json:"severity"`
+	Summary   string   `json:"summary,omitempty"`
+	Versions  []string `json:"versions,omitempty"`
+	// Introduced is the first affected version, every version before Fixed when empty
+	Introduced string `json:"introduced,omitempty"`
+	Fixed      string `json:"fixed,omitempty"`
+}
+
+// vulnDBFile is the layout of the offline vulnerability database file
+type vulnDBFile struct {
+	UpdatedAt       time.Time     `json:"updated_at"`
+	Vulnerabilities []vulnDBEntry `json:"vulnerabilities"`
+}
+
+// vulnDBScanner matches the SBOM of an image against an offline vulnerability
+// database file. The file is reloaded when it changes, so it can be updated
+// without restarting the builder.
+type vulnDBScanner struct {
+	path string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	advisory map[string][]vulnDBEntry // by lowercased package name
+}
+
+func newVulnDBScanner(path string) *vulnDBScanner {
+	return &vulnDBScanner{path: path}
+}
+
+func (s *vulnDBScanner) Name() string {
+	return ScannerVulnDB
+}
+
+func (s *vulnDBScanner) Scan(ctx context.Context, buildSpec *models.BuildSpec, logger common.Logger) (*common.ScanReport, error) {
+	if len(buildSpec.SBOM) == 0 {
+		return nil, fmt.Errorf("the image has no SBOM to scan")
+	}
+	advisories, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	packages := common.SBOMPackages(buildSpec.SBOM)
+	report := &common.ScanReport{Packages: len(packages)}
+	for _, pkg := range packages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, entry := range advisories[strings.ToLower(pkg.Name)] {
+			if !entry.affects(pkg) {
+				continue
+			}
+			report.Vulnerabilities = append(report.Vulnerabilities, common.Vulnerability{
+				ID:           entry.ID,
+				Package:      pkg.Name,
+				Version:      pkg.Version,
+				Severity:     common.NormalizeSeverity(entry.Severity),
+				FixedVersion: entry.Fixed,
+				Summary:      entry.Summary,
+			})
+		}
+	}
+	return report, nil
+}
+
+// load returns the advisories of the database by package, reading the file again when it changed
+func (s *vulnDBScanner) load() (map[string][]vulnDBEntry, error) {
+	if s.path == "" {
+		return nil, fmt.Errorf("MIRA_VULN_DB_PATH is not set")
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vulnerability database: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.advisory != nil && info.ModTime().Equal(s.modTime) {
+		return s.advisory, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vulnerability database: %w", err)
+	}
+	var db vulnDBFile
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, fmt.Errorf("failed to parse vulnerability database: %w", err)
+	}
+
+	advisory := make(map[string][]vulnDBEntry)
+	for _, entry := range db.Vulnerabilities {
+		name := strings.ToLower(entry.Package)
+		advisory[name] = append(advisory[name], entry)
+	}
+	s.advisory = advisory
+	s.modTime = info.ModTime()
+	return advisory, nil
+}
+
+// affects reports whether the advisory applies to a package
+func (e *vulnDBEntry) affects(pkg common.SBOMPackage) bool {
+	if e.Ecosystem != "" && pkg.PURL != "" && !strings.HasPrefix(pkg.PURL, "pkg:"+e.Ecosystem+"/") {
+		return false
+	}
+	if pkg.Version == "" {
+		return false
+	}
+	for _, version := range e.Versions {
+		if compareVersions(version, pkg.Version) == 0 {
+			return true
+		}
+	}
+	if e.Fixed == "" && e.Introduced == "" {
+		return false
+	}
+	if e.Introduced != "" && compareVersions(pkg.Version, e.Introduced) < 0 {
+		return false
+	}
+	return e.Fixed == "" || compareVersions(pkg.Version, e.Fixed) < 0
+}
+
+// compareVersions compares dotted versions part by part, numerically where
+// both parts are numbers. Pre-release and build suffixes are compared as text.
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' || r == '+' })
+	}
+	partsA, partsB := split(a), split(b)
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var partA, partB string
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+		numA, errA := strconv.Atoi(partA)
+		numB, errB := strconv.Atoi(partB)
+		switch {
+		case errA == nil && errB == nil:
+			if numA != numB {
+				if numA < numB {
+					return -1
+				}
+				return 1
+			}
+		case partA == "":
+			// 1.0 is after 1.0-rc1 but 1.0 equals 1.0.0
+			if errB == nil && numB == 0 {
+				continue
+			}
+			if errB != nil {
+				return 1
+			}
+			return -1
+		case partB == "":
+			if errA == nil && numA == 0 {
+				continue
+			}
+			if errA != nil {
+				return -1
+			}
+			return 1
+		default:
+			if c := strings.Compare(partA, partB); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"testing"
+
+	common "mira/cmd/common"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc1", 1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.2", -1},
+		{"1.0.1", "1.0.0-rc1", 1},
+	}
+
+	for _, tc := range tests {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestVulnDBEntryAffects(t *testing.T) {
+	npm := func(version string) common.SBOMPackage {
+		return common.SBOMPackage{Name: "lodash", Version: version, PURL: "pkg:npm/lodash@" + version}
+	}
+
+	tests := []struct {
+		name  string
+		entry vulnDBEntry
+		pkg   common.SBOMPackage
+		want  bool
+	}{
+		{
+			name:  "listed version",
+			entry: vulnDBEntry{Versions: []string{"4.17.20", "4.17.19"}},
+			pkg:   npm("4.17.19"),
+			want:  true,
+		},
+		{
+			name:  "unlisted version",
+			entry: vulnDBEntry{Versions: []string{"4.17.20"}},
+			pkg:   npm("4.17.21"),
+			want:  false,
+		},
+		{
+			name:  "below the fixed version",
+			entry: vulnDBEntry{Fixed: "4.17.21"},
+			pkg:   npm("4.17.20"),
+			want:  true,
+		},
+		{
+			name:  "fixed version",
+			entry: vulnDBEntry{Fixed: "4.17.21"},
+			pkg:   npm("4.17.21"),
+			want:  false,
+		},
+		{
+			name:  "before the introduced version",
+			entry: vulnDBEntry{Introduced: "4.0.0", Fixed: "4.17.21"},
+			pkg:   npm("3.10.1"),
+			want:  false,
+		},
+		{
+			name:  "introduced version",
+			entry: vulnDBEntry{Introduced: "4.0.0", Fixed: "4.17.21"},
+			pkg:   npm("4.0.0"),
+			want:  true,
+		},
+		{
+			name:  "introduced and never fixed",
+			entry: vulnDBEntry{Introduced: "4.0.0"},
+			pkg:   npm("5.0.0"),
+			want:  true,
+		},
+		{
+			name:  "no affected versions",
+			entry: vulnDBEntry{},
+			pkg:   npm("4.17.20"),
+			want:  false,
+		},
+		{
+			name:  "other ecosystem",
+			entry: vulnDBEntry{Ecosystem: "pypi", Fixed: "4.17.21"},
+			pkg:   npm("4.17.20"),
+			want:  false,
+		},
+		{
+			name:  "matching ecosystem",
+			entry: vulnDBEntry{Ecosystem: "npm", Fixed: "4.17.21"},
+			pkg:   npm("4.17.20"),
+			want:  true,
+		},
+		{
+			name:  "package without purl matches any ecosystem",
+			entry: vulnDBEntry{Ecosystem: "npm", Fixed: "4.17.21"},
+			pkg:   common.SBOMPackage{Name: "lodash", Version: "4.17.20"},
+			want:  true,
+		},
+		{
+			name:  "package without version",
+			entry: vulnDBEntry{Fixed: "4.17.21"},
+			pkg:   common.SBOMPackage{Name: "lodash"},
+			want:  false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.entry.affects(tc.pkg); got != tc.want {
+				t.Errorf("affects(%s@%s) = %v, want %v", tc.pkg.Name, tc.pkg.Version, got, tc.want)
+			}
+		})
+	}
+}
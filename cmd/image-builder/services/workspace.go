@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"mira/cmd/config"
+	"mira/cmd/image-builder/models"
+)
+
+// workspaceLeaseFile is touched in the workspace of a running build, so
+// workspaces left behind by a crashed builder can be told apart
+const workspaceLeaseFile = ".lease"
+
+// Workspace leases are renewed every workspaceLeaseRenewal while the build
+// runs. Workspaces whose lease is older than workspaceLeaseTTL are orphaned.
+const (
+	workspaceLeaseRenewal = time.Minute
+	workspaceLeaseTTL     = 5 * time.Minute
+)
+
+// WorkspaceService gives each build its own directory under the workspace
+// root, keyed by build ID, so concurrent builds of apps with the same name
+// never share their source
+type WorkspaceService struct {
+	root string
+}
+
+// NewWorkspaceService creates a new workspace service
+func NewWorkspaceService(builderConfig *config.BuilderConfig) *WorkspaceService {
+	return &WorkspaceService{root: builderConfig.WorkspaceDir}
+}
+
+// Workspace is the directory a build keeps its source code in while it runs
+type Workspace struct {
+	Dir string
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Create creates an empty workspace for a build. A workspace left by an
+// earlier delivery of the build is replaced.
+func (s *WorkspaceService) Create(buildID string) (*Workspace, error) {
+	if buildID == "" || buildID == "." || buildID == ".." || filepath.Base(buildID) != buildID {
+		return nil, fmt.Errorf("invalid build ID for a workspace: %q", buildID)
+	}
+
+	dir := filepath.Join(s.root, buildID)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove old workspace: %w", err)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	workspace := &Workspace{Dir: dir, stop: make(chan struct{})}
+	if err := workspace.renew(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create workspace lease: %w", err)
+	}
+	go workspace.keepLease()
+	return workspace, nil
+}
+
+// renew touches the lease of the workspace
+func (w *Workspace) renew() error {
+	path := filepath.Join(w.Dir, workspaceLeaseFile)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, nil, 0644)
+}
+
+// keepLease renews the lease of the workspace until it is removed
+func (w *Workspace) keepLease() {
+	ticker := time.NewTicker(workspaceLeaseRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.renew(); err != nil {
+				log.Printf("Failed to renew the lease of workspace %s: %v", w.Dir, err)
+			}
+		}
+	}
+}
+
+// Remove deletes the workspace and everything the build left in it
+func (w *Workspace) Remove() {
+	w.once.Do(func() {
+		close(w.stop)
+		if err := os.RemoveAll(w.Dir); err != nil {
+			log.Printf("Failed to remove workspace %s: %v", w.Dir, err)
+		}
+	})
+}
+
+// SweepOrphans removes the workspaces whose lease expired, left behind by
+// builders that died mid-build. Workspaces of builds running on other
+// builders sharing the root keep their lease fresh and are left alone.
+func (s *WorkspaceService) SweepOrphans() {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read workspace root %s: %v", s.root, err)
+		}
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.root, entry.Name())
+		lease, err := os.Stat(filepath.Join(dir, workspaceLeaseFile))
+		if err == nil && time.Since(lease.ModTime()) < workspaceLeaseTTL {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to remove orphaned workspace %s: %v", dir, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("Removed %d orphaned build workspaces from %s", removed, s.root)
+	}
+}
+
+// sourceDir returns where the source code of a build is checked out or extracted
+func sourceDir(buildSpec *models.BuildSpec) string {
+	return filepath.Join(buildSpec.Workspace, "source")
+}
+
+// sourceArchive returns where the source archive of a build is downloaded to
+func sourceArchive(buildSpec *models.BuildSpec) string {
+	return filepath.Join(buildSpec.Workspace, "source-archive")
+}
@@ -1,7 +1,8 @@
 package utils
 
 import (
-	"os"
+	"log"
+	"strings"
 
 	common "mira/cmd/common"
 	"mira/cmd/image-builder/models"
@@ -10,21 +11,99 @@ import (
 // ConvertToBuildSpec converts a common.BuildRequest to internal BuildSpec
 func ConvertToBuildSpec(buildReq *common.BuildRequest) *models.BuildSpec {
 	return &models.BuildSpec{
-		Name:   buildReq.Name,
-		Spec:   buildReq.Spec,
-		Source: buildReq.Spec.Source,
+		Name:    buildReq.Name,
+		Spec:    buildReq.Spec,
+		Source:  buildReq.Spec.Source,
+		BuildID: buildReq.ID,
 	}
 }
 
-// GenerateImageName creates a standardized image name
+// Entries of the image tag policy
+const (
+	TagPolicySHA7    = "sha7"    // first seven characters of the commit SHA
+	TagPolicyBuildID = "buildId" // ID of the build
+	TagPolicySemver  = "semver"  // git tag of the commit when it is a semantic version, without the leading v
+	TagPolicyLatest  = "latest"
+)
+
+// ImageTags returns the tags the image of a build is pushed with, following
+// the policy order. Entries that do not apply, such as sha7 for uploaded
+// source code or semver for untagged commits, are skipped; the image is
+// tagged latest when none apply.
+func ImageTags(buildSpec *models.BuildSpec, policy []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, entry := range policy {
+		switch entry {
+		case TagPolicySHA7:
+			if len(buildSpec.CommitSHA) >= 7 {
+				add(buildSpec.CommitSHA[:7])
+			}
+		case TagPolicyBuildID:
+			add(buildSpec.BuildID)
+		case TagPolicySemver:
+			add(strings.TrimPrefix(buildSpec.GitTag, "v"))
+		case TagPolicyLatest:
+			add("latest")
+		default:
+			log.Printf("Ignoring unknown image tag policy entry: %s", entry)
+		}
+	}
+
+	if len(tags) == 0 {
+		tags = []string{"latest"}
+	}
+	return tags
+}
+
+// ImageRefs returns the image name with each of the tags of the build
+func ImageRefs(buildSpec *models.BuildSpec) []string {
+	imageName := GenerateImageName(buildSpec)
+	tags := buildSpec.ImageTags
+	if len(tags) == 0 {
+		tags = []string{"latest"}
+	}
+
+	refs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		refs = append(refs, imageName+":"+tag)
+	}
+	return refs
+}
+
+// ImageReference returns the reference of the built image that gets
+// deployed: pinned to its digest when the backend reported one, the first
+// tag otherwise
+func ImageReference(buildSpec *models.BuildSpec) string {
+	if buildSpec.ImageDigest != "" {
+		return GenerateImageName(buildSpec) + "@" + buildSpec.ImageDigest
+	}
+	return ImageRefs(buildSpec)[0]
+}
+
+// ImageRegistry returns the registry the image of a build is pushed to
+func ImageRegistry(buildSpec *models.BuildSpec) *common.ImageRegistry {
+	if buildSpec.Spec.Registry != nil {
+		return buildSpec.Spec.Registry
+	}
+	return common.DefaultImageRegistry()
+}
+
+// GenerateImageName creates a standardized image name in the registry of the project, without a tag
 func GenerateImageName(buildSpec *models.BuildSpec) string {
-	dockerUsername := os.Getenv("DOCKERHUB_USERNAME")
-	return dockerUsername + "/" + buildSpec.Spec.ProjectID + buildSpec.Name
+	return ImageRegistry(buildSpec).Repository(buildSpec.Spec.ProjectID, buildSpec.Name)
 }
 
 // CreateDeploymentConfig creates deployment configuration
 func CreateDeploymentConfig(buildSpec *models.BuildSpec) *models.DeploymentConfig {
-	imageName := GenerateImageName(buildSpec)
+	imageName := ImageReference(buildSpec)
 
 	envVars := map[string]string{
 		"PORT": "8080",
@@ -41,7 +120,7 @@ func CreateDeploymentConfig(buildSpec *models.BuildSpec) *models.DeploymentConfi
 		Image:        imageName,
 		Name:         buildSpec.Name,
 		ProjectID:    buildSpec.Spec.ProjectID,
-		PrivateImage: false,
+		PrivateImage: ImageRegistry(buildSpec).IsPrivate(),
 		Replicas:     1,
 		Port:         8080,
 		EnvVars:      envVars,
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	common "mira/cmd/common"
+	"mira/cmd/image-builder/models"
+)
+
+func TestImageTags(t *testing.T) {
+	defaultPolicy := []string{TagPolicySHA7, TagPolicyBuildID, TagPolicySemver, TagPolicyLatest}
+
+	tests := []struct {
+		name      string
+		buildSpec models.BuildSpec
+		policy    []string
+		want      []string
+	}{
+		{
+			name:      "every entry applies",
+			buildSpec: models.BuildSpec{BuildID: "build-1", CommitSHA: "0123456789abcdef", GitTag: "v1.2.3"},
+			policy:    defaultPolicy,
+			want:      []string{"0123456", "build-1", "1.2.3", "latest"},
+		},
+		{
+			name:      "untagged commit skips semver",
+			buildSpec: models.BuildSpec{BuildID: "build-1", CommitSHA: "0123456789abcdef"},
+			policy:    defaultPolicy,
+			want:      []string{"0123456", "build-1", "latest"},
+		},
+		{
+			name:      "uploaded source skips sha7",
+			buildSpec: models.BuildSpec{BuildID: "build-1"},
+			policy:    defaultPolicy,
+			want:      []string{"build-1", "latest"},
+		},
+		{
+			name:      "policy order is kept",
+			buildSpec: models.BuildSpec{BuildID: "build-1", CommitSHA: "0123456789abcdef", GitTag: "2.0.0"},
+			policy:    []string{TagPolicySemver, TagPolicySHA7},
+			want:      []string{"2.0.0", "0123456"},
+		},
+		{
+			name:      "duplicates and unknown entries are dropped",
+			buildSpec: models.BuildSpec{BuildID: "build-1"},
+			policy:    []string{TagPolicyBuildID, "branch", TagPolicyBuildID},
+			want:      []string{"build-1"},
+		},
+		{
+			name:      "latest when nothing applies",
+			buildSpec: models.BuildSpec{CommitSHA: "0123"},
+			policy:    []string{TagPolicySHA7, TagPolicySemver},
+			want:      []string{"latest"},
+		},
+		{
+			name:      "latest with an empty policy",
+			buildSpec: models.BuildSpec{BuildID: "build-1"},
+			want:      []string{"latest"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ImageTags(&tc.buildSpec, tc.policy); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ImageTags() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImageReference(t *testing.T) {
+	buildSpec := &models.BuildSpec{
+		Name:      "web",
+		ImageTags: []string{"0123456", "latest"},
+	}
+	buildSpec.Spec.ProjectID = "project"
+	buildSpec.Spec.Registry = &common.ImageRegistry{Type: common.REGISTRY_GHCR, Namespace: "crane"}
+
+	if got, want := ImageReference(buildSpec), "ghcr.io/crane/projectweb:0123456"; got != want {
+		t.Errorf("ImageReference() without digest = %q, want %q", got, want)
+	}
+
+	buildSpec.ImageDigest = "sha256:abc"
+	if got, want := ImageReference(buildSpec), "ghcr.io/crane/projectweb@sha256:abc"; got != want {
+		t.Errorf("ImageReference() with digest = %q, want %q", got, want)
+	}
+}
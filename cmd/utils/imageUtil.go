@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	common "mira/cmd/common"
 	internalsUtils "mira/internals/utils"
 )
 
@@ -29,13 +30,13 @@ func ValidateImageConfig(config *ImageConfig) error {
 	return nil
 }
 
-// GenerateDockerImageName creates a standardized Docker image name
-func GenerateDockerImageName(projectID, imageName string) string {
-	dockerUsername := os.Getenv("DOCKERHUB_USERNAME")
-	if dockerUsername == "" {
-		dockerUsername = "default"
+// GenerateDockerImageName creates a standardized Docker image name in the
+// registry of the project, the default Docker Hub account when registry is nil
+func GenerateDockerImageName(registry *common.ImageRegistry, projectID, imageName string) string {
+	if registry == nil {
+		registry = common.DefaultImageRegistry()
 	}
-	return fmt.Sprintf("%s/%s%s", dockerUsername, projectID, imageName)
+	return registry.Repository(projectID, imageName)
 }
 
 // CleanupBuildArtifacts removes temporary build files
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Languages the image builder knows how to build
+const (
+	LanguageNode   = "node"
+	LanguagePython = "python"
+	LanguageGo     = "go"
+	LanguageJava   = "java"
+	LanguagePHP    = "php"
+	LanguageRuby   = "ruby"
+)
+
+// languageMarker maps a file found at the root of a repository to its language
+type languageMarker struct {
+	File     string
+	Language string
+}
+
+// languageMarkers are checked in order. Node comes last because Ruby, PHP and
+// Python apps often ship a package.json for their frontend assets.
+var languageMarkers = []languageMarker{
+	{"go.mod", LanguageGo},
+	{"pom.xml", LanguageJava},
+	{"build.gradle", LanguageJava},
+	{"build.gradle.kts", LanguageJava},
+	{"composer.json", LanguagePHP},
+	{"Gemfile", LanguageRuby},
+	{"requirements.txt", LanguagePython},
+	{"pyproject.toml", LanguagePython},
+	{"Pipfile", LanguagePython},
+	{"setup.py", LanguagePython},
+	{"package.json", LanguageNode},
+}
+
+// DetectLanguage returns the main language of a local repository, or an empty string if unknown
+func DetectLanguage(repoDir string) string {
+	for _, marker := range languageMarkers {
+		if _, err := os.Stat(filepath.Join(repoDir, marker.File)); err == nil {
+			return marker.Language
+		}
+	}
+
+	if isJS, _ := IsJavaScriptProjectLocal(repoDir); isJS {
+		return LanguageNode
+	}
+	return ""
+}